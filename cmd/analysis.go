@@ -23,17 +23,38 @@ package cmd
 
 import (
 	"fmt"
+	"os"
+	"strings"
 
 	"github.com/spf13/cobra"
+	"github.com/sredog/sre/pkg/toolparse"
 )
 
+var analysisTool string
+
 // analysisCmd represents the analysis command
 var analysisCmd = &cobra.Command{
 	Use:   "analysis",
 	Short: "Auto-find issues in the output of various standard tools",
-	Long:  ``,
+	Long: fmt.Sprintf(`Reads the output of a standard tool from stdin, recognises the tool, parses
+the output and looks for issues, e.g.:
+
+free | sre analysis
+iostat -x 1 3 | sre analysis
+sre analysis < vmstat-pasted-from-a-ticket.txt
+
+Supported tools: %s`, toolparse.ToolNames()),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return fmt.Errorf("analysis not implemented")
+		if f, ok := cmd.InOrStdin().(*os.File); ok {
+			if stat, err := f.Stat(); err == nil && stat.Mode()&os.ModeCharDevice != 0 {
+				return fmt.Errorf("expected the output of a tool on stdin, e.g. free | sre analysis")
+			}
+		}
+		parser, probe, err := toolparse.ParseTool(cmd.InOrStdin(), analysisTool)
+		if err != nil {
+			return err
+		}
+		return printProbes(cmd.OutOrStdout(), []namedProbe{{ID: toolID(parser), Probe: probe}})
 	},
 }
 
@@ -49,4 +70,10 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	// analysisCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	analysisCmd.Flags().StringVar(&analysisTool, "tool", "", "skip the detection and parse the output of this tool")
+}
+
+// toolID names the report after the tool, e.g. "iostat" for "iostat -x"
+func toolID(parser *toolparse.Parser) string {
+	return strings.Fields(parser.Tool)[0]
 }
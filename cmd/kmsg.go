@@ -0,0 +1,141 @@
+/*
+Copyright © 2022 Mikolaj Pawlikowski <mikolaj@pawlikowski.pl>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/enescakir/emoji"
+	"github.com/spf13/cobra"
+	"github.com/sredog/sre/pkg/analysis"
+	"github.com/sredog/sre/pkg/kmsgprobe"
+)
+
+var kmsgSince time.Duration
+var kmsgFollow bool
+var kmsgFile string
+
+// kmsgCmd represents the kmsg command
+var kmsgCmd = &cobra.Command{
+	Use:     "kmsg",
+	Aliases: []string{"dmesg"},
+	Short:   "Kernel ring buffer: OOM kills, hung tasks, lockups, I/O errors and other known failures",
+	Long: `Reads the kernel ring buffer and counts the warnings and errors per hour, the OOM kills
+and the messages of known failures. Those of the last hour are told apart from older ones.
+
+With --since only the messages of that last period are read, e.g. --since 1h.
+With --follow the ring buffer is followed from its end, and what's found is printed as soon
+as it's logged, until interrupted.
+
+With --kmsg-file a saved log is read instead, for postmortems: the output of dmesg (-T for the
+times, -x for the priorities), /var/log/kern.log, journalctl -k, journalctl -k -o export, or a
+copy of /dev/kmsg. Its last message stands for now, e.g. for --since.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if kmsgFile != "" {
+			return kmsgFromFile(cmd)
+		}
+		var since time.Time
+		if kmsgSince > 0 {
+			since = time.Now().Add(-kmsgSince)
+		}
+		if kmsgFollow {
+			p := kmsgprobe.NewStreamingProbe()
+			p.Since = since
+			return followKmsg(cmd, func(observe func(*analysis.Observation)) error {
+				return p.Follow(cmd.Context(), observe)
+			})
+		}
+		p, err := kmsgprobe.NewKernelRingBufferProbe(cmd.Context(), since)
+		if err != nil {
+			return err
+		}
+		return printProbes(cmd.OutOrStdout(), []namedProbe{{ID: "kmsg", Probe: p}})
+	},
+}
+
+// kmsgFromFile analyses a saved kernel log, or replays it with --follow
+func kmsgFromFile(cmd *cobra.Command) error {
+	f, err := os.Open(kmsgFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if kmsgFollow {
+		return followKmsg(cmd, func(observe func(*analysis.Observation)) error {
+			packets, _, err := kmsgprobe.ScanFile(cmd.Context(), f)
+			if err != nil {
+				return err
+			}
+			return kmsgprobe.NewStreamingProbe().Stream(cmd.Context(), packets, observe)
+		})
+	}
+	p, err := kmsgprobe.NewKernelLogFileProbe(cmd.Context(), f, kmsgFile, kmsgSince)
+	if err != nil {
+		return err
+	}
+	return printProbes(cmd.OutOrStdout(), []namedProbe{{ID: "kmsg", Probe: p}})
+}
+
+// followKmsg prints the observations as follow finds them, one JSON object per line
+// with --output json
+func followKmsg(cmd *cobra.Command, follow func(observe func(*analysis.Observation)) error) error {
+	w := cmd.OutOrStdout()
+	encoder := json.NewEncoder(w)
+	if outputFormat == outputHuman {
+		fmt.Fprintf(w, "%v Following the kernel log, interrupt to stop\n", emoji.Penguin)
+	}
+	var err error
+	observe := func(o *analysis.Observation) {
+		if err != nil {
+			return
+		}
+		if outputFormat == outputJSON {
+			err = encoder.Encode(o)
+			return
+		}
+		_, err = fmt.Fprintln(w, o.Format())
+	}
+	if followErr := follow(observe); followErr != nil {
+		return followErr
+	}
+	return err
+}
+
+func init() {
+	rootCmd.AddCommand(kmsgCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// kmsgCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	// kmsgCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	kmsgCmd.Flags().DurationVar(&kmsgSince, "since", 0, "only read the messages of the last period, e.g. 1h")
+	kmsgCmd.Flags().BoolVarP(&kmsgFollow, "follow", "f", false, "follow the ring buffer and print what's found as it's logged")
+	kmsgCmd.Flags().StringVar(&kmsgFile, "kmsg-file", "", "read a saved log instead: dmesg output, kern.log or journalctl -k -o export")
+}
@@ -0,0 +1,93 @@
+/*
+Copyright © 2022 Mikolaj Pawlikowski <mikolaj@pawlikowski.pl>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"github.com/sredog/sre/pkg/analysis"
+)
+
+// probeListing is a registered probe along with the collections it's part of
+type probeListing struct {
+	ID          string
+	Aliases     []string
+	Description string
+	Collections []string
+}
+
+// listCmd represents the list command
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the probes with their aliases and collections",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var listings []probeListing
+		for _, p := range analysis.Probes() {
+			l := probeListing{
+				ID:          p.ID,
+				Aliases:     append([]string{}, p.Aliases...),
+				Description: p.Description,
+				Collections: []string{},
+			}
+			for _, c := range analysis.Collections() {
+				for _, id := range c.Probes {
+					if id == p.ID {
+						l.Collections = append(l.Collections, c.ID)
+					}
+				}
+			}
+			listings = append(listings, l)
+		}
+		if outputFormat == outputJSON {
+			encoder := json.NewEncoder(cmd.OutOrStdout())
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(listings)
+		}
+		bold := color.New(color.Bold)
+		w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "PROBE\tALIASES\tCOLLECTIONS\tDESCRIPTION")
+		for _, l := range listings {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", bold.Sprint(l.ID), strings.Join(l.Aliases, ","), strings.Join(l.Collections, ","), l.Description)
+		}
+		return w.Flush()
+	},
+}
+
+func init() {
+	probesCmd.AddCommand(listCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// listCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	// listCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+}
@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/enescakir/emoji"
+	"github.com/sredog/sre/pkg/analysis"
+)
+
+const (
+	outputHuman = "human"
+	outputJSON  = "json"
+)
+
+// namedProbe pairs a probe with the ID it's reported under, or the error it failed with
+type namedProbe struct {
+	ID    string
+	Probe analysis.Probe
+	Err   error
+}
+
+// jsonDocument is the top level object printed with --output json
+type jsonDocument struct {
+	Probes []*analysis.Report
+	// Observations are about all the probes, e.g. that they average since boot
+	Observations []*analysis.Observation `json:",omitempty"`
+}
+
+func validateOutputFormat() error {
+	switch outputFormat {
+	case outputHuman, outputJSON:
+		return nil
+	}
+	return fmt.Errorf("unknown output format %q, expected one of: %s, %s", outputFormat, outputHuman, outputJSON)
+}
+
+// printProbes writes the probes out in the format selected with --output
+func printProbes(w io.Writer, probes []namedProbe) error {
+	var built []analysis.Probe
+	for _, p := range probes {
+		if p.Err == nil {
+			built = append(built, p.Probe)
+		}
+	}
+	hint := analysis.SinceBootHint(built)
+	switch outputFormat {
+	case outputJSON:
+		doc := jsonDocument{
+			Probes: make([]*analysis.Report, 0, len(probes)),
+		}
+		for _, p := range probes {
+			if p.Err != nil {
+				doc.Probes = append(doc.Probes, analysis.NewFailedReport(p.ID, p.Err))
+				continue
+			}
+			doc.Probes = append(doc.Probes, analysis.NewReport(p.ID, p.Probe))
+		}
+		if hint != nil {
+			doc.Observations = append(doc.Observations, hint)
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(doc)
+	default:
+		for _, p := range probes {
+			if p.Err != nil {
+				_, err := fmt.Fprintf(w, "%v Probe %s failed: %v\n", emoji.CrossMark, p.ID, p.Err)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			_, err := fmt.Fprint(w, p.Probe.Display())
+			if err != nil {
+				return err
+			}
+			for _, observation := range p.Probe.Analysis() {
+				_, err = fmt.Fprintf(w, "%s\n", observation.Format())
+				if err != nil {
+					return err
+				}
+			}
+		}
+		if hint != nil {
+			if _, err := fmt.Fprintf(w, "%s\n", hint.Format()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -23,17 +23,35 @@ package cmd
 
 import (
 	"fmt"
+	"strconv"
 
 	"github.com/spf13/cobra"
+	"github.com/sredog/sre/pkg/pid"
 )
 
 // pidCmd represents the pid command
 var pidCmd = &cobra.Command{
-	Use:   "pid",
+	Use:   "pid PID",
 	Short: "Display info per-process",
-	Long:  `.`,
+	Long: `Deep-dive into a single process: command line, state, parent chain, threads,
+CPU time, memory (from smaps_rollup), file descriptors, cgroups, namespaces,
+I/O counters and OOM score.`,
+	Args: cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return fmt.Errorf("pid not implemented")
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n <= 0 {
+			return fmt.Errorf("expected a PID, got %q", args[0])
+		}
+		r := roots()
+		p, err := r.ProcFS()
+		if err != nil {
+			return err
+		}
+		pp, err := pid.NewPIDProbe(p, r.Proc, n)
+		if err != nil {
+			return err
+		}
+		return printProbes(cmd.OutOrStdout(), []namedProbe{{ID: "pid", Probe: pp}})
 	},
 }
 
@@ -1,40 +1,49 @@
 package cmd
 
 import (
-	"github.com/prometheus/procfs"
+	"context"
+
 	"github.com/sredog/sre/pkg/analysis"
-	"github.com/sredog/sre/pkg/uptime"
+
+	// the probe packages register themselves
+	_ "github.com/sredog/sre/pkg/cpu"
+	_ "github.com/sredog/sre/pkg/disk"
+	_ "github.com/sredog/sre/pkg/filesystem"
+	_ "github.com/sredog/sre/pkg/kmsgprobe"
+	_ "github.com/sredog/sre/pkg/loadavg"
+	_ "github.com/sredog/sre/pkg/memory"
+	_ "github.com/sredog/sre/pkg/network"
+	_ "github.com/sredog/sre/pkg/numa"
+	_ "github.com/sredog/sre/pkg/processes"
+	_ "github.com/sredog/sre/pkg/psi"
+	_ "github.com/sredog/sre/pkg/slab"
+	_ "github.com/sredog/sre/pkg/tcp"
+	_ "github.com/sredog/sre/pkg/throttle"
+	_ "github.com/sredog/sre/pkg/uptime"
+	_ "github.com/sredog/sre/pkg/vmstat"
 )
 
-type ProbeConfiguration struct {
-	ID          string
-	Description string
-	Aliases     []string
-	Build       func(*procfs.FS) (analysis.Probe, error)
+func init() {
+	analysis.RegisterCollection(&analysis.ProbeCollectionConfiguration{
+		ID:          "quick",
+		Description: "Quick overview of the system",
+		Probes:      []string{"uptime", "loadavg", "kmsg", "memory", "numa", "processes", "cpu", "disk", "network", "filesystem", "psi", "tcp"},
+	})
 }
 
-type ProbeCollectionConfiguration struct {
-	ID     string
-	Probes []string
+// environment builds the probes from the locations given with the root flags
+func environment() *analysis.Environment {
+	return &analysis.Environment{
+		Roots:    roots(),
+		Interval: probeInterval,
+	}
 }
 
-var probes []*ProbeConfiguration
-
-func init() {
-	probes = append(probes, &ProbeConfiguration{
-		ID:          "uptime",
-		Aliases:     []string{"up"},
-		Description: "Look into the systems uptime and idle time",
-		Build: func(fs *procfs.FS) (analysis.Probe, error) {
-			stat, err := fs.Stat()
-			if err != nil {
-				panic(err)
-			}
-			up, err := uptime.NewUptimeProbe(len(stat.CPU))
-			if err != nil {
-				panic(err)
-			}
-			return up, nil
-		},
-	})
+// buildProbes builds the configured probes concurrently, the failed ones are returned with their error
+func buildProbes(ctx context.Context, configs []*analysis.ProbeConfiguration) []namedProbe {
+	var built []namedProbe
+	for _, r := range analysis.Collect(ctx, environment(), configs, probeTimeout) {
+		built = append(built, namedProbe{ID: r.ID, Probe: r.Probe, Err: r.Err})
+	}
+	return built
 }
@@ -22,83 +22,28 @@ THE SOFTWARE.
 package cmd
 
 import (
-	"fmt"
-
-	"github.com/prometheus/procfs"
 	"github.com/spf13/cobra"
 	"github.com/sredog/sre/pkg/analysis"
-	"github.com/sredog/sre/pkg/cpu"
-	"github.com/sredog/sre/pkg/kmsgprobe"
-	"github.com/sredog/sre/pkg/loadavg"
-	"github.com/sredog/sre/pkg/memory"
-	"github.com/sredog/sre/pkg/processes"
-	"github.com/sredog/sre/pkg/uptime"
 )
 
+var quickProbes []string
+var quickSkip []string
+
 // quickCmd represents the quick command
 var quickCmd = &cobra.Command{
 	Use:   "quick",
 	Short: "Quick overview of the system: CPUs, RAM, IO, net, filesystems",
-	Long:  ``,
-	RunE: func(cmd *cobra.Command, args []string) error {
-
-		p, err := procfs.NewDefaultFS()
-		if err != nil {
-			return err
-		}
-
-		var probes []analysis.Probe
-
-		uptime, err := uptime.NewUptimeProbe(2)
-		if err != nil {
-			return err
-		}
-		probes = append(probes, uptime)
-
-		la, err := loadavg.NewLoadAverage(p)
-		if err != nil {
-			return err
-		}
-		probes = append(probes, la)
+	Long: `Runs the probes of the quick collection, or the ones given with --probes.
+See "sre probes list" for the probes and their aliases.
 
-		krbp, err := kmsgprobe.NewKernelRingBufferProbe()
-		if err != nil {
-			return err
-		}
-		probes = append(probes, krbp)
-
-		mp, err := memory.NewMemoryProbe(p)
-		if err != nil {
-			return err
-		}
-		probes = append(probes, mp)
-
-		pp, err := processes.NewProcessesProbe(p)
-		if err != nil {
-			return err
-		}
-		probes = append(probes, pp)
-
-		cp, err := cpu.NewCPUProbe(p)
+sre quick --probes mem,cpu
+sre quick --skip kmsg`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		configs, err := analysis.Select("quick", quickProbes, quickSkip)
 		if err != nil {
 			return err
 		}
-		probes = append(probes, cp)
-
-		for _, probe := range probes {
-			output := probe.Display()
-			_, err = fmt.Print(output)
-			if err != nil {
-				return err
-			}
-			for _, observation := range probe.Analysis() {
-				_, err = fmt.Printf("%s\n", observation.Format())
-				if err != nil {
-					return err
-				}
-			}
-		}
-		return nil
+		return printProbes(cmd.OutOrStdout(), buildProbes(cmd.Context(), configs))
 	},
 }
 
@@ -114,4 +59,6 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	// quickCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	quickCmd.Flags().StringSliceVar(&quickProbes, "probes", nil, "probes to run instead of the quick collection, e.g. mem,cpu")
+	quickCmd.Flags().StringSliceVar(&quickSkip, "skip", nil, "probes to skip, e.g. kmsg")
 }
@@ -25,15 +25,20 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/enescakir/emoji"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"github.com/sredog/sre/pkg/fsroot"
 )
 
 var cfgFile string
 var procfsLocation string
+var sysfsLocation string
 var outputFormat string
+var probeTimeout time.Duration
+var probeInterval time.Duration
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
@@ -49,6 +54,9 @@ sre shell			# starts interactive mode with autocompletion and auto-analysis
 sre tools 			# suggests command line tools to debug various components of the system
 sre throttle		# lists processes by the amount of time they've been throttled
 `, emoji.DogFace),
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return validateOutputFormat()
+	},
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
 	// Run: func(cmd *cobra.Command, args []string) {
@@ -70,8 +78,19 @@ func init() {
 	// will be global for your application.
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.sre.yaml)")
-	rootCmd.PersistentFlags().StringVar(&procfsLocation, "procfs", "/proc", "procfs location")
+	rootCmd.PersistentFlags().StringVar(&procfsLocation, "procfs", fsroot.DefaultProc, "procfs location")
+	rootCmd.PersistentFlags().StringVar(&sysfsLocation, "sysfs", fsroot.DefaultSys, "sysfs location")
 	rootCmd.PersistentFlags().StringVar(&outputFormat, "output", "human", "output format: human, json")
+	rootCmd.PersistentFlags().DurationVar(&probeInterval, "interval", time.Second, "how long to sample counters for, 0 for averages since boot")
+	rootCmd.PersistentFlags().DurationVar(&probeTimeout, "timeout", 10*time.Second, "how long each probe gets on top of --interval before it is reported as failed")
+}
+
+// roots returns the procfs and sysfs locations all probes should read from
+func roots() *fsroot.Roots {
+	return &fsroot.Roots{
+		Proc: procfsLocation,
+		Sys:  sysfsLocation,
+	}
 }
 
 // initConfig reads in config file and ENV variables if set.
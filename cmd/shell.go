@@ -22,21 +22,131 @@ THE SOFTWARE.
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
 
 	"github.com/spf13/cobra"
+	"github.com/sredog/sre/pkg/analysis"
+	"github.com/sredog/sre/pkg/pid"
+	"github.com/sredog/sre/pkg/shell"
 )
 
+var shellHistoryFile string
+
 // shellCmd represents the shell command
 var shellCmd = &cobra.Command{
 	Use:   "shell",
-	Short: "Better shell with autocompletion for common SRE tools",
-	Long:  ``,
+	Short: "Interactive prompt running probes and analysing the output of other commands",
+	Long: `Interactive prompt with history and tab completion of probe IDs, PIDs and paths
+(e.g. cgroups under /sys/fs/cgroup/). Typing a probe ID or alias (e.g. "up") runs the probe,
+"pid PID" looks into a process, and anything else runs in a sub-shell with its output
+analysed like "sre analysis" would.
+
+Ctrl-C stops the command being run, or drops the line being edited, Ctrl-D leaves.
+The arrow keys go through the history of previous sessions too.
+
+When stdin isn't a terminal, the lines are read as a script:
+
+echo -e "up\nvmstat 1 5" | sre shell`,
+	Args: cobra.NoArgs,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return fmt.Errorf("shell not implemented")
+		s := &shell.Shell{
+			Prompt:      "sre> ",
+			Commands:    shellCommands(),
+			HistoryFile: shellHistoryFile,
+		}
+		// an interrupt stops the command being run, not the shell, see shell.Execute
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM)
+		defer stop()
+		return s.Run(ctx, cmd.InOrStdin(), cmd.OutOrStdout())
 	},
 }
 
+// shellCommands turns the probes and collections into shell commands, plus pid
+func shellCommands() []*shell.Command {
+	var commands []*shell.Command
+	for _, config := range analysis.Probes() {
+		config := config
+		commands = append(commands, &shell.Command{
+			ID:          config.ID,
+			Aliases:     config.Aliases,
+			Description: config.Description,
+			Run: func(ctx context.Context, w io.Writer, args []string) error {
+				return printProbes(w, buildProbes(ctx, []*analysis.ProbeConfiguration{config}))
+			},
+		})
+	}
+	for _, collection := range analysis.Collections() {
+		collection := collection
+		commands = append(commands, &shell.Command{
+			ID:          collection.ID,
+			Description: fmt.Sprintf("%s: %s", collection.Description, strings.Join(collection.Probes, ", ")),
+			Run: func(ctx context.Context, w io.Writer, args []string) error {
+				configs, err := analysis.Select(collection.ID, nil, nil)
+				if err != nil {
+					return err
+				}
+				return printProbes(w, buildProbes(ctx, configs))
+			},
+		})
+	}
+	commands = append(commands, &shell.Command{
+		ID:          "pid",
+		Description: "Deep-dive into a single process, e.g. pid 1",
+		Complete:    completePIDs,
+		Run: func(ctx context.Context, w io.Writer, args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("usage: pid PID")
+			}
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n <= 0 {
+				return fmt.Errorf("expected a PID, got %q", args[0])
+			}
+			r := roots()
+			p, err := r.ProcFS()
+			if err != nil {
+				return err
+			}
+			pp, err := pid.NewPIDProbe(p, r.Proc, n)
+			if err != nil {
+				return err
+			}
+			return printProbes(w, []namedProbe{{ID: "pid", Probe: pp}})
+		},
+	})
+	return commands
+}
+
+// completePIDs lists the processes in procfs
+func completePIDs(prefix string) (pids []string) {
+	entries, err := ioutil.ReadDir(roots().Proc)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if _, err := strconv.Atoi(e.Name()); err == nil && e.IsDir() {
+			pids = append(pids, e.Name())
+		}
+	}
+	return
+}
+
+func defaultHistoryFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".sre_history")
+}
+
 func init() {
 	rootCmd.AddCommand(shellCmd)
 
@@ -49,4 +159,5 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	// shellCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	shellCmd.Flags().StringVar(&shellHistoryFile, "history-file", defaultHistoryFile(), "file to keep the history in, empty to not keep it")
 }
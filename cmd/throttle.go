@@ -22,18 +22,30 @@ THE SOFTWARE.
 package cmd
 
 import (
-	"fmt"
-
 	"github.com/spf13/cobra"
+	"github.com/sredog/sre/pkg/throttle"
 )
 
+var cgroupfsLocation string
+var throttleTop int
+
 // throttleCmd represents the throttle command
 var throttleCmd = &cobra.Command{
 	Use:   "throttle",
 	Short: "List CPU-throttled processes",
-	Long:  ``,
+	Long: `Walks the cgroup hierarchy (v1 or unified v2) and ranks the cgroups with a CPU
+quota by the time CFS bandwidth control throttled them, along with their processes.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return fmt.Errorf("throttle not implemented")
+		r := roots()
+		cgroupRoot := cgroupfsLocation
+		if cgroupRoot == "" {
+			cgroupRoot = r.CgroupPath()
+		}
+		tp, err := throttle.NewThrottleProbe(r.Proc, cgroupRoot, throttleTop)
+		if err != nil {
+			return err
+		}
+		return printProbes(cmd.OutOrStdout(), []namedProbe{{ID: "throttle", Probe: tp}})
 	},
 }
 
@@ -49,4 +61,6 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	// throttleCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	throttleCmd.Flags().StringVar(&cgroupfsLocation, "cgroupfs", "", "cgroupfs location (default is fs/cgroup under --sysfs)")
+	throttleCmd.Flags().IntVar(&throttleTop, "top", throttle.DefaultTop, "how many cgroups to show, 0 for all")
 }
@@ -22,18 +22,38 @@ THE SOFTWARE.
 package cmd
 
 import (
-	"fmt"
-
 	"github.com/spf13/cobra"
+	"github.com/sredog/sre/pkg/tools"
 )
 
+var toolsSearch string
+
 // toolsCmd represents the tools command
 var toolsCmd = &cobra.Command{
 	Use:   "tools",
 	Short: "Learn command line tools to debug various components of the system",
-	Long:  ``,
+	Long: `Catalog of command line tools by the component of the system they debug,
+with example invocations and what to look for. Tools missing from $PATH are marked.
+
+sre tools			# all the tools
+sre tools disk			# tools for a component: cpu, memory, disk, network, scheduler, kernel, containers, ...
+sre tools iostat		# a single tool
+sre tools --search latency	# tools mentioning latency`,
+	Args: cobra.MaximumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return fmt.Errorf("tools not implemented")
+		entries, err := tools.Catalog()
+		if err != nil {
+			return err
+		}
+		arg := ""
+		if len(args) == 1 {
+			arg = args[0]
+		}
+		listing, err := tools.Select(entries, arg, toolsSearch)
+		if err != nil {
+			return err
+		}
+		return printProbes(cmd.OutOrStdout(), []namedProbe{{ID: "tools", Probe: listing}})
 	},
 }
 
@@ -49,4 +69,5 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	// toolsCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	toolsCmd.Flags().StringVar(&toolsSearch, "search", "", "only show the tools mentioning this text")
 }
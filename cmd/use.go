@@ -25,15 +25,42 @@ import (
 	"fmt"
 
 	"github.com/spf13/cobra"
+	"github.com/sredog/sre/pkg/loadavg"
+	"github.com/sredog/sre/pkg/memory"
+	"github.com/sredog/sre/pkg/use"
 )
 
 // useCmd represents the use command
 var useCmd = &cobra.Command{
 	Use:   "use",
 	Short: "USE (Utilisation, Saturation, Errors) analysis on the system",
-	Long:  ``,
+	Long: `Checks every resource for its Utilisation, Saturation and Errors, following
+the USE method: https://www.brendangregg.com/usemethod.html
+
+CPU, memory, disks, storage controllers and network interfaces are covered.
+The counters are sampled twice, --interval apart.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return fmt.Errorf("use not implemented")
+		if probeInterval <= 0 {
+			return fmt.Errorf("the USE method needs an --interval to sample the counters over")
+		}
+		r := roots()
+		p, err := r.ProcFS()
+		if err != nil {
+			return err
+		}
+		mp, err := memory.NewMemoryProbe(p)
+		if err != nil {
+			return err
+		}
+		la, err := loadavg.NewLoadAverage(p)
+		if err != nil {
+			return err
+		}
+		up, err := use.NewUSEProbe(cmd.Context(), r, probeInterval, mp, la)
+		if err != nil {
+			return err
+		}
+		return printProbes(cmd.OutOrStdout(), []namedProbe{{ID: "use", Probe: up}})
 	},
 }
 
@@ -16,9 +16,22 @@ const (
 	Issue
 )
 
+var observationTypeNames = [...]string{"Learn", "Hint", "Note", "Warning", "Issue"}
+
+func (t ObservationType) String() string {
+	return observationTypeNames[t]
+}
+
+// MarshalText makes observation types show up by name in JSON output
+func (t ObservationType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
 type Observation struct {
 	Type    ObservationType
 	Message string
+	// See is the ID of a `sre tools` catalog entry with more to learn
+	See string `json:",omitempty"`
 }
 
 // Analyser is the main interface all probes need to implement
@@ -27,7 +40,7 @@ type Analyser interface {
 }
 
 func (o *Observation) String() string {
-	return [...]string{"Learn", "Hint", "Note", "Warning", "Issue"}[o.Type]
+	return o.Type.String()
 }
 
 func (o *Observation) Format() string {
@@ -42,5 +55,8 @@ func (o *Observation) Format() string {
 	default:
 		textColor = color.New(color.Italic)
 	}
+	if o.See != "" {
+		return fmt.Sprintf("%s: %s (see `sre tools %s`)", textColor.Sprint(o.String()), o.Message, o.See)
+	}
 	return fmt.Sprintf("%s: %s", textColor.Sprint(o.String()), o.Message)
 }
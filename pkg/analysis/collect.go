@@ -0,0 +1,52 @@
+package analysis
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Result is a probe built by Collect, or why it couldn't be
+type Result struct {
+	ID    string
+	Probe Probe
+	Err   error
+}
+
+// Collect builds the probes concurrently, giving each of them timeout to finish on top of
+// the environment's interval they may sample over. The results are in the order of configs. A probe stuck past its deadline, e.g. reading
+// from a hung NFS mount, is reported as failed and left behind.
+func Collect(ctx context.Context, env *Environment, configs []*ProbeConfiguration, timeout time.Duration) []*Result {
+	results := make([]*Result, len(configs))
+	var wg sync.WaitGroup
+	for i, config := range configs {
+		wg.Add(1)
+		go func(i int, config *ProbeConfiguration) {
+			defer wg.Done()
+			results[i] = collect(ctx, env, config, env.Interval+timeout)
+		}(i, config)
+	}
+	wg.Wait()
+	return results
+}
+
+func collect(ctx context.Context, env *Environment, config *ProbeConfiguration, timeout time.Duration) *Result {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	done := make(chan *Result, 1)
+	go func() {
+		probe, err := config.Build(ctx, env)
+		done <- &Result{ID: config.ID, Probe: probe, Err: err}
+	}()
+	select {
+	case r := <-done:
+		return r
+	case <-ctx.Done():
+		err := ctx.Err()
+		if err == context.DeadlineExceeded {
+			err = fmt.Errorf("timed out after %v", timeout)
+		}
+		return &Result{ID: config.ID, Err: err}
+	}
+}
@@ -4,4 +4,5 @@ package analysis
 type Probe interface {
 	Displayer
 	Analyser
+	Serializer
 }
@@ -0,0 +1,162 @@
+package analysis
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sredog/sre/pkg/fsroot"
+)
+
+// Environment is what the probes are built from
+type Environment struct {
+	Roots *fsroot.Roots
+	// Interval to sample counters over, 0 for averages since boot
+	Interval time.Duration
+
+	mu     sync.Mutex
+	shared map[string]*sharedValue
+}
+
+type sharedValue struct {
+	once  sync.Once
+	value interface{}
+	err   error
+}
+
+// Shared builds the value under key once, and hands it to every probe asking for it
+// afterwards, e.g. two samples of /proc/stat used by more than one probe
+func (e *Environment) Shared(key string, build func() (interface{}, error)) (interface{}, error) {
+	e.mu.Lock()
+	if e.shared == nil {
+		e.shared = make(map[string]*sharedValue)
+	}
+	v, ok := e.shared[key]
+	if !ok {
+		v = &sharedValue{}
+		e.shared[key] = v
+	}
+	e.mu.Unlock()
+	v.once.Do(func() {
+		v.value, v.err = build()
+	})
+	return v.value, v.err
+}
+
+// ProbeConfiguration describes a probe that can be run by its ID or one of its aliases
+type ProbeConfiguration struct {
+	ID          string
+	Description string
+	Aliases     []string
+	// Build should give up once ctx is done
+	Build func(ctx context.Context, env *Environment) (Probe, error)
+}
+
+// ProbeCollectionConfiguration is a named list of probe IDs run together, e.g. "quick"
+type ProbeCollectionConfiguration struct {
+	ID          string
+	Description string
+	Probes      []string
+}
+
+var (
+	probes      []*ProbeConfiguration
+	collections []*ProbeCollectionConfiguration
+)
+
+// Register adds the probe to the registry. Probe packages call it from init.
+func Register(config *ProbeConfiguration) {
+	for _, name := range append([]string{config.ID}, config.Aliases...) {
+		if existing, err := Find(name); err == nil {
+			panic(fmt.Sprintf("probe %q: %q is already taken by probe %q", config.ID, name, existing.ID))
+		}
+	}
+	probes = append(probes, config)
+}
+
+// RegisterCollection adds the collection to the registry
+func RegisterCollection(config *ProbeCollectionConfiguration) {
+	if _, err := Collection(config.ID); err == nil {
+		panic(fmt.Sprintf("collection %q is already registered", config.ID))
+	}
+	collections = append(collections, config)
+}
+
+// Probes returns the registered probes sorted by ID
+func Probes() []*ProbeConfiguration {
+	sorted := append([]*ProbeConfiguration{}, probes...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+	return sorted
+}
+
+// Collections returns the registered collections sorted by ID
+func Collections() []*ProbeCollectionConfiguration {
+	sorted := append([]*ProbeCollectionConfiguration{}, collections...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+	return sorted
+}
+
+// Find looks a probe up by its ID or one of its aliases
+func Find(name string) (*ProbeConfiguration, error) {
+	for _, p := range probes {
+		if p.ID == name {
+			return p, nil
+		}
+		for _, alias := range p.Aliases {
+			if alias == name {
+				return p, nil
+			}
+		}
+	}
+	var ids []string
+	for _, p := range Probes() {
+		ids = append(ids, p.ID)
+	}
+	return nil, fmt.Errorf("unknown probe %q, expected one of: %s", name, strings.Join(ids, ", "))
+}
+
+// Collection looks a collection up by its ID
+func Collection(id string) (*ProbeCollectionConfiguration, error) {
+	for _, c := range collections {
+		if c.ID == id {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown collection %q", id)
+}
+
+// Select resolves the probes of the collection, or the names given instead of it, minus the skipped ones.
+// Names can be IDs or aliases.
+func Select(collection string, names, skip []string) ([]*ProbeConfiguration, error) {
+	if len(names) == 0 {
+		c, err := Collection(collection)
+		if err != nil {
+			return nil, err
+		}
+		names = c.Probes
+	}
+	skipped := make(map[string]bool)
+	for _, name := range skip {
+		p, err := Find(name)
+		if err != nil {
+			return nil, err
+		}
+		skipped[p.ID] = true
+	}
+	var selected []*ProbeConfiguration
+	for _, name := range names {
+		p, err := Find(name)
+		if err != nil {
+			return nil, err
+		}
+		if skipped[p.ID] {
+			continue
+		}
+		skipped[p.ID] = true // runs each probe once
+		selected = append(selected, p)
+	}
+	return selected, nil
+}
@@ -0,0 +1,105 @@
+package analysis
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSelect(t *testing.T) {
+	defer func(p []*ProbeConfiguration, c []*ProbeCollectionConfiguration) {
+		probes, collections = p, c
+	}(probes, collections)
+	probes, collections = nil, nil
+	Register(&ProbeConfiguration{ID: "memory", Aliases: []string{"mem"}})
+	Register(&ProbeConfiguration{ID: "cpu"})
+	Register(&ProbeConfiguration{ID: "kmsg"})
+	RegisterCollection(&ProbeCollectionConfiguration{ID: "quick", Probes: []string{"kmsg", "memory", "cpu"}})
+
+	for _, tc := range []struct {
+		names, skip []string
+		expected    []string
+	}{
+		{nil, nil, []string{"kmsg", "memory", "cpu"}},
+		{nil, []string{"kmsg"}, []string{"memory", "cpu"}},
+		{[]string{"mem", "cpu", "memory"}, nil, []string{"memory", "cpu"}},
+		{[]string{"mem", "cpu"}, []string{"memory"}, []string{"cpu"}},
+	} {
+		selected, err := Select("quick", tc.names, tc.skip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, p := range selected {
+			ids = append(ids, p.ID)
+		}
+		if !reflect.DeepEqual(ids, tc.expected) {
+			t.Errorf("Expected %v for %v skipping %v, got %v", tc.expected, tc.names, tc.skip, ids)
+		}
+	}
+	if _, err := Select("quick", []string{"nope"}, nil); err == nil {
+		t.Error("Expected an unknown probe to fail")
+	}
+	if _, err := Select("slow", nil, nil); err == nil {
+		t.Error("Expected an unknown collection to fail")
+	}
+}
+
+type stubProbe struct{}
+
+func (stubProbe) Display() string          { return "" }
+func (stubProbe) Analysis() []*Observation { return nil }
+func (stubProbe) Serialize() interface{}   { return nil }
+
+func TestCollect(t *testing.T) {
+	stuck := make(chan struct{})
+	defer close(stuck)
+	configs := []*ProbeConfiguration{{
+		ID: "ok",
+		Build: func(ctx context.Context, env *Environment) (Probe, error) {
+			return stubProbe{}, nil
+		},
+	}, {
+		ID: "failing",
+		Build: func(ctx context.Context, env *Environment) (Probe, error) {
+			return nil, fmt.Errorf("no such file")
+		},
+	}, {
+		ID: "sampling",
+		Build: func(ctx context.Context, env *Environment) (Probe, error) {
+			// a sample longer than the timeout
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(env.Interval):
+			}
+			return stubProbe{}, nil
+		},
+	}, {
+		ID: "stuck",
+		Build: func(ctx context.Context, env *Environment) (Probe, error) {
+			// ignores ctx, like a read from a hung NFS mount
+			<-stuck
+			return stubProbe{}, nil
+		},
+	}}
+	results := Collect(context.Background(), &Environment{Interval: 50 * time.Millisecond}, configs, 10*time.Millisecond)
+	if len(results) != 4 {
+		t.Fatalf("Expected 4 results, got %d", len(results))
+	}
+	if results[0].ID != "ok" || results[0].Err != nil || results[0].Probe == nil {
+		t.Errorf("Expected ok to succeed, got %+v", results[0])
+	}
+	if results[1].ID != "failing" || results[1].Err == nil {
+		t.Errorf("Expected failing to fail, got %+v", results[1])
+	}
+	if results[2].ID != "sampling" || results[2].Err != nil {
+		t.Errorf("Expected sampling to get its interval on top of the timeout, got %+v", results[2])
+	}
+	if results[3].ID != "stuck" || results[3].Err == nil || !strings.Contains(results[3].Err.Error(), "timed out") {
+		t.Errorf("Expected stuck to time out, got %+v", results[3])
+	}
+}
@@ -0,0 +1,32 @@
+package analysis
+
+// Report is the machine-readable result of a single probe
+type Report struct {
+	Probe        string
+	Data         interface{}
+	Observations []*Observation
+	// Error is set when the probe failed or timed out, and there's no data
+	Error string `json:",omitempty"`
+}
+
+// NewReport runs the probe's analysis and bundles it with the raw data
+func NewReport(id string, p Probe) *Report {
+	observations := p.Analysis()
+	if observations == nil {
+		observations = []*Observation{}
+	}
+	return &Report{
+		Probe:        id,
+		Data:         p.Serialize(),
+		Observations: observations,
+	}
+}
+
+// NewFailedReport reports a probe that couldn't be built
+func NewFailedReport(id string, err error) *Report {
+	return &Report{
+		Probe:        id,
+		Observations: []*Observation{},
+		Error:        err.Error(),
+	}
+}
@@ -0,0 +1,89 @@
+package analysis
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+)
+
+// Sample holds two snapshots of cumulative counters taken Interval apart. Without
+// Before, left to the zero T, the counters are averaged since boot. The probes
+// declare their own, e.g. type Sample analysis.Sample[map[string]uint64]
+type Sample[T any] struct {
+	Before   T
+	After    T
+	Interval time.Duration
+	// Elapsed is the time the counters were collected over
+	Elapsed time.Duration
+}
+
+// TakeSample reads the counters twice, interval apart, giving up early if ctx is done.
+// With no interval it reads them once, for averages since the boot of the system whose
+// procfs is mounted at procfsRoot.
+func TakeSample[T any](ctx context.Context, procfsRoot string, interval time.Duration, read func() (T, error)) (*Sample[T], error) {
+	s := &Sample[T]{
+		Interval: interval,
+		Elapsed:  interval,
+	}
+	if interval > 0 {
+		before, err := read()
+		if err != nil {
+			return nil, err
+		}
+		s.Before = before
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+	} else {
+		up, err := Uptime(procfsRoot)
+		if err != nil {
+			return nil, err
+		}
+		s.Elapsed = up
+	}
+	after, err := read()
+	if err != nil {
+		return nil, err
+	}
+	s.After = after
+	return s, nil
+}
+
+// Uptime reads how long ago the system booted from /proc/uptime
+func Uptime(procfsRoot string) (time.Duration, error) {
+	content, err := ioutil.ReadFile(filepath.Join(procfsRoot, "uptime"))
+	if err != nil {
+		return 0, err
+	}
+	// $ cat /proc/uptime
+	// 4932.96 9643.80
+	var up float64
+	if _, err := fmt.Sscanf(string(content), "%f", &up); err != nil {
+		return 0, fmt.Errorf("could not parse %q: %w", content, err)
+	}
+	return time.Duration(up*1000) * time.Millisecond, nil
+}
+
+// Averager is implemented by the probes averaging counters over a Sample
+type Averager interface {
+	// SinceBoot tells whether the counters are averaged since boot, without an interval
+	SinceBoot() bool
+}
+
+// SinceBootHint suggests an interval when one of the probes averages its counters since
+// boot, for all of them at once. It's nil when none does.
+func SinceBootHint(probes []Probe) *Observation {
+	for _, p := range probes {
+		if a, ok := p.(Averager); ok && a.SinceBoot() {
+			return &Observation{
+				Type:    Hint,
+				Message: "These are averages since boot, sample with --interval 1s to see what's happening now",
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package analysis
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTakeSample(t *testing.T) {
+	proc := t.TempDir()
+	if err := os.WriteFile(filepath.Join(proc, "uptime"), []byte("4932.96 9643.80\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	reads := 0
+	read := func() (map[string]int, error) {
+		reads++
+		return map[string]int{"reads": reads}, nil
+	}
+	s, err := TakeSample(context.Background(), proc, 0, read)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Before != nil || s.After["reads"] != 1 || s.Elapsed != 4932960*time.Millisecond {
+		t.Errorf("Expected a single read averaged since boot, got %+v", s)
+	}
+	s, err = TakeSample(context.Background(), proc, time.Millisecond, read)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Before["reads"] != 2 || s.After["reads"] != 3 || s.Elapsed != time.Millisecond {
+		t.Errorf("Expected two reads over the interval, got %+v", s)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := TakeSample(ctx, proc, time.Hour, read); err == nil {
+		t.Error("Expected sampling to stop with the context")
+	}
+}
+
+type averagingProbe struct {
+	stubProbe
+	sinceBoot bool
+}
+
+func (p averagingProbe) SinceBoot() bool { return p.sinceBoot }
+
+func TestSinceBootHint(t *testing.T) {
+	if hint := SinceBootHint([]Probe{stubProbe{}, averagingProbe{}}); hint != nil {
+		t.Errorf("Expected no hint when sampling over an interval, got %v", hint.Message)
+	}
+	if hint := SinceBootHint([]Probe{stubProbe{}, averagingProbe{sinceBoot: true}, averagingProbe{sinceBoot: true}}); hint == nil || hint.Type != Hint {
+		t.Errorf("Expected a hint when averaging since boot, got %+v", hint)
+	}
+}
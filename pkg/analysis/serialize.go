@@ -0,0 +1,6 @@
+package analysis
+
+// Serializer exposes the raw values a probe collected, so they can be encoded as JSON
+type Serializer interface {
+	Serialize() interface{}
+}
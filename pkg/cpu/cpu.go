@@ -1,7 +1,10 @@
 package cpu
 
 import (
+	"context"
 	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/enescakir/emoji"
 	"github.com/fatih/color"
@@ -29,43 +32,250 @@ type StatProvider interface {
 }
 
 type CPUProbe struct {
-	Stat *procfs.Stat
+	// Stat is the latest snapshot
+	Stat   *procfs.Stat
+	Sample *Sample
+	// Usage is the time spent in each state over the sample
+	Usage procfs.CPUStat
+	// PerCPU is the usage of each online CPU
+	PerCPU []*CPUUsage
 }
 
-// NewCPUProbe provides insights into CPU utilization
-func NewCPUProbe(provider StatProvider) (*CPUProbe, error) {
-	s, err := provider.Stat()
-	if err != nil {
-		return nil, err
+// CPUUsage is the time a single CPU spent in each state over the sample
+type CPUUsage struct {
+	CPU int
+	procfs.CPUStat
+}
+
+// Utilization returns the ratio of the CPU's time spent outside of idle
+func (u *CPUUsage) Utilization() float64 {
+	return 1 - u.Share(u.Idle)
+}
+
+// Share returns the ratio of the CPU's time spent in a state
+func (u *CPUUsage) Share(time float64) float64 {
+	total := CPUTotalTime(&u.CPUStat)
+	if total == 0 {
+		return 0
+	}
+	return time / total
+}
+
+// NewCPUProbeFromSample splits the time of each online CPU between its states over the sample
+func NewCPUProbeFromSample(s *Sample) *CPUProbe {
+	p := &CPUProbe{
+		Stat:   s.After,
+		Sample: s,
+		Usage:  s.CPUTotal(),
 	}
-	u := &CPUProbe{
-		Stat: &s,
+	for i := range s.After.CPU {
+		usage := &CPUUsage{CPU: i, CPUStat: s.CPU(i)}
+		// offline CPUs are left out of /proc/stat
+		if CPUTotalTime(&usage.CPUStat) > 0 {
+			p.PerCPU = append(p.PerCPU, usage)
+		}
 	}
-	return u, nil
+	return p
 }
 
-const displayFormat = `%v %v CPUs at %v utilization
-User: %v (niced %v), system: %v, stolen: %v, idle: %v
+func init() {
+	analysis.Register(&analysis.ProbeConfiguration{
+		ID:          "cpu",
+		Description: "CPU utilization over the interval",
+		Build: func(ctx context.Context, env *analysis.Environment) (analysis.Probe, error) {
+			s, err := SharedSample(ctx, env)
+			if err != nil {
+				return nil, err
+			}
+			return NewCPUProbeFromSample(s), nil
+		},
+	})
+}
+
+// Utilization returns the ratio of CPU time spent outside of idle
+func (p *CPUProbe) Utilization() float64 {
+	total := CPUTotalTime(&p.Usage)
+	if total == 0 {
+		return 0
+	}
+	return 1 - (p.Usage.Idle / total)
+}
+
+// share of CPU time spent in a state
+func (p *CPUProbe) share(time float64) float64 {
+	total := CPUTotalTime(&p.Usage)
+	if total == 0 {
+		return 0
+	}
+	return time / total
+}
+
+func (p *CPUProbe) period() string {
+	if p.Sample.SinceBoot() {
+		return "since boot"
+	}
+	return fmt.Sprintf("over %v", p.Sample.Interval)
+}
+
+// busiest returns the online CPUs, the busiest first
+func (p *CPUProbe) busiest() []*CPUUsage {
+	sorted := append([]*CPUUsage{}, p.PerCPU...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Utilization() > sorted[j].Utilization() })
+	return sorted
+}
+
+// hotCPUThreshold is the utilization of a saturated CPU
+const hotCPUThreshold = 0.9
+
+// imbalance returns the saturated CPUs, when they're a minority while the rest are mostly idle,
+// and the average utilization of the rest
+func (p *CPUProbe) imbalance() (hot []*CPUUsage, rest float64) {
+	if len(p.PerCPU) < 2 {
+		return nil, 0
+	}
+	var others []*CPUUsage
+	for _, u := range p.PerCPU {
+		if u.Utilization() >= hotCPUThreshold {
+			hot = append(hot, u)
+		} else {
+			others = append(others, u)
+		}
+	}
+	if len(hot) == 0 || len(hot) > len(p.PerCPU)/2 {
+		return nil, 0
+	}
+	for _, u := range others {
+		rest += u.Utilization()
+	}
+	rest /= float64(len(others))
+	if rest >= 0.5 {
+		return nil, 0
+	}
+	return hot, rest
+}
+
+// busiestShown is how many CPUs are listed on the busiest line
+const busiestShown = 4
+
+const displayFormat = `%v %v CPUs at %v utilization %v
+User: %v (niced %v), system: %v, iowait: %v, irq: %v, softirq: %v, stolen: %v, idle: %v
 `
 
 func (p *CPUProbe) Display() string {
 	bold := color.New(color.Bold)
-	total := CPUTotalTime(&p.Stat.CPUTotal)
-	var utilization float64 = 1 - (float64(p.Stat.CPUTotal.Idle) / total)
+	utilization := p.Utilization()
 	utilisationColor := format.ColorForUtilization(utilization, 0.95, 0.85, 0.5)
-	return fmt.Sprintf(displayFormat,
+	display := fmt.Sprintf(displayFormat,
 		emoji.Fire,
 		bold.Sprintf("%d", len(p.Stat.CPU)),
 		utilisationColor.Sprintf("%0.2f%%", utilization*100),
-		bold.Sprintf("%0.2f%%", p.Stat.CPUTotal.User/total*100),
-		bold.Sprintf("%0.2f%%", p.Stat.CPUTotal.Nice/total*100),
-		bold.Sprintf("%0.2f%%", p.Stat.CPUTotal.System/total*100),
-		bold.Sprintf("%0.2f%%", p.Stat.CPUTotal.Steal/total*100),
-		bold.Sprintf("%0.2f%%", p.Stat.CPUTotal.Idle/total*100),
+		p.period(),
+		bold.Sprintf("%0.2f%%", p.share(p.Usage.User)*100),
+		bold.Sprintf("%0.2f%%", p.share(p.Usage.Nice)*100),
+		bold.Sprintf("%0.2f%%", p.share(p.Usage.System)*100),
+		bold.Sprintf("%0.2f%%", p.share(p.Usage.Iowait)*100),
+		bold.Sprintf("%0.2f%%", p.share(p.Usage.IRQ)*100),
+		bold.Sprintf("%0.2f%%", p.share(p.Usage.SoftIRQ)*100),
+		bold.Sprintf("%0.2f%%", p.share(p.Usage.Steal)*100),
+		bold.Sprintf("%0.2f%%", p.share(p.Usage.Idle)*100),
 	)
+	if len(p.PerCPU) < 2 {
+		return display
+	}
+	var busiest []string
+	for i, u := range p.busiest() {
+		if i == busiestShown {
+			break
+		}
+		busiest = append(busiest, fmt.Sprintf("cpu%d %v", u.CPU,
+			format.ColorForUtilization(u.Utilization(), 0.95, 0.85, 0.5).Sprintf("%0.0f%%", u.Utilization()*100)))
+	}
+	return display + fmt.Sprintf("Busiest: %s\n", strings.Join(busiest, ", "))
+}
+
+func (p *CPUProbe) Serialize() interface{} {
+	return struct {
+		Stat            *procfs.Stat
+		IntervalSeconds float64
+		Usage           procfs.CPUStat
+		Utilization     float64
+		PerCPU          []*CPUUsage
+	}{
+		Stat:            p.Stat,
+		IntervalSeconds: p.Sample.Interval.Seconds(),
+		Usage:           p.Usage,
+		Utilization:     p.Utilization(),
+		PerCPU:          p.PerCPU,
+	}
+}
+
+// SinceBoot tells whether the utilization is averaged since boot
+func (p *CPUProbe) SinceBoot() bool {
+	return p.Sample.SinceBoot()
 }
 
 func (p *CPUProbe) Analysis() (observations []*analysis.Observation) {
-	// TODO detect when CPUs are not equally busy
+	if utilization := p.Utilization(); utilization > 0.9 {
+		observations = append(observations, &analysis.Observation{
+			Type:    analysis.Warning,
+			Message: fmt.Sprintf("CPUs are %0.0f%% busy %s", utilization*100, p.period()),
+			See:     "mpstat",
+		})
+	}
+	if iowait := p.share(p.Usage.Iowait); iowait > 0.2 {
+		observations = append(observations, &analysis.Observation{
+			Type:    analysis.Warning,
+			Message: fmt.Sprintf("CPUs spend %0.0f%% of time waiting on I/O %s", iowait*100, p.period()),
+			See:     "iostat",
+		})
+	}
+	if steal := p.share(p.Usage.Steal); steal > 0.1 {
+		observations = append(observations, &analysis.Observation{
+			Type:    analysis.Warning,
+			Message: fmt.Sprintf("%0.0f%% of CPU time is stolen by the hypervisor %s - noisy neighbours or an undersized instance", steal*100, p.period()),
+		})
+	}
+	if softirq := p.share(p.Usage.SoftIRQ); softirq > 0.2 {
+		observations = append(observations, &analysis.Observation{
+			Type:    analysis.Warning,
+			Message: fmt.Sprintf("CPUs spend %0.0f%% of time in softirqs %s, usually network packet processing", softirq*100, p.period()),
+		})
+	}
+	hot, rest := p.imbalance()
+	for _, u := range hot {
+		observations = append(observations, p.hotCPUObservation(u, rest))
+	}
 	return
 }
+
+// irqBound is the share of time in interrupt handlers that makes a hot CPU an IRQ affinity problem
+const irqBound = 0.3
+
+// hotCPUObservation explains a saturated CPU, with the IRQs and softirqs concentrated on it
+func (p *CPUProbe) hotCPUObservation(u *CPUUsage, rest float64) *analysis.Observation {
+	message := fmt.Sprintf("cpu%d is %0.0f%% busy %s while the other CPUs average %0.0f%%",
+		u.CPU, u.Utilization()*100, p.period(), rest*100)
+	irq := u.Share(u.IRQ + u.SoftIRQ)
+	if irq < irqBound {
+		return &analysis.Observation{
+			Type:    analysis.Warning,
+			Message: message + " - likely a single-threaded bottleneck, look for the thread pegging it",
+			See:     "pidstat",
+		}
+	}
+	message += fmt.Sprintf(", %0.0f%% of it handling interrupts", irq*100)
+	var handlers []string
+	for _, share := range p.Sample.TopSoftIRQs(u.CPU, 2) {
+		handlers = append(handlers, "softirq "+share.String())
+	}
+	for _, share := range p.Sample.TopInterrupts(u.CPU, 2) {
+		handlers = append(handlers, "IRQ "+share.String())
+	}
+	if len(handlers) > 0 {
+		message += ": " + strings.Join(handlers, ", ")
+	}
+	return &analysis.Observation{
+		Type:    analysis.Warning,
+		Message: message + " - check /proc/irq/*/smp_affinity, irqbalance and RPS/RSS to spread them",
+	}
+}
@@ -0,0 +1,118 @@
+package cpu
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/procfs"
+)
+
+const interruptsBefore = `           CPU0       CPU1       CPU2       CPU3
+ 24:          1          0          0          0  IO-APIC   5-edge      ACPI:Ged
+ 45:       1000          0          0          0  PCI-MSIX-0000:00:05.0   1-edge      eth0-rx-0
+LOC:        100        100        100        100   Local timer interrupts
+ERR:          0
+`
+
+const interruptsAfter = `           CPU0       CPU1       CPU2       CPU3
+ 24:          1          0          0          0  IO-APIC   5-edge      ACPI:Ged
+ 45:      91000          0          0          0  PCI-MSIX-0000:00:05.0   1-edge      eth0-rx-0
+LOC:     900100        100        100        100   Local timer interrupts
+ERR:          0
+`
+
+const softirqsBefore = `                    CPU0       CPU1       CPU2       CPU3
+          HI:          0          0          0          0
+       TIMER:        100        100        100        100
+      NET_RX:        100         10         10         10
+`
+
+const softirqsAfter = `                    CPU0       CPU1       CPU2       CPU3
+          HI:          0          0          0          0
+       TIMER:     900100        100        100        100
+      NET_RX:      50100         20         10         10
+`
+
+func parseIRQs(t *testing.T, interrupts, softirqs string) *IRQs {
+	t.Helper()
+	i, err := ParseIRQs(strings.NewReader(interrupts))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := ParseIRQs(strings.NewReader(softirqs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &IRQs{Interrupts: i, SoftIRQs: s}
+}
+
+func TestParseIRQs(t *testing.T) {
+	irqs := parseIRQs(t, interruptsAfter, softirqsAfter)
+	if len(irqs.Interrupts) != 4 {
+		t.Fatalf("Expected 4 interrupts, got %d", len(irqs.Interrupts))
+	}
+	eth := irqs.Interrupts[1]
+	if eth.Name != "45" || eth.PerCPU[0] != 91000 || eth.Description != "PCI-MSIX-0000:00:05.0 1-edge eth0-rx-0" {
+		t.Errorf("Unexpected %+v", eth)
+	}
+	if err := irqs.Interrupts[3]; err.Name != "ERR" || len(err.PerCPU) != 1 {
+		t.Errorf("Unexpected %+v", err)
+	}
+}
+
+func TestHotCPU(t *testing.T) {
+	idle := procfs.CPUStat{Idle: 100}
+	before := &procfs.Stat{CPU: []procfs.CPUStat{idle, idle, idle, idle}}
+	after := &procfs.Stat{CPU: []procfs.CPUStat{
+		{System: 45, SoftIRQ: 50, Idle: 105},
+		{User: 10, Idle: 190},
+		{User: 5, Idle: 195},
+		{Idle: 200},
+	}}
+	for _, c := range after.CPU {
+		after.CPUTotal.User += c.User
+		after.CPUTotal.System += c.System
+		after.CPUTotal.SoftIRQ += c.SoftIRQ
+		after.CPUTotal.Idle += c.Idle
+	}
+	before.CPUTotal.Idle = 400
+	p := NewCPUProbeFromSample(&Sample{
+		Before:     before,
+		After:      after,
+		Interval:   time.Second,
+		Elapsed:    time.Second,
+		IRQsBefore: parseIRQs(t, interruptsBefore, softirqsBefore),
+		IRQsAfter:  parseIRQs(t, interruptsAfter, softirqsAfter),
+	})
+	if len(p.PerCPU) != 4 {
+		t.Fatalf("Expected 4 CPUs, got %d", len(p.PerCPU))
+	}
+	observations := p.Analysis()
+	if len(observations) != 1 {
+		t.Fatalf("Expected a single observation, got %d", len(observations))
+	}
+	message := observations[0].Message
+	for _, expected := range []string{"cpu0 is 95% busy", "softirq NET_RX (100% of them", "IRQ 45 PCI-MSIX-0000:00:05.0 1-edge eth0-rx-0 (100%"} {
+		if !strings.Contains(message, expected) {
+			t.Errorf("Expected %q in %q", expected, message)
+		}
+	}
+	if strings.Contains(message, "TIMER") || strings.Contains(message, "LOC") {
+		t.Errorf("Expected housekeeping interrupts to be left out of %q", message)
+	}
+}
+
+func TestRatesSinceBoot(t *testing.T) {
+	s := &Sample{
+		// the clock of the system the procfs is from doesn't matter, its uptime does
+		After:   &procfs.Stat{BootTime: 1, ProcessCreated: 5000, ContextSwitches: 100000},
+		Elapsed: 100 * time.Second,
+	}
+	if forks := s.ForkRate(); forks != 50 {
+		t.Errorf("Expected 50 forks/s, got %v", forks)
+	}
+	if switches := s.ContextSwitchRate(); switches != 1000 {
+		t.Errorf("Expected 1000 context switches/s, got %v", switches)
+	}
+}
@@ -0,0 +1,161 @@
+package cpu
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// IRQCounter is a row of /proc/interrupts or /proc/softirqs
+type IRQCounter struct {
+	// Name is the IRQ number, e.g. "24", or a type, e.g. "LOC" or "NET_RX"
+	Name string
+	// Description is the controller and device of an IRQ, e.g. "IO-APIC 4-edge ttyS0"
+	Description string
+	// PerCPU maps the CPU number to the count
+	PerCPU map[int]uint64
+}
+
+// IRQs holds the counters of /proc/interrupts and /proc/softirqs
+type IRQs struct {
+	Interrupts []*IRQCounter
+	SoftIRQs   []*IRQCounter
+}
+
+// ReadIRQs reads interrupts and softirqs from procfs mounted at procfsRoot
+func ReadIRQs(procfsRoot string) (*IRQs, error) {
+	interrupts, err := readIRQFile(filepath.Join(procfsRoot, "interrupts"))
+	if err != nil {
+		return nil, err
+	}
+	softirqs, err := readIRQFile(filepath.Join(procfsRoot, "softirqs"))
+	if err != nil {
+		return nil, err
+	}
+	return &IRQs{
+		Interrupts: interrupts,
+		SoftIRQs:   softirqs,
+	}, nil
+}
+
+func readIRQFile(path string) ([]*IRQCounter, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseIRQs(f)
+}
+
+// ParseIRQs parses the format shared by /proc/interrupts and /proc/softirqs
+func ParseIRQs(r io.Reader) ([]*IRQCounter, error) {
+	//            CPU0       CPU1
+	//   24:          1          0  IO-APIC   5-edge      ACPI:Ged
+	//  NMI:          0          0   Non-maskable interrupts
+	//  ERR:          0
+	scanner := bufio.NewScanner(r)
+	if !scanner.Scan() {
+		return nil, fmt.Errorf("no header found")
+	}
+	// offline CPUs are left out of the columns
+	var cpus []int
+	for _, column := range strings.Fields(scanner.Text()) {
+		n, err := strconv.Atoi(strings.TrimPrefix(column, "CPU"))
+		if err != nil {
+			return nil, fmt.Errorf("unexpected column %q", column)
+		}
+		cpus = append(cpus, n)
+	}
+	var counters []*IRQCounter
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || !strings.HasSuffix(fields[0], ":") {
+			continue
+		}
+		c := &IRQCounter{
+			Name:   strings.TrimSuffix(fields[0], ":"),
+			PerCPU: make(map[int]uint64),
+		}
+		i := 1
+		for ; i < len(fields) && i <= len(cpus); i++ {
+			count, err := strconv.ParseUint(fields[i], 10, 64)
+			if err != nil {
+				break
+			}
+			c.PerCPU[cpus[i-1]] = count
+		}
+		c.Description = strings.Join(fields[i:], " ")
+		counters = append(counters, c)
+	}
+	return counters, scanner.Err()
+}
+
+// IRQShare is how much of an IRQ's activity over the sample was on a single CPU
+type IRQShare struct {
+	Counter *IRQCounter
+	// Count on the CPU
+	Count uint64
+	// Share of the count across all CPUs
+	Share float64
+}
+
+func (s *IRQShare) String() string {
+	name := s.Counter.Name
+	if s.Counter.Description != "" {
+		name += " " + s.Counter.Description
+	}
+	return fmt.Sprintf("%s (%0.0f%% of them on this CPU)", name, s.Share*100)
+}
+
+// housekeeping softirqs run everywhere, and don't point at a device
+var housekeepingSoftIRQs = map[string]bool{"TIMER": true, "HRTIMER": true, "SCHED": true, "RCU": true}
+
+// deviceInterrupt tells numbered IRQs of devices apart from e.g. the local timer (LOC)
+func deviceInterrupt(c *IRQCounter) bool {
+	_, err := strconv.Atoi(c.Name)
+	return err == nil
+}
+
+func deviceSoftIRQ(c *IRQCounter) bool {
+	return !housekeepingSoftIRQs[c.Name]
+}
+
+// topIRQs returns the counters busiest on the CPU between the snapshots, before can be nil
+func topIRQs(before, after []*IRQCounter, include func(*IRQCounter) bool, cpu int, top int) (shares []*IRQShare) {
+	previous := make(map[string]*IRQCounter)
+	for _, c := range before {
+		previous[c.Name] = c
+	}
+	for _, c := range after {
+		if !include(c) {
+			continue
+		}
+		deltas := make(map[int]uint64)
+		var total uint64
+		for n, count := range c.PerCPU {
+			if p, ok := previous[c.Name]; ok && p.PerCPU[n] <= count {
+				count -= p.PerCPU[n]
+			}
+			deltas[n] = count
+			total += count
+		}
+		if deltas[cpu] == 0 {
+			continue
+		}
+		shares = append(shares, &IRQShare{
+			Counter: c,
+			Count:   deltas[cpu],
+			Share:   float64(deltas[cpu]) / float64(total),
+		})
+	}
+	sort.Slice(shares, func(i, j int) bool { return shares[i].Count > shares[j].Count })
+	if len(shares) > top {
+		shares = shares[:top]
+	}
+	return
+}
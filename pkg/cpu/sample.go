@@ -0,0 +1,160 @@
+package cpu
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/procfs"
+	"github.com/sredog/sre/pkg/analysis"
+)
+
+// Sample holds two snapshots of /proc/stat taken Interval apart.
+// Without Before the counters are averaged since boot.
+type Sample struct {
+	Before   *procfs.Stat
+	After    *procfs.Stat
+	Interval time.Duration
+	// Elapsed is the time the counters were collected over, the uptime without Before
+	Elapsed time.Duration
+	// IRQsBefore and IRQsAfter are snapshots of /proc/interrupts and /proc/softirqs,
+	// nil when they couldn't be read
+	IRQsBefore *IRQs
+	IRQsAfter  *IRQs
+}
+
+// snapshot is what a Sample reads at once
+type snapshot struct {
+	stat *procfs.Stat
+	irqs *IRQs
+}
+
+// TakeSample reads /proc/stat twice, interval apart, or once for averages since boot.
+// The IRQ counters of procfs mounted at procfsRoot are read along, if possible.
+func TakeSample(ctx context.Context, provider StatProvider, procfsRoot string, interval time.Duration) (*Sample, error) {
+	snapshots, err := analysis.TakeSample(ctx, procfsRoot, interval, func() (*snapshot, error) {
+		stat, err := provider.Stat()
+		if err != nil {
+			return nil, err
+		}
+		irqs, _ := ReadIRQs(procfsRoot)
+		return &snapshot{stat: &stat, irqs: irqs}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s := &Sample{
+		After:     snapshots.After.stat,
+		IRQsAfter: snapshots.After.irqs,
+		Interval:  interval,
+		Elapsed:   snapshots.Elapsed,
+	}
+	if snapshots.Before != nil {
+		s.Before = snapshots.Before.stat
+		s.IRQsBefore = snapshots.Before.irqs
+	}
+	if s.IRQsAfter == nil {
+		s.IRQsBefore = nil
+	}
+	return s, nil
+}
+
+// SharedSample reads /proc/stat and the IRQ counters once per environment, for both the cpu
+// and processes probes
+func SharedSample(ctx context.Context, env *analysis.Environment) (*Sample, error) {
+	v, err := env.Shared("cpu.Sample", func() (interface{}, error) {
+		fs, err := env.Roots.ProcFS()
+		if err != nil {
+			return nil, err
+		}
+		return TakeSample(ctx, fs, env.Roots.Proc, env.Interval)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*Sample), nil
+}
+
+// SinceBoot tells whether the counters are averaged since boot
+func (s *Sample) SinceBoot() bool {
+	return s.Before == nil
+}
+
+// SubtractCPUStat returns the time spent in each state between the snapshots
+func SubtractCPUStat(after, before procfs.CPUStat) procfs.CPUStat {
+	return procfs.CPUStat{
+		User:      after.User - before.User,
+		Nice:      after.Nice - before.Nice,
+		System:    after.System - before.System,
+		Idle:      after.Idle - before.Idle,
+		Iowait:    after.Iowait - before.Iowait,
+		IRQ:       after.IRQ - before.IRQ,
+		SoftIRQ:   after.SoftIRQ - before.SoftIRQ,
+		Steal:     after.Steal - before.Steal,
+		Guest:     after.Guest - before.Guest,
+		GuestNice: after.GuestNice - before.GuestNice,
+	}
+}
+
+// CPUTotal returns the time all CPUs spent in each state over the sample
+func (s *Sample) CPUTotal() procfs.CPUStat {
+	if s.Before == nil {
+		return s.After.CPUTotal
+	}
+	return SubtractCPUStat(s.After.CPUTotal, s.Before.CPUTotal)
+}
+
+// CPU returns the time the i-th CPU spent in each state over the sample
+func (s *Sample) CPU(i int) procfs.CPUStat {
+	if s.Before == nil || i >= len(s.Before.CPU) {
+		return s.After.CPU[i]
+	}
+	return SubtractCPUStat(s.After.CPU[i], s.Before.CPU[i])
+}
+
+// rate of a counter per second over the sample
+func (s *Sample) rate(counter func(*procfs.Stat) uint64) float64 {
+	delta := counter(s.After)
+	if s.Before != nil {
+		delta -= counter(s.Before)
+	}
+	return float64(delta) / s.Elapsed.Seconds()
+}
+
+// ContextSwitchRate returns the context switches per second
+func (s *Sample) ContextSwitchRate() float64 {
+	return s.rate(func(stat *procfs.Stat) uint64 { return stat.ContextSwitches })
+}
+
+// ForkRate returns the processes created per second
+func (s *Sample) ForkRate() float64 {
+	return s.rate(func(stat *procfs.Stat) uint64 { return stat.ProcessCreated })
+}
+
+// InterruptRate returns the interrupts handled per second
+func (s *Sample) InterruptRate() float64 {
+	return s.rate(func(stat *procfs.Stat) uint64 { return stat.IRQTotal })
+}
+
+// TopInterrupts returns the device IRQs handled the most by the CPU over the sample
+func (s *Sample) TopInterrupts(cpu int, top int) []*IRQShare {
+	if s.IRQsAfter == nil {
+		return nil
+	}
+	var before []*IRQCounter
+	if s.IRQsBefore != nil {
+		before = s.IRQsBefore.Interrupts
+	}
+	return topIRQs(before, s.IRQsAfter.Interrupts, deviceInterrupt, cpu, top)
+}
+
+// TopSoftIRQs returns the softirqs, other than the housekeeping ones, run the most by the CPU over the sample
+func (s *Sample) TopSoftIRQs(cpu int, top int) []*IRQShare {
+	if s.IRQsAfter == nil {
+		return nil
+	}
+	var before []*IRQCounter
+	if s.IRQsBefore != nil {
+		before = s.IRQsBefore.SoftIRQs
+	}
+	return topIRQs(before, s.IRQsAfter.SoftIRQs, deviceSoftIRQ, cpu, top)
+}
@@ -0,0 +1,282 @@
+// Package disk samples /proc/diskstats and reports the metrics of iostat -x
+// See https://www.kernel.org/doc/Documentation/ABI/testing/procfs-diskstats
+// and https://www.kernel.org/doc/Documentation/iostats.txt
+package disk
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/enescakir/emoji"
+	"github.com/fatih/color"
+	"github.com/prometheus/procfs/blockdevice"
+	"github.com/sredog/sre/pkg/analysis"
+	"github.com/sredog/sre/pkg/format"
+	"github.com/sredog/sre/pkg/fsroot"
+)
+
+// sectorSize is the unit of the sector counters in /proc/diskstats, regardless of the device
+const sectorSize = 512
+
+var virtualDiskRE = regexp.MustCompile(`^(loop|ram|zram)\d+$`)
+
+// IsWholeDisk tells apart disks from partitions (which don't show up in /sys/block)
+// and virtual loop and ram devices
+func IsWholeDisk(roots *fsroot.Roots, name string) bool {
+	if virtualDiskRE.MatchString(name) {
+		return false
+	}
+	_, err := os.Stat(roots.SysPath("block", name))
+	return err == nil
+}
+
+// ReadDiskstats reads the counters of the whole disks, or of every device with all
+func ReadDiskstats(roots *fsroot.Roots, all bool) (map[string]blockdevice.IOStats, error) {
+	blockFS, err := roots.BlockFS()
+	if err != nil {
+		return nil, err
+	}
+	diskstats, err := blockFS.ProcDiskstats()
+	if err != nil {
+		return nil, err
+	}
+	disks := make(map[string]blockdevice.IOStats)
+	for _, d := range diskstats {
+		if all || IsWholeDisk(roots, d.DeviceName) {
+			disks[d.DeviceName] = d.IOStats
+		}
+	}
+	return disks, nil
+}
+
+// Sample is /proc/diskstats before and after the interval
+type Sample analysis.Sample[map[string]blockdevice.IOStats]
+
+// TakeSample samples the counters of every device, or only of the whole disks
+func TakeSample(ctx context.Context, roots *fsroot.Roots, interval time.Duration, all bool) (*Sample, error) {
+	s, err := analysis.TakeSample(ctx, roots.Proc, interval, func() (map[string]blockdevice.IOStats, error) {
+		return ReadDiskstats(roots, all)
+	})
+	return (*Sample)(s), err
+}
+
+// Device holds the iostat -x metrics of a single device over the sample
+type Device struct {
+	Name string
+	// ReadIOPS and WriteIOPS are the completed requests per second
+	ReadIOPS  float64
+	WriteIOPS float64
+	// ReadBytes and WriteBytes are the bytes transferred per second
+	ReadBytes  float64
+	WriteBytes float64
+	// Await is the average time in ms a request spent queued and served, like r_await and w_await
+	ReadAwait  float64
+	WriteAwait float64
+	Await      float64
+	// BootAwait is the average await since boot, to compare Await with
+	BootAwait float64
+	// QueueSize is the average number of requests in flight, aqu-sz
+	QueueSize float64
+	// Utilization is the share of time the device had requests in flight, %util
+	Utilization float64
+}
+
+// IOPS returns the requests completed per second
+func (d *Device) IOPS() float64 {
+	return d.ReadIOPS + d.WriteIOPS
+}
+
+// Throughput returns the bytes transferred per second
+func (d *Device) Throughput() float64 {
+	return d.ReadBytes + d.WriteBytes
+}
+
+// await returns the average ms per request, 0 without requests
+func await(ticks, ios uint64) float64 {
+	if ios == 0 {
+		return 0
+	}
+	return float64(ticks) / float64(ios)
+}
+
+// delta returns how much a counter went up, 0 when it went back, e.g. a device
+// removed and plugged back in while sampling
+func delta(before, after uint64) uint64 {
+	if after < before {
+		return 0
+	}
+	return after - before
+}
+
+// NewDevice computes the metrics between the counters, before can be the zero value for since boot
+func NewDevice(name string, before, after blockdevice.IOStats, elapsed time.Duration) *Device {
+	seconds := elapsed.Seconds()
+	ms := seconds * 1000
+	readIOs := delta(before.ReadIOs, after.ReadIOs)
+	writeIOs := delta(before.WriteIOs, after.WriteIOs)
+	readTicks := delta(before.ReadTicks, after.ReadTicks)
+	writeTicks := delta(before.WriteTicks, after.WriteTicks)
+	d := &Device{
+		Name:        name,
+		ReadIOPS:    float64(readIOs) / seconds,
+		WriteIOPS:   float64(writeIOs) / seconds,
+		ReadBytes:   float64(delta(before.ReadSectors, after.ReadSectors)) * sectorSize / seconds,
+		WriteBytes:  float64(delta(before.WriteSectors, after.WriteSectors)) * sectorSize / seconds,
+		ReadAwait:   await(readTicks, readIOs),
+		WriteAwait:  await(writeTicks, writeIOs),
+		Await:       await(readTicks+writeTicks, readIOs+writeIOs),
+		BootAwait:   await(after.ReadTicks+after.WriteTicks, after.ReadIOs+after.WriteIOs),
+		QueueSize:   float64(delta(before.WeightedIOTicks, after.WeightedIOTicks)) / ms,
+		Utilization: float64(delta(before.IOsTotalTicks, after.IOsTotalTicks)) / ms,
+	}
+	// the ticks are updated in batches, and can run ahead of the wall clock
+	if d.Utilization > 1 {
+		d.Utilization = 1
+	}
+	return d
+}
+
+type DiskProbe struct {
+	// Devices sorted by name
+	Devices  []*Device
+	Interval time.Duration
+}
+
+// NewDiskProbe reads /proc/diskstats twice, interval apart, or once for the metrics since boot.
+// Partitions, loop and ram devices are left out unless all is set.
+func NewDiskProbe(ctx context.Context, roots *fsroot.Roots, interval time.Duration, all bool) (*DiskProbe, error) {
+	s, err := TakeSample(ctx, roots, interval, all)
+	if err != nil {
+		return nil, err
+	}
+	return NewDiskProbeFromSample(s), nil
+}
+
+// NewDiskProbeFromSample works out the iostat -x metrics of the devices in the sample, leaving
+// out the ones plugged in while sampling
+func NewDiskProbeFromSample(s *Sample) *DiskProbe {
+	p := &DiskProbe{
+		Interval: s.Interval,
+	}
+	for name, after := range s.After {
+		var before blockdevice.IOStats
+		if s.Before != nil {
+			var ok bool
+			// devices plugged in while sampling
+			if before, ok = s.Before[name]; !ok {
+				continue
+			}
+		}
+		p.Devices = append(p.Devices, NewDevice(name, before, after, s.Elapsed))
+	}
+	sort.Slice(p.Devices, func(i, j int) bool { return p.Devices[i].Name < p.Devices[j].Name })
+	return p
+}
+
+func init() {
+	analysis.Register(&analysis.ProbeConfiguration{
+		ID:          "disk",
+		Aliases:     []string{"io", "iostat"},
+		Description: "Disk IOPS, throughput, latency, queue size and utilization over the interval",
+		Build: func(ctx context.Context, env *analysis.Environment) (analysis.Probe, error) {
+			return NewDiskProbe(ctx, env.Roots, env.Interval, false)
+		},
+	})
+}
+
+func (p *DiskProbe) period() string {
+	if p.Interval == 0 {
+		return "since boot"
+	}
+	return fmt.Sprintf("over %v", p.Interval)
+}
+
+// Busiest returns the device with the highest utilization, nil without devices
+func (p *DiskProbe) Busiest() *Device {
+	var busiest *Device
+	for _, d := range p.Devices {
+		if busiest == nil || d.Utilization > busiest.Utilization {
+			busiest = d
+		}
+	}
+	return busiest
+}
+
+const displayFormat = "%v %v disk(s) %v\n"
+
+const columns = "%-12s %9s %9s %11s %11s %9s %9s %7s %7s\n"
+
+func (p *DiskProbe) Display() string {
+	bold := color.New(color.Bold)
+	var sb strings.Builder
+	fmt.Fprintf(&sb, displayFormat, emoji.FloppyDisk, bold.Sprint(len(p.Devices)), p.period())
+	if len(p.Devices) == 0 {
+		return sb.String()
+	}
+	fmt.Fprintf(&sb, columns, "Device", "r/s", "w/s", "rB/s", "wB/s", "r_await", "w_await", "aqu-sz", "%util")
+	for _, d := range p.Devices {
+		// padded before coloring, the escape codes would throw the widths off
+		util := fmt.Sprintf("%7.1f", d.Utilization*100)
+		fmt.Fprintf(&sb, columns,
+			d.Name,
+			fmt.Sprintf("%0.1f", d.ReadIOPS),
+			fmt.Sprintf("%0.1f", d.WriteIOPS),
+			humanize.Bytes(uint64(d.ReadBytes)),
+			humanize.Bytes(uint64(d.WriteBytes)),
+			fmt.Sprintf("%0.2f", d.ReadAwait),
+			fmt.Sprintf("%0.2f", d.WriteAwait),
+			fmt.Sprintf("%0.2f", d.QueueSize),
+			format.ColorForUtilization(d.Utilization, 0.9, 0.7, 0.4).Sprint(util),
+		)
+	}
+	return sb.String()
+}
+
+func (p *DiskProbe) Serialize() interface{} {
+	return struct {
+		IntervalSeconds float64
+		Devices         []*Device
+	}{
+		IntervalSeconds: p.Interval.Seconds(),
+		Devices:         p.Devices,
+	}
+}
+
+// risingAwait is how many times slower than its average since boot a device has to get
+// to be worth mentioning, as long as it takes at least minAwait ms
+const (
+	risingAwait = 2
+	minAwait    = 10
+)
+
+// SinceBoot tells whether the metrics are averaged since boot
+func (p *DiskProbe) SinceBoot() bool {
+	return p.Interval == 0
+}
+
+func (p *DiskProbe) Analysis() (observations []*analysis.Observation) {
+	for _, d := range p.Devices {
+		if d.Utilization > 0.9 {
+			observations = append(observations, &analysis.Observation{
+				Type: analysis.Warning,
+				Message: fmt.Sprintf("%s is %0.0f%% utilized %s with %0.1f requests queued on average - devices serving requests in parallel (SSD, NVMe, RAID) may have headroom left, check the await",
+					d.Name, d.Utilization*100, p.period(), d.QueueSize),
+				See: "iostat",
+			})
+		}
+		if p.Interval > 0 && d.IOPS() > 0 && d.Await >= minAwait && d.Await > risingAwait*d.BootAwait {
+			observations = append(observations, &analysis.Observation{
+				Type:    analysis.Warning,
+				Message: fmt.Sprintf("%s requests take %0.1fms %s, up from %0.1fms on average since boot", d.Name, d.Await, p.period(), d.BootAwait),
+				See:     "biolatency",
+			})
+		}
+	}
+	return
+}
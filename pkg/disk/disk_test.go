@@ -0,0 +1,60 @@
+package disk
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/procfs/blockdevice"
+)
+
+func TestDevice(t *testing.T) {
+	before := blockdevice.IOStats{ReadIOs: 1000, ReadTicks: 1000, WriteIOs: 1000, WriteTicks: 1000}
+	after := blockdevice.IOStats{
+		ReadIOs:         1100,
+		ReadSectors:     2048,
+		ReadTicks:       4000,
+		WriteIOs:        1100,
+		WriteTicks:      3000,
+		IOsTotalTicks:   1950,
+		WeightedIOTicks: 8000,
+	}
+	d := NewDevice("sda", before, after, 2*time.Second)
+	for _, c := range []struct {
+		name          string
+		got, expected float64
+	}{
+		{"r/s", d.ReadIOPS, 50},
+		{"w/s", d.WriteIOPS, 50},
+		{"rB/s", d.ReadBytes, 512 * 1024},
+		{"r_await", d.ReadAwait, 30},
+		{"w_await", d.WriteAwait, 20},
+		{"await", d.Await, 25},
+		{"aqu-sz", d.QueueSize, 4},
+		{"%util", d.Utilization, 0.975},
+	} {
+		if c.got != c.expected {
+			t.Errorf("Expected %s %v, got %v", c.name, c.expected, c.got)
+		}
+	}
+
+	p := &DiskProbe{Devices: []*Device{d}, Interval: 2 * time.Second}
+	var messages []string
+	for _, o := range p.Analysis() {
+		messages = append(messages, o.Message)
+	}
+	all := strings.Join(messages, "\n")
+	if !strings.Contains(all, "sda is 98% utilized") || !strings.Contains(all, "sda requests take 25.0ms") {
+		t.Errorf("Expected high utilization and rising await, got:\n%s", all)
+	}
+}
+
+func TestDeviceCounterReset(t *testing.T) {
+	// the device went away and came back while sampling, its counters starting over
+	before := blockdevice.IOStats{ReadIOs: 1000, ReadSectors: 8000, ReadTicks: 1000, IOsTotalTicks: 5000, WeightedIOTicks: 5000}
+	after := blockdevice.IOStats{ReadIOs: 10, ReadSectors: 80, ReadTicks: 10, IOsTotalTicks: 50, WeightedIOTicks: 50}
+	d := NewDevice("sdb", before, after, time.Second)
+	if d.ReadIOPS != 0 || d.ReadBytes != 0 || d.ReadAwait != 0 || d.QueueSize != 0 || d.Utilization != 0 {
+		t.Errorf("Expected no activity from counters going back, got %+v", d)
+	}
+}
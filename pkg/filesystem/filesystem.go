@@ -0,0 +1,272 @@
+// Package filesystem reports the space and inode usage of the mounted filesystems,
+// and the ones the kernel flipped to read-only after errors
+// See https://man7.org/linux/man-pages/man5/proc.5.html (/proc/[pid]/mountinfo)
+// and https://man7.org/linux/man-pages/man2/statfs.2.html
+package filesystem
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/dustin/go-humanize"
+	"github.com/enescakir/emoji"
+	"github.com/fatih/color"
+	"github.com/prometheus/procfs"
+	"github.com/sredog/sre/pkg/analysis"
+	"github.com/sredog/sre/pkg/format"
+)
+
+// pseudoFilesystems don't store anything on a device, or are layers of something else
+var pseudoFilesystems = map[string]bool{
+	"autofs":      true,
+	"binfmt_misc": true,
+	"bpf":         true,
+	"cgroup":      true,
+	"cgroup2":     true,
+	"configfs":    true,
+	"debugfs":     true,
+	"devpts":      true,
+	"devtmpfs":    true,
+	"efivarfs":    true,
+	"fusectl":     true,
+	"hugetlbfs":   true,
+	"mqueue":      true,
+	"nsfs":        true,
+	"overlay":     true,
+	"proc":        true,
+	"pstore":      true,
+	"ramfs":       true,
+	"rpc_pipefs":  true,
+	"securityfs":  true,
+	"squashfs":    true,
+	"sysfs":       true,
+	"tmpfs":       true,
+	"tracefs":     true,
+}
+
+// IsPseudo tells whether the filesystem type is skipped by default
+func IsPseudo(fsType string) bool {
+	return pseudoFilesystems[fsType]
+}
+
+// statfs is swapped in tests
+var statfs = syscall.Statfs
+
+type SelfProvider interface {
+	Self() (procfs.Proc, error)
+}
+
+// Mount is a filesystem with its usage
+type Mount struct {
+	MountPoint string
+	Source     string
+	FSType     string
+	// ReadOnly is set when the mount or the filesystem is read-only
+	ReadOnly bool
+	// RemountedReadOnly is set when the filesystem went read-only while the mount is
+	// read-write, which is what the kernel does after errors (errors=remount-ro)
+	RemountedReadOnly bool
+	// Sizes are in bytes, Available is what's left to unprivileged users
+	Size      uint64
+	Used      uint64
+	Available uint64
+	// Inodes is 0 for filesystems without a fixed number of them, e.g. btrfs
+	Inodes     uint64
+	InodesUsed uint64
+	// Error is set when statfs failed
+	Error string `json:",omitempty"`
+}
+
+// Utilization returns the share of the space used, counting the reserved blocks as df does
+func (m *Mount) Utilization() float64 {
+	if m.Used+m.Available == 0 {
+		return 0
+	}
+	return float64(m.Used) / float64(m.Used+m.Available)
+}
+
+// InodeUtilization returns the share of the inodes used
+func (m *Mount) InodeUtilization() float64 {
+	if m.Inodes == 0 {
+		return 0
+	}
+	return float64(m.InodesUsed) / float64(m.Inodes)
+}
+
+// unescape decodes the octal escapes of mountinfo, e.g. \040 for a space
+func unescape(path string) string {
+	if !strings.Contains(path, `\`) {
+		return path
+	}
+	var sb strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] == '\\' && i+3 < len(path) {
+			if c, err := strconv.ParseUint(path[i+1:i+4], 8, 8); err == nil {
+				sb.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		sb.WriteByte(path[i])
+	}
+	return sb.String()
+}
+
+// NewMount statfs the mount point, the error is recorded on the mount
+func NewMount(info *procfs.MountInfo) *Mount {
+	_, mountRO := info.Options["ro"]
+	_, superRO := info.SuperOptions["ro"]
+	m := &Mount{
+		MountPoint:        unescape(info.MountPoint),
+		Source:            unescape(info.Source),
+		FSType:            info.FSType,
+		ReadOnly:          mountRO || superRO,
+		RemountedReadOnly: superRO && !mountRO,
+	}
+	var st syscall.Statfs_t
+	if err := statfs(m.MountPoint, &st); err != nil {
+		m.Error = err.Error()
+		return m
+	}
+	blockSize := uint64(st.Frsize)
+	if blockSize == 0 {
+		blockSize = uint64(st.Bsize)
+	}
+	m.Size = st.Blocks * blockSize
+	m.Used = (st.Blocks - st.Bfree) * blockSize
+	m.Available = st.Bavail * blockSize
+	m.Inodes = st.Files
+	m.InodesUsed = st.Files - st.Ffree
+	return m
+}
+
+type FilesystemProbe struct {
+	// Mounts in the order of mountinfo
+	Mounts []*Mount
+}
+
+// NewFilesystemProbe reads the mounts of the process reading procfs, and statfs each of them.
+// Pseudo filesystems are left out unless all is set, and so are mounts of a device already seen,
+// e.g. bind mounts.
+func NewFilesystemProbe(ctx context.Context, provider SelfProvider, all bool) (*FilesystemProbe, error) {
+	self, err := provider.Self()
+	if err != nil {
+		return nil, err
+	}
+	infos, err := self.MountInfo()
+	if err != nil {
+		return nil, err
+	}
+	p := &FilesystemProbe{}
+	seen := make(map[string]bool)
+	for _, info := range infos {
+		// statfs of a hung NFS mount blocks, the rest are given up on
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if !all && (IsPseudo(info.FSType) || seen[info.MajorMinorVer]) {
+			continue
+		}
+		seen[info.MajorMinorVer] = true
+		p.Mounts = append(p.Mounts, NewMount(info))
+	}
+	return p, nil
+}
+
+func init() {
+	analysis.Register(&analysis.ProbeConfiguration{
+		ID:          "filesystem",
+		Aliases:     []string{"fs", "df"},
+		Description: "Space and inode usage of the mounted filesystems, and read-only ones",
+		Build: func(ctx context.Context, env *analysis.Environment) (analysis.Probe, error) {
+			fs, err := env.Roots.ProcFS()
+			if err != nil {
+				return nil, err
+			}
+			return NewFilesystemProbe(ctx, fs, false)
+		},
+	})
+}
+
+const displayFormat = "%v %v filesystem(s)\n"
+
+func (p *FilesystemProbe) Display() string {
+	bold := color.New(color.Bold)
+	var sb strings.Builder
+	fmt.Fprintf(&sb, displayFormat, emoji.FileCabinet, bold.Sprint(len(p.Mounts)))
+	for _, m := range p.Mounts {
+		if m.Error != "" {
+			fmt.Fprintf(&sb, "%s (%s on %s): %s\n", bold.Sprint(m.MountPoint), m.FSType, m.Source, m.Error)
+			continue
+		}
+		inodes := "-"
+		if m.Inodes > 0 {
+			inodes = format.ColorForUtilization(m.InodeUtilization(), 0.95, 0.9, 0.75).Sprintf("%0.0f%%", m.InodeUtilization()*100)
+		}
+		ro := ""
+		if m.ReadOnly {
+			ro = ", " + bold.Sprint("read-only")
+		}
+		fmt.Fprintf(&sb, "%v used of %s, inodes %v: %s (%s on %s%s)\n",
+			format.ColorForUtilization(m.Utilization(), 0.95, 0.9, 0.75).Sprintf("%3.0f%%", m.Utilization()*100),
+			humanize.Bytes(m.Size),
+			inodes,
+			bold.Sprint(m.MountPoint),
+			m.FSType,
+			m.Source,
+			ro,
+		)
+	}
+	return sb.String()
+}
+
+func (p *FilesystemProbe) Serialize() interface{} {
+	return p
+}
+
+// usageObservation rates the usage of space or inodes
+func usageObservation(m *Mount, what string, utilization float64) *analysis.Observation {
+	var observationType analysis.ObservationType
+	switch {
+	case utilization >= 0.95:
+		observationType = analysis.Issue
+	case utilization >= 0.9:
+		observationType = analysis.Warning
+	default:
+		return nil
+	}
+	return &analysis.Observation{
+		Type:    observationType,
+		Message: fmt.Sprintf("%s (%s) has %0.0f%% of its %s used", m.MountPoint, m.Source, utilization*100, what),
+		See:     "df",
+	}
+}
+
+func (p *FilesystemProbe) Analysis() (observations []*analysis.Observation) {
+	for _, m := range p.Mounts {
+		if m.Error != "" {
+			observations = append(observations, &analysis.Observation{
+				Type:    analysis.Warning,
+				Message: fmt.Sprintf("Couldn't statfs %s: %s", m.MountPoint, m.Error),
+			})
+			continue
+		}
+		if m.RemountedReadOnly {
+			observations = append(observations, &analysis.Observation{
+				Type:    analysis.Issue,
+				Message: fmt.Sprintf("%s (%s) is mounted read-write but the filesystem went read-only, likely after errors - check the kernel log", m.MountPoint, m.Source),
+				See:     "dmesg",
+			})
+		}
+		if o := usageObservation(m, "space", m.Utilization()); o != nil {
+			observations = append(observations, o)
+		}
+		if o := usageObservation(m, "inodes", m.InodeUtilization()); o != nil {
+			observations = append(observations, o)
+		}
+	}
+	return
+}
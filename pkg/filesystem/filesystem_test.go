@@ -0,0 +1,36 @@
+package filesystem
+
+import (
+	"syscall"
+	"testing"
+
+	"github.com/prometheus/procfs"
+)
+
+func TestMount(t *testing.T) {
+	defer func(f func(string, *syscall.Statfs_t) error) { statfs = f }(statfs)
+	statfs = func(path string, st *syscall.Statfs_t) error {
+		if path != "/mnt/my data" {
+			t.Errorf("Expected the mount point to be unescaped, got %q", path)
+		}
+		*st = syscall.Statfs_t{Bsize: 4096, Frsize: 4096, Blocks: 1000, Bfree: 50, Bavail: 0, Files: 100, Ffree: 50}
+		return nil
+	}
+	m := NewMount(&procfs.MountInfo{
+		MountPoint:   `/mnt/my\040data`,
+		Source:       "/dev/sdb1",
+		FSType:       "ext4",
+		Options:      map[string]string{"rw": ""},
+		SuperOptions: map[string]string{"ro": "", "errors": "remount-ro"},
+	})
+	if !m.ReadOnly || !m.RemountedReadOnly {
+		t.Errorf("Expected a filesystem flipped to read-only, got %+v", m)
+	}
+	if m.Size != 4096000 || m.Utilization() != 1 || m.InodeUtilization() != 0.5 {
+		t.Errorf("Unexpected usage %+v", m)
+	}
+	observations := (&FilesystemProbe{Mounts: []*Mount{m}}).Analysis()
+	if len(observations) != 2 {
+		t.Fatalf("Expected the read-only flip and full space, got %d observations", len(observations))
+	}
+}
@@ -0,0 +1,53 @@
+// Package fsroot tells the probes where procfs and sysfs are mounted.
+// Pointing it somewhere else than /proc and /sys lets sre analyse a container's
+// host-mounted /host/proc, or a copy of /proc taken from another machine.
+package fsroot
+
+import (
+	"path/filepath"
+
+	"github.com/prometheus/procfs"
+	"github.com/prometheus/procfs/blockdevice"
+	"github.com/prometheus/procfs/sysfs"
+)
+
+const (
+	DefaultProc = "/proc"
+	DefaultSys  = "/sys"
+)
+
+// Roots holds the mount points of procfs and sysfs
+type Roots struct {
+	Proc string
+	Sys  string
+}
+
+// ProcPath joins the elements to the procfs root, e.g. ProcPath("sys", "kernel", "pid_max")
+func (r *Roots) ProcPath(elem ...string) string {
+	return filepath.Join(append([]string{r.Proc}, elem...)...)
+}
+
+// SysPath joins the elements to the sysfs root, e.g. SysPath("class", "net")
+func (r *Roots) SysPath(elem ...string) string {
+	return filepath.Join(append([]string{r.Sys}, elem...)...)
+}
+
+// CgroupPath returns where cgroupfs is mounted under the sysfs root
+func (r *Roots) CgroupPath() string {
+	return r.SysPath("fs", "cgroup")
+}
+
+// ProcFS returns a procfs.FS reading from the procfs root
+func (r *Roots) ProcFS() (procfs.FS, error) {
+	return procfs.NewFS(r.Proc)
+}
+
+// SysFS returns a sysfs.FS reading from the sysfs root
+func (r *Roots) SysFS() (sysfs.FS, error) {
+	return sysfs.NewFS(r.Sys)
+}
+
+// BlockFS returns a blockdevice.FS reading from both roots
+func (r *Roots) BlockFS() (blockdevice.FS, error) {
+	return blockdevice.NewFS(r.Proc, r.Sys)
+}
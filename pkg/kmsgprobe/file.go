@@ -0,0 +1,275 @@
+package kmsgprobe
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	gokmsg "github.com/talos-systems/go-kmsg"
+)
+
+// UnknownPriority is the priority of the messages of logs that don't keep it, e.g. kern.log.
+// They're matched against the OOM reports and the signatures, but not counted.
+const UnknownPriority gokmsg.Priority = -1
+
+// Format of a saved kernel log
+type Format string
+
+const (
+	// FormatKmsg is the format of /dev/kmsg, e.g. saved with `cat /dev/kmsg`
+	FormatKmsg Format = "kmsg"
+	// FormatDmesg is the output of dmesg, with -T for the times and -x for the priorities
+	FormatDmesg Format = "dmesg"
+	// FormatSyslog is the format of /var/log/kern.log, or of `journalctl -k`
+	FormatSyslog Format = "syslog"
+	// FormatJournalExport is the output of `journalctl -k -o export`
+	FormatJournalExport Format = "journal-export"
+)
+
+var (
+	// 6,339,5140900,-;message
+	kmsgLineRE = regexp.MustCompile(`^\d+,\d+,\d+,[^;]*;`)
+	// __CURSOR=s=...
+	journalFieldRE = regexp.MustCompile(`^[A-Z_][A-Z0-9_]*=`)
+	// kern  :err   : [Sat Oct 18 08:29:29 2026] message
+	dmesgLineRE = regexp.MustCompile(`^(?:(\w+)\s*:(\w+)\s*: )?\[([^\]]+)\] ?(.*)$`)
+	// Oct 18 08:29:29 host kernel: [ 1234.567890] message
+	// 2026-10-18T08:29:29.123456+00:00 host kernel: message
+	syslogLineRE = regexp.MustCompile(`^(\w{3} [ \d]\d \d\d:\d\d:\d\d|\d{4}-\d\d-\d\dT\S+) (\S+) ([^:\s]+): (.*)$`)
+	// [ 1234.567890] printed by the kernel with CONFIG_PRINTK_TIME
+	printkTimeRE = regexp.MustCompile(`^\[\s*\d+\.\d+\] ?`)
+)
+
+// dmesgPriorities are the names of the priorities of `dmesg -x`
+var dmesgPriorities = map[string]gokmsg.Priority{
+	"emerg":  gokmsg.Emerg,
+	"alert":  gokmsg.Alert,
+	"crit":   gokmsg.Crit,
+	"err":    gokmsg.Err,
+	"warn":   gokmsg.Warning,
+	"notice": gokmsg.Notice,
+	"info":   gokmsg.Info,
+	"debug":  gokmsg.Debug,
+}
+
+// DetectFormat tells the format of a saved kernel log from its first line
+func DetectFormat(line string) (Format, error) {
+	switch {
+	case kmsgLineRE.MatchString(line):
+		return FormatKmsg, nil
+	case journalFieldRE.MatchString(line):
+		return FormatJournalExport, nil
+	case dmesgLineRE.MatchString(line):
+		return FormatDmesg, nil
+	case syslogLineRE.MatchString(line):
+		return FormatSyslog, nil
+	}
+	return "", fmt.Errorf("unknown kernel log format, expected the output of dmesg, kern.log or journalctl -k -o export: %q", line)
+}
+
+// ScanFile reads the messages of a saved kernel log in any of the formats, detected from
+// its first line. Times that aren't in the log are zero.
+func ScanFile(ctx context.Context, r io.Reader) (<-chan gokmsg.Packet, Format, error) {
+	br := bufio.NewReader(r)
+	var first string
+	for first == "" {
+		line, err := br.ReadString('\n')
+		first = strings.TrimSpace(line)
+		if first == "" && err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, "", errors.New("the kernel log is empty")
+			}
+			return nil, "", err
+		}
+		if first != "" {
+			// the first line is read again by the scanners
+			br = bufio.NewReader(io.MultiReader(strings.NewReader(line), br))
+		}
+	}
+	format, err := DetectFormat(first)
+	if err != nil {
+		return nil, "", err
+	}
+	if format == FormatKmsg {
+		// the boot time of the machine the log was saved on isn't known
+		return clearTimestamps(ctx, ScanMessages(ctx, br, time.Time{})), format, nil
+	}
+	packets := make(chan gokmsg.Packet)
+	send := func(packet gokmsg.Packet) bool {
+		select {
+		case packets <- packet:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	go func() {
+		defer close(packets)
+		var err error
+		switch format {
+		case FormatJournalExport:
+			err = scanJournalExport(br, send)
+		case FormatDmesg:
+			err = scanLines(br, send, parseDmesgLine)
+		case FormatSyslog:
+			err = scanLines(br, send, syslogLineParser(time.Now()))
+		}
+		if err != nil {
+			send(gokmsg.Packet{Err: err})
+		}
+	}()
+	return packets, format, nil
+}
+
+// clearTimestamps zeroes the times of the packets, relative to an unknown boot time
+func clearTimestamps(ctx context.Context, in <-chan gokmsg.Packet) <-chan gokmsg.Packet {
+	out := make(chan gokmsg.Packet)
+	go func() {
+		defer close(out)
+		for packet := range in {
+			packet.Message.Timestamp = time.Time{}
+			select {
+			case out <- packet:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// scanLines sends the message of every line parse recognises, skipping the others
+func scanLines(r io.Reader, send func(gokmsg.Packet) bool, parse func(string) (gokmsg.Message, bool)) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		msg, ok := parse(scanner.Text())
+		if !ok {
+			continue
+		}
+		if !send(gokmsg.Packet{Message: msg}) {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+// parseDmesgLine parses a line of dmesg. Only -T gives wall-clock times, the seconds since
+// boot of plain dmesg leave them out. Only -x gives the priorities.
+func parseDmesgLine(line string) (gokmsg.Message, bool) {
+	matches := dmesgLineRE.FindStringSubmatch(line)
+	if matches == nil {
+		return gokmsg.Message{}, false
+	}
+	msg := gokmsg.Message{
+		Priority: UnknownPriority,
+		Message:  matches[4],
+	}
+	if priority, ok := dmesgPriorities[matches[2]]; ok {
+		msg.Priority = priority
+	}
+	// dmesg -T, or dmesg --time-format iso since util-linux 2.38
+	if t, err := time.ParseInLocation("Mon Jan _2 15:04:05 2006", matches[3], time.Local); err == nil {
+		msg.Timestamp = t
+	} else if t, err := time.Parse(time.RFC3339Nano, strings.Replace(matches[3], ",", ".", 1)); err == nil {
+		msg.Timestamp = t
+	}
+	return msg, true
+}
+
+// syslogLineParser parses the lines logged by the kernel in the syslog format. The traditional
+// timestamps have no year, that of now is assumed unless it puts them in the future.
+func syslogLineParser(now time.Time) func(string) (gokmsg.Message, bool) {
+	return func(line string) (gokmsg.Message, bool) {
+		matches := syslogLineRE.FindStringSubmatch(line)
+		if matches == nil || matches[3] != "kernel" {
+			return gokmsg.Message{}, false
+		}
+		msg := gokmsg.Message{
+			Priority: UnknownPriority,
+			Message:  printkTimeRE.ReplaceAllString(matches[4], ""),
+		}
+		if t, err := time.ParseInLocation(time.Stamp, matches[1], time.Local); err == nil {
+			t = t.AddDate(now.Year(), 0, 0)
+			if t.After(now.Add(24 * time.Hour)) {
+				t = t.AddDate(-1, 0, 0)
+			}
+			msg.Timestamp = t
+		} else if t, err := time.Parse(time.RFC3339Nano, matches[1]); err == nil {
+			msg.Timestamp = t
+		}
+		return msg, true
+	}
+}
+
+// maxJournalFieldSize bounds the binary fields of journal exports, journald truncates the
+// messages it stores way below it. Larger sizes come from corrupt or truncated files.
+const maxJournalFieldSize = 64 << 20
+
+// scanJournalExport reads the entries of the journal export format, keeping those of the kernel
+// See https://systemd.io/JOURNAL_EXPORT_FORMATS/
+func scanJournalExport(r *bufio.Reader, send func(gokmsg.Packet) bool) error {
+	fields := make(map[string]string)
+	flush := func() bool {
+		defer func() { fields = make(map[string]string) }()
+		message, ok := fields["MESSAGE"]
+		if !ok {
+			return true
+		}
+		if transport, ok := fields["_TRANSPORT"]; ok && transport != "kernel" {
+			return true
+		}
+		msg := gokmsg.Message{
+			Priority: UnknownPriority,
+			Message:  message,
+		}
+		if priority, err := strconv.Atoi(fields["PRIORITY"]); err == nil {
+			msg.Priority = gokmsg.Priority(priority)
+		}
+		if usec, err := strconv.ParseInt(fields["__REALTIME_TIMESTAMP"], 10, 64); err == nil {
+			msg.Timestamp = time.UnixMicro(usec)
+		}
+		return send(gokmsg.Packet{Message: msg})
+	}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			if !flush() || err != nil {
+				return nil
+			}
+			continue
+		}
+		if key, value, found := strings.Cut(line, "="); found {
+			fields[key] = value
+		} else {
+			// binary fields, e.g. messages with newlines, are their name then their
+			// little-endian 64 bits size, the data and a newline
+			var size uint64
+			if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+				return fmt.Errorf("could not read the size of the journal field %s: %w", line, err)
+			}
+			if size > maxJournalFieldSize {
+				return fmt.Errorf("the journal field %s claims %d bytes, the export is corrupt", line, size)
+			}
+			data := make([]byte, size+1)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return fmt.Errorf("could not read the journal field %s: %w", line, err)
+			}
+			fields[line] = string(data[:size])
+		}
+		if errors.Is(err, io.EOF) {
+			flush()
+			return nil
+		}
+	}
+}
@@ -3,11 +3,13 @@
 package kmsgprobe
 
 import (
+	"bufio"
 	"context"
 	"fmt"
-	"regexp"
+	"io"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/enescakir/emoji"
 	"github.com/sredog/sre/pkg/analysis"
@@ -32,74 +34,346 @@ func CounterToString(counter map[string]int64, sorted bool) string {
 	return summary
 }
 
+// RecentWindow tells recent events, worth acting on now, from historic ones
+const RecentWindow = time.Hour
+
+// HistogramHours is how many of the last hours with messages are displayed
+const HistogramHours = 24
+
+// HourCounter counts the messages of an hour by priority
+type HourCounter struct {
+	Hour    time.Time
+	Counter map[string]int64
+}
+
 type KernelRingBufferProbe struct {
-	Counter    map[string]int64
-	OOMRE      *regexp.Regexp
+	// Counter counts the messages of warning priority or worse
+	Counter map[string]int64
+	// Hours breaks Counter down by the hour they were logged at, when known
+	Hours map[time.Time]*HourCounter
+	// OOMVictims counts the OOM kills by command, OOMReports has the details of each
 	OOMVictims map[string]int64
+	OOMReports []*OOMReport
+	// oom is the report being read
+	oom *OOMReport
+	// Signatures are matched against every message, Matches is keyed by their ID
+	Signatures []*Signature
+	Matches    map[string]*SignatureMatches
+	// Since leaves out the messages logged before it, unless zero
+	Since time.Time
+	// Now is when the buffer was read, events more than RecentWindow before it are historic
+	Now time.Time
+	// Source is the file the messages were read from instead of the ring buffer, and its format
+	Source string
+	// Unprioritized counts the messages of logs without priorities, which Counter leaves out
+	Unprioritized int64
 }
 
-const OOMRE = `Killed process (?P<pid>\d+) \((?P<cmd>.+)\) total-vm:(.+), anon-rss:(.+), file-rss:(.+), shmem-rss:(.+)`
+// NewKernelRingBufferProbe reads the kernel ring buffer until it's drained or ctx is done,
+// leaving out the messages logged before since unless it's zero
+func NewKernelRingBufferProbe(ctx context.Context, since time.Time) (*KernelRingBufferProbe, error) {
+	krbp := newKernelRingBufferProbe()
+	krbp.Since = since
+	if err := krbp.ReadKernelRingBuffer(ctx); err != nil {
+		return nil, err
+	}
+	return krbp, nil
+}
+
+// NewStreamingProbe returns an empty probe, for the messages to be passed to Follow, Stream or Process
+func NewStreamingProbe() *KernelRingBufferProbe {
+	return newKernelRingBufferProbe()
+}
 
-// NewKernelRingBufferProbe reads the load average data and returns its representation
-func NewKernelRingBufferProbe() (*KernelRingBufferProbe, error) {
-	krbp := &KernelRingBufferProbe{
+func newKernelRingBufferProbe() *KernelRingBufferProbe {
+	return &KernelRingBufferProbe{
 		Counter:    make(map[string]int64),
-		OOMRE:      regexp.MustCompile(OOMRE),
+		Hours:      make(map[time.Time]*HourCounter),
 		OOMVictims: make(map[string]int64),
+		Signatures: Signatures(),
+		Matches:    make(map[string]*SignatureMatches),
+		Now:        time.Now(),
 	}
-	krbp.ReadKernelRingBuffer()
-	return krbp, nil
 }
 
-func (p *KernelRingBufferProbe) ReadKernelRingBuffer() error {
+func init() {
+	analysis.Register(&analysis.ProbeConfiguration{
+		ID:          "kmsg",
+		Aliases:     []string{"dmesg"},
+		Description: "Smells in the kernel ring buffer, like OOM kills, hung tasks, lockups and I/O errors",
+		Build: func(ctx context.Context, env *analysis.Environment) (analysis.Probe, error) {
+			return NewKernelRingBufferProbe(ctx, time.Time{})
+		},
+	})
+}
+
+func (p *KernelRingBufferProbe) ReadKernelRingBuffer(ctx context.Context) error {
 	reader, err := gokmsg.NewReader()
 	if err != nil {
 		return err
 	}
 	defer reader.Close()
-	for packet := range reader.Scan(context.TODO()) {
+	for packet := range reader.Scan(ctx) {
 		if packet.Err == nil {
-			msg := packet.Message
-			// https://github.com/siderolabs/go-kmsg/blob/v0.1.1/message.go#L56
-			if msg.Priority > gokmsg.Warning {
+			p.Process(packet.Message)
+		}
+	}
+	return ctx.Err()
+}
+
+// NewKernelLogFileProbe reads a saved kernel log in any of the formats ScanFile detects. The last
+// message stands for now: the events within RecentWindow before it are recent, and with a window
+// only the messages logged within it before the last one are read.
+func NewKernelLogFileProbe(ctx context.Context, r io.Reader, name string, window time.Duration) (*KernelRingBufferProbe, error) {
+	packets, format, err := ScanFile(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	var messages []gokmsg.Message
+	var last time.Time
+	for packet := range packets {
+		if packet.Err != nil {
+			return nil, packet.Err
+		}
+		messages = append(messages, packet.Message)
+		if packet.Message.Timestamp.After(last) {
+			last = packet.Message.Timestamp
+		}
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	p := newKernelRingBufferProbe()
+	p.Source = fmt.Sprintf("%s (%s)", name, format)
+	if !last.IsZero() {
+		p.Now = last
+		if window > 0 {
+			p.Since = last.Add(-window)
+		}
+	}
+	for _, msg := range messages {
+		p.Process(msg)
+	}
+	return p, nil
+}
+
+// Follow tails the kernel ring buffer from its end, passing the observations to observe
+// as soon as a message makes them, until ctx is done
+func (p *KernelRingBufferProbe) Follow(ctx context.Context, observe func(*analysis.Observation)) error {
+	reader, err := gokmsg.NewReader(gokmsg.Follow(), gokmsg.FromTail())
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	return p.Stream(ctx, reader.Scan(ctx), observe)
+}
+
+// Stream processes the packets until there are no more or ctx is done, passing the
+// observations to observe as soon as a message makes them. Packets that failed to parse are
+// skipped as when reading the ring buffer, and being stopped by ctx isn't an error.
+func (p *KernelRingBufferProbe) Stream(ctx context.Context, packets <-chan gokmsg.Packet, observe func(*analysis.Observation)) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case packet, ok := <-packets:
+			if !ok {
+				return nil
+			}
+			if packet.Err != nil {
 				continue
 			}
-			p.ProcessEvent(msg.Priority.String(), msg.Message)
+			for _, o := range p.Process(packet.Message) {
+				observe(o)
+			}
+		}
+	}
+}
+
+// ScanMessages reads the messages in the format of /dev/kmsg from r, one per line, e.g. saved
+// with `cat /dev/kmsg`. Their times are relative to bootTime.
+func ScanMessages(ctx context.Context, r io.Reader, bootTime time.Time) <-chan gokmsg.Packet {
+	packets := make(chan gokmsg.Packet)
+	go func() {
+		defer close(packets)
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			if scanner.Text() == "" {
+				continue
+			}
+			var packet gokmsg.Packet
+			packet.Message, packet.Err = gokmsg.ParseMessage(scanner.Text(), bootTime)
+			select {
+			case packets <- packet:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			select {
+			case packets <- gokmsg.Packet{Err: err}:
+			case <-ctx.Done():
+			}
 		}
+	}()
+	return packets
+}
+
+// Process counts the message and matches it against the OOM reports and the signatures,
+// returning the observations about the OOM kills it completed and the failures it matched.
+// Messages logged before Since are left out.
+func (p *KernelRingBufferProbe) Process(msg gokmsg.Message) (observations []*analysis.Observation) {
+	if !p.Since.IsZero() && msg.Timestamp.Before(p.Since) {
+		return nil
 	}
-	return nil
+	// https://github.com/siderolabs/go-kmsg/blob/v0.1.1/message.go#L56
+	// most of the OOM reports, segfaults and links going down are logged as info
+	if msg.Priority == UnknownPriority {
+		p.Unprioritized++
+	} else if msg.Priority <= gokmsg.Warning {
+		p.count(msg.Timestamp, msg.Priority.String())
+	}
+	if r := p.ProcessOOM(msg.Timestamp, msg.Message); r != nil {
+		observations = append(observations, oomKillObservation(r))
+	}
+	for _, s := range p.ProcessSignatures(msg.Timestamp, msg.Message) {
+		observations = append(observations, &analysis.Observation{
+			Type:    s.Type,
+			Message: fmt.Sprintf("%s: %s", s.ID, msg.Message),
+			See:     s.See,
+		})
+	}
+	return
 }
 
+// ProcessEvent processes a message whose time isn't known
 func (p *KernelRingBufferProbe) ProcessEvent(priority, message string) {
-	val, exists := p.Counter[priority]
-	if exists == false {
-		p.Counter[priority] = 1
-	} else {
-		p.Counter[priority] = val + 1
+	p.count(time.Time{}, priority)
+	p.ProcessOOM(time.Time{}, message)
+	p.ProcessSignatures(time.Time{}, message)
+}
+
+func (p *KernelRingBufferProbe) count(timestamp time.Time, priority string) {
+	p.Counter[priority]++
+	if timestamp.IsZero() {
+		return
 	}
-	p.ProcessOOM(message)
+	hour := time.Date(timestamp.Year(), timestamp.Month(), timestamp.Day(), timestamp.Hour(), 0, 0, 0, timestamp.Location())
+	h, exists := p.Hours[hour]
+	if !exists {
+		h = &HourCounter{Hour: hour, Counter: make(map[string]int64)}
+		p.Hours[hour] = h
+	}
+	h.Counter[priority]++
+}
+
+// historic tells whether the event happened long enough ago not to be worth acting on.
+// Events whose time isn't known aren't historic.
+func (p *KernelRingBufferProbe) historic(timestamp time.Time) bool {
+	return !timestamp.IsZero() && p.Now.Sub(timestamp) > RecentWindow
 }
 
-func (p *KernelRingBufferProbe) ProcessOOM(message string) {
-	if strings.Contains(message, "Killed process") {
-		matches := p.OOMRE.FindStringSubmatch(message)
-		if len(matches) != 7 {
-			panic(fmt.Errorf("My regexp game is weak! Wanted 7 finds, got %v in %v", matches, message))
+// ProcessSignatures counts the message against every signature it matches, and returns them
+func (p *KernelRingBufferProbe) ProcessSignatures(timestamp time.Time, message string) (matched []*Signature) {
+	for _, s := range p.Signatures {
+		if !s.RE.MatchString(message) {
+			continue
+		}
+		matched = append(matched, s)
+		m, exists := p.Matches[s.ID]
+		if !exists {
+			m = &SignatureMatches{}
+			p.Matches[s.ID] = m
+		}
+		m.Count++
+		if p.historic(timestamp) {
+			m.Historic++
+		}
+		if m.Last.Before(timestamp) {
+			m.Last = timestamp
 		}
-		cmd := matches[2]
-		val := p.OOMVictims[cmd]
-		p.OOMVictims[cmd] = val + 1
+		if len(m.Examples) < MaxExamples {
+			m.Examples = append(m.Examples, message)
+		}
+	}
+	return
+}
+
+// Histogram returns the counters of the last hours with messages, oldest first
+func (p *KernelRingBufferProbe) Histogram(hours int) []*HourCounter {
+	histogram := make([]*HourCounter, 0, len(p.Hours))
+	for _, h := range p.Hours {
+		histogram = append(histogram, h)
 	}
+	sort.Slice(histogram, func(i, j int) bool { return histogram[i].Hour.Before(histogram[j].Hour) })
+	if hours > 0 && len(histogram) > hours {
+		histogram = histogram[len(histogram)-hours:]
+	}
+	return histogram
 }
 
-const displayFormat = "%v Kernel ring buffer: %s\n"
+const displayFormat = "%v %s%s: %s\n"
 
 func (p *KernelRingBufferProbe) Display() string {
 	summary := CounterToString(p.Counter, true)
-	return fmt.Sprintf(displayFormat,
+	if summary == "" && p.Unprioritized > 0 {
+		summary = fmt.Sprintf("%d message(s) without priority", p.Unprioritized)
+	}
+	since := ""
+	if !p.Since.IsZero() {
+		since = fmt.Sprintf(" since %s", p.Since.Format(time.Stamp))
+	}
+	source := "Kernel ring buffer"
+	if p.Source != "" {
+		source = "Kernel log " + p.Source
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, displayFormat,
 		emoji.Penguin,
+		source,
+		since,
 		summary,
 	)
+	if len(p.Matches) > 0 {
+		counts := make(map[string]int64, len(p.Matches))
+		for id, m := range p.Matches {
+			counts[id] = m.Count
+		}
+		fmt.Fprintf(&sb, "Known failures: %s\n", CounterToString(counts, true))
+	}
+	for _, h := range p.Histogram(HistogramHours) {
+		fmt.Fprintf(&sb, "%s  %s\n", h.Hour.Format("2006-01-02 15:04"), CounterToString(h.Counter, true))
+	}
+	return sb.String()
+}
+
+func (p *KernelRingBufferProbe) Serialize() interface{} {
+	var since *time.Time
+	if !p.Since.IsZero() {
+		since = &p.Since
+	}
+	return struct {
+		Source     string     `json:",omitempty"`
+		Since      *time.Time `json:",omitempty"`
+		Counter    map[string]int64
+		Hours      []*HourCounter
+		OOMVictims map[string]int64
+		OOMReports []*OOMReport
+		Matches    map[string]*SignatureMatches
+	}{
+		Source:     p.Source,
+		Since:      since,
+		Counter:    p.Counter,
+		Hours:      p.Histogram(0),
+		OOMVictims: p.OOMVictims,
+		OOMReports: p.OOMReports,
+		Matches:    p.Matches,
+	}
+}
+
+// ago tells how long before now something happened, to the minute
+func ago(now, timestamp time.Time) string {
+	return fmt.Sprintf("%v ago", now.Sub(timestamp).Truncate(time.Minute))
 }
 
 func (p *KernelRingBufferProbe) Analysis() (observations []*analysis.Observation) {
@@ -112,14 +386,47 @@ func (p *KernelRingBufferProbe) Analysis() (observations []*analysis.Observation
 		if len(p.OOMVictims) < 20 {
 			summary = " Counts: " + CounterToString(p.OOMVictims, true)
 		}
+		observationType := analysis.Warning
+		if last := p.OOMReports[len(p.OOMReports)-1]; p.historic(last.Time) {
+			observationType = analysis.Note
+			summary += fmt.Sprintf(" The last one was %s.", ago(p.Now, last.Time))
+		}
 		observations = append(observations, &analysis.Observation{
-			Type:    analysis.Warning,
+			Type:    observationType,
 			Message: fmt.Sprintf("Found %d occurence(s) of OOM killer for %d command(s).%v", total, len(p.OOMVictims), summary),
 		})
+		observations = append(observations, p.oomObservations()...)
+	}
+	for _, s := range p.Signatures {
+		m, exists := p.Matches[s.ID]
+		if !exists {
+			continue
+		}
+		observationType, when := s.Type, ""
+		switch {
+		case m.Historic == m.Count:
+			// old news, unless it keeps happening
+			observationType = analysis.Note
+			when = fmt.Sprintf(", the last %s", ago(p.Now, m.Last))
+		case m.Historic > 0:
+			when = fmt.Sprintf(", %d in the last %v", m.Count-m.Historic, RecentWindow)
+		}
+		observations = append(observations, &analysis.Observation{
+			Type:    observationType,
+			Message: fmt.Sprintf("Found %d line(s) of %s%s: %s. E.g. %q", m.Count, s.ID, when, s.Description, m.Examples),
+			See:     s.See,
+		})
+	}
+	if len(p.Counter) == 0 && p.Unprioritized > 0 {
+		observations = append(observations, &analysis.Observation{
+			Type:    analysis.Hint,
+			Message: "The log doesn't keep the priorities of the messages, so warnings and errors aren't counted - save it with `dmesg -Tx` or `journalctl -k -o export` next time",
+		})
 	}
 	observations = append(observations, &analysis.Observation{
 		Type:    analysis.Learn,
 		Message: "To browse through all kernel ring buffer, use: dmesg --decode --human",
+		See:     "dmesg",
 	})
 	return
 }
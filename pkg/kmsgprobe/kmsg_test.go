@@ -1,9 +1,19 @@
 package kmsgprobe
 
-import "testing"
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sredog/sre/pkg/analysis"
+	gokmsg "github.com/talos-systems/go-kmsg"
+)
 
 func TestProcessOOMBasic(t *testing.T) {
-	p, _ := NewKernelRingBufferProbe()
+	p := newKernelRingBufferProbe()
 	p.ProcessEvent("error", "Killed process 102503 (chrome) total-vm:911152kB, anon-rss:104748kB, file-rss:0kB, shmem-rss:1968kB")
 	if len(p.OOMVictims) != 1 {
 		t.Errorf("Expected 1 OOM event to be detected, got %v", p.OOMVictims)
@@ -16,3 +26,219 @@ func TestProcessOOMBasic(t *testing.T) {
 		t.Errorf("Expected 1, got %d", val)
 	}
 }
+
+func TestSignatures(t *testing.T) {
+	p := newKernelRingBufferProbe()
+	for _, line := range []string{
+		"INFO: task kworker/u8:2:1234 blocked for more than 120 seconds.",
+		"watchdog: BUG: soft lockup - CPU#3 stuck for 23s! [java:4242]",
+		"NMI watchdog: Watchdog detected hard LOCKUP on cpu 1",
+		"rcu: INFO: rcu_sched self-detected stall on CPU",
+		"nginx[9876]: segfault at 0 ip 00007f2a sp 00007ffd error 4 in libc.so.6[7f2a+195000]",
+		"traps: python3[5555] general protection fault ip:7f00 sp:7ffc error:0 in libpython3.so",
+		"blk_update_request: I/O error, dev sda, sector 2048 op 0x0:(READ) flags 0x0 phys_seg 1 prio class 0",
+		"I/O error, dev nvme0n1, sector 1024 op 0x1:(WRITE) flags 0x800 phys_seg 1 prio class 0",
+		"EXT4-fs error (device sda1): ext4_find_entry:1455: inode #2: comm ls: reading directory lblock 0",
+		"XFS (dm-0): Metadata corruption detected at xfs_da3_node_read_verify+0x10c/0x120 [xfs]",
+		"e1000e 0000:00:19.0 eth0: NIC Link is Down",
+		"e1000e 0000:00:19.0 eth0: NIC Link is Up 1000 Mbps Full Duplex, Flow Control: None",
+		"mce: [Hardware Error]: Machine check events logged",
+		"nf_conntrack: nf_conntrack: table full, dropping packet",
+		"TCP: request_sock_TCP: Possible SYN flooding on port 443. Sending cookies.  Check SNMP counters.",
+		"nvme nvme0: 8/0/0 default/read/poll queues",
+	} {
+		p.ProcessSignatures(time.Time{}, line)
+	}
+	for _, s := range p.Signatures {
+		if _, matched := p.Matches[s.ID]; !matched {
+			t.Errorf("Expected %s to match", s.ID)
+		}
+	}
+	if m := p.Matches["io_error"]; m.Count != 2 || len(m.Examples) != 2 {
+		t.Errorf("Expected 2 I/O errors with their lines, got %+v", m)
+	}
+	if m := p.Matches["link_down"]; m.Count != 1 {
+		t.Errorf("Expected the link going up not to count, got %+v", m)
+	}
+	// every signature makes an observation, along with the one to learn about dmesg
+	if observations := p.Analysis(); len(observations) != len(p.Signatures)+1 {
+		t.Errorf("Expected %d observations, got %d", len(p.Signatures)+1, len(observations))
+	}
+}
+
+func TestProcessOOMReport(t *testing.T) {
+	p := newKernelRingBufferProbe()
+	for _, line := range []string{
+		"stress invoked oom-killer: gfp_mask=0xcc0(GFP_KERNEL), order=0, oom_score_adj=0",
+		"CPU: 1 PID: 4321 Comm: stress Not tainted 5.15.0-91-generic #101-Ubuntu",
+		"memory: usage 524288kB, limit 524288kB, failcnt 1042",
+		"swap: usage 0kB, limit 9007199254740988kB, failcnt 0",
+		"Memory cgroup stats for /system.slice/stress.service:",
+		"Tasks state (memory values in pages):",
+		"[  pid  ]   uid  tgid total_vm      rss pgtables_bytes swapents oom_score_adj name",
+		"[   4320]     0  4320      943      448    45056        0             0 sh",
+		"[   4321]     0  4321   265012   131170  1118208        0           100 stress",
+		"oom-kill:constraint=CONSTRAINT_MEMCG,nodemask=(null),cpuset=/,mems_allowed=0,oom_memcg=/system.slice/stress.service,task_memcg=/system.slice/stress.service,task=stress,pid=4321,uid=0",
+		"Memory cgroup out of memory: Killed process 4321 (stress) total-vm:1060048kB, anon-rss:523684kB, file-rss:996kB, shmem-rss:0kB, UID:0 pgtables:1092kB oom_score_adj:100",
+		// from a kernel logging the kill differently, it's counted without the sizes
+		"Killed process 77 (my app)",
+		"Killed process garbage",
+	} {
+		p.ProcessEvent("info", line)
+	}
+	if len(p.OOMReports) != 2 || p.OOMVictims["my app"] != 1 {
+		t.Fatalf("Expected 2 OOM kills, got %+v", p.OOMVictims)
+	}
+	r := p.OOMReports[0]
+	if r.Trigger != "stress" || r.GFPMask != "0xcc0" || r.GFPFlags != "GFP_KERNEL" || r.Order != 0 {
+		t.Errorf("Expected the trigger and the gfp_mask, got %+v", r)
+	}
+	if !r.InCgroup() || r.Memcg != "/system.slice/stress.service" || r.MemcgLimit != 512*1024*1024 {
+		t.Errorf("Expected the cgroup at its limit, got %+v", r)
+	}
+	if r.Victim != "stress" || r.VictimPID != 4321 || r.AnonRSS != 523684*1024 {
+		t.Errorf("Expected the victim, got %+v", r)
+	}
+	if len(r.Tasks) != 2 || r.Tasks[1].RSS != 131170 || r.Tasks[1].OOMScoreAdj != 100 || r.Tasks[1].Name != "stress" {
+		t.Errorf("Expected the tasks, got %+v", r.Tasks)
+	}
+	var found bool
+	for _, o := range p.Analysis() {
+		found = found || strings.Contains(o.Message, "Cgroup /system.slice/stress.service hit its memory limit of 512 MiB")
+	}
+	if !found {
+		t.Errorf("Expected an observation naming the cgroup, got %+v", p.Analysis())
+	}
+}
+
+// kmsgFile is in the format of /dev/kmsg: priority,sequence,microseconds since boot,flags;message
+const kmsgFile = `4,100,1000000,-;nginx[9876]: segfault at 0 ip 00007f2a sp 00007ffd error 4 in libc.so.6[7f2a+195000]
+3,101,2000000,-;EXT4-fs error (device sda1): ext4_find_entry:1455: inode #2: comm ls: reading directory lblock 0
+4,200,7200000000,-;java invoked oom-killer: gfp_mask=0xcc0(GFP_KERNEL), order=0, oom_score_adj=0
+6,201,7200000100,-;memory: usage 1048576kB, limit 1048576kB, failcnt 12
+6,202,7200000200,-;oom-kill:constraint=CONSTRAINT_MEMCG,nodemask=(null),cpuset=/,mems_allowed=0,oom_memcg=/kubepods/pod1,task_memcg=/kubepods/pod1/app,task=java,pid=4321,uid=1000
+3,203,7200000300,-;Memory cgroup out of memory: Killed process 4321 (java) total-vm:2060048kB, anon-rss:1023684kB, file-rss:996kB, shmem-rss:0kB, UID:1000 pgtables:2092kB oom_score_adj:0
+`
+
+func TestStream(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kmsg")
+	if err := os.WriteFile(path, []byte(kmsgFile), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// booted 2 hours and a half ago, the segfault and the filesystem error are historic
+	now := time.Now()
+	bootTime := now.Add(-150 * time.Minute)
+	p := newKernelRingBufferProbe()
+	p.Now = now
+	var streamed []*analysis.Observation
+	err = p.Stream(context.Background(), ScanMessages(context.Background(), f, bootTime), func(o *analysis.Observation) {
+		streamed = append(streamed, o)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(streamed) != 3 {
+		t.Fatalf("Expected 3 observations as the messages came, got %d", len(streamed))
+	}
+	if oom := streamed[2].Message; !strings.HasPrefix(oom, "OOM kill of java") || !strings.Contains(oom, "in cgroup /kubepods/pod1") {
+		t.Errorf("Expected the OOM kill in its cgroup, got %q", oom)
+	}
+	if p.Counter["err"] != 2 || p.Counter["warning"] != 2 || len(p.Hours) == 0 {
+		t.Errorf("Expected 2 errors and 2 warnings counted per hour, got %v and %v", p.Counter, p.Hours)
+	}
+	types := make(map[string]analysis.ObservationType)
+	for _, o := range p.Analysis() {
+		types[strings.Fields(o.Message)[4]] = o.Type
+	}
+	if types["segfault,"] != analysis.Note || types["fs_error,"] != analysis.Note {
+		t.Errorf("Expected the old segfault and filesystem error to be notes, got %v", types)
+	}
+
+	// the same messages since an hour ago leave the old ones out
+	recent := newKernelRingBufferProbe()
+	recent.Since = now.Add(-time.Hour)
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	recent.Stream(context.Background(), ScanMessages(context.Background(), f, bootTime), func(*analysis.Observation) {})
+	if len(recent.Matches) != 0 || len(recent.OOMReports) != 1 || recent.Counter["err"] != 1 {
+		t.Errorf("Expected only the OOM kill since an hour ago, got %v and %v", recent.Matches, recent.Counter)
+	}
+}
+
+func TestScanFile(t *testing.T) {
+	oom := "Out of memory: Killed process 4321 (java) total-vm:2060048kB, anon-rss:1023684kB, file-rss:996kB, shmem-rss:0kB"
+	binaryOOM := "Out of memory: Killed process 99 (a\x01b) total-vm:1kB"
+	for _, test := range []struct {
+		log      string
+		format   Format
+		priority gokmsg.Priority
+		victim   string
+	}{
+		{"kern  :err   : [Sat Oct 17 08:00:01 2026] " + oom + "\n", FormatDmesg, gokmsg.Err, "java"},
+		{"[Sat Oct 17 08:00:01 2026] " + oom + "\n", FormatDmesg, UnknownPriority, "java"},
+		{"Oct 17 08:00:02 web1 systemd[1]: Started foo.\nOct 17 08:00:01 web1 kernel: [12345.678901] " + oom + "\n", FormatSyslog, UnknownPriority, "java"},
+		{"2026-10-17T08:00:01.123456+00:00 web1 kernel: " + oom + "\n", FormatSyslog, UnknownPriority, "java"},
+		{"3,100,1000000,-;" + oom + "\n", FormatKmsg, gokmsg.Err, "java"},
+		{
+			"__CURSOR=s=1\n__REALTIME_TIMESTAMP=1792224000000000\nPRIORITY=3\n_TRANSPORT=kernel\nMESSAGE\n" +
+				string([]byte{byte(len(binaryOOM)), 0, 0, 0, 0, 0, 0, 0}) + binaryOOM + "\n\n" +
+				"__CURSOR=s=2\n_TRANSPORT=syslog\nMESSAGE=" + oom + "\n",
+			FormatJournalExport, gokmsg.Err, "a\x01b",
+		},
+	} {
+		packets, format, err := ScanFile(context.Background(), strings.NewReader(test.log))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if format != test.format {
+			t.Errorf("Expected %s, got %s for %q", test.format, format, test.log)
+		}
+		var messages []gokmsg.Message
+		for packet := range packets {
+			if packet.Err != nil {
+				t.Fatal(packet.Err)
+			}
+			messages = append(messages, packet.Message)
+		}
+		if len(messages) != 1 || messages[0].Priority != test.priority {
+			t.Fatalf("Expected a message of priority %d, got %+v for %q", test.priority, messages, test.log)
+		}
+		// only dmesg -T, syslog and the journal give wall-clock times
+		if (test.format == FormatKmsg) != messages[0].Timestamp.IsZero() {
+			t.Errorf("Unexpected time %v for %q", messages[0].Timestamp, test.log)
+		}
+		p := newKernelRingBufferProbe()
+		p.Process(messages[0])
+		if len(p.OOMReports) != 1 || p.OOMReports[0].Victim != test.victim {
+			t.Errorf("Expected an OOM kill of %q, got %+v", test.victim, p.OOMReports)
+		}
+	}
+	if _, _, err := ScanFile(context.Background(), strings.NewReader("\nnot a kernel log\n")); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+
+	// corrupt sizes of binary fields, overflowing size+1 and too large to allocate
+	for _, size := range [][]byte{
+		{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		{0, 0, 0, 0, 0, 0x10, 0, 0},
+	} {
+		packets, _, err := ScanFile(context.Background(), strings.NewReader("__CURSOR=s=1\nMESSAGE\n"+string(size)+"truncated"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var scanErr error
+		for packet := range packets {
+			scanErr = packet.Err
+		}
+		if scanErr == nil || !strings.Contains(scanErr.Error(), "corrupt") {
+			t.Errorf("Expected the corrupt size %x to be reported, got %v", size, scanErr)
+		}
+	}
+}
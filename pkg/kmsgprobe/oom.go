@@ -0,0 +1,291 @@
+package kmsgprobe
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/sredog/sre/pkg/analysis"
+)
+
+// The lines of an OOM report, in the order the kernel logs them. Only the ones
+// needed to tell the report apart are anchored, the layout changed across versions.
+var (
+	// stress invoked oom-killer: gfp_mask=0xcc0(GFP_KERNEL), order=0, oom_score_adj=0
+	oomInvokedRE = regexp.MustCompile(`^(.+) invoked oom-killer: gfp_mask=([^,(]+)(?:\(([^)]*)\))?, order=(-?\d+)`)
+	// memory: usage 524288kB, limit 524288kB, failcnt 123
+	oomMemcgUsageRE = regexp.MustCompile(`^memory: usage (\d+)kB, limit (\d+)kB`)
+	// Memory cgroup stats for /system.slice/foo.service:
+	oomMemcgStatsRE = regexp.MustCompile(`^Memory cgroup stats for ([^:]+):`)
+	// [   1234]     0  1234   265012   131170  1118208        0             0 stress
+	oomTaskRE = regexp.MustCompile(`^\[\s*(\d+)\]\s+(.*)$`)
+	// oom-kill:constraint=CONSTRAINT_MEMCG,nodemask=(null),cpuset=/,mems_allowed=0,oom_memcg=/foo,task_memcg=/foo,task=stress,pid=1234,uid=0
+	oomSummaryRE = regexp.MustCompile(`^oom-kill:(.*)`)
+	// Memory cgroup out of memory: Killed process 1234 (stress) total-vm:1060048kB, anon-rss:523684kB, file-rss:996kB, shmem-rss:0kB, UID:0 pgtables:1092kB oom_score_adj:0
+	oomKilledRE = regexp.MustCompile(`Killed process (\d+) \((.*)\)(.*)`)
+	oomSizeRE   = regexp.MustCompile(`([\w-]+):(\d+)kB`)
+)
+
+// Constraints of the OOM killer, CONSTRAINT_NONE being the whole system running out of memory
+const (
+	ConstraintNone  = "CONSTRAINT_NONE"
+	ConstraintMemcg = "CONSTRAINT_MEMCG"
+)
+
+// OOMTask is a row of the table of tasks the OOM killer chose the victim from
+type OOMTask struct {
+	PID  int
+	UID  int
+	TGID int
+	// TotalVM and RSS are in pages
+	TotalVM     uint64
+	RSS         uint64
+	OOMScoreAdj int
+	Name        string
+}
+
+// OOMReport is what the kernel logs about a single OOM kill
+type OOMReport struct {
+	// Time the victim was killed at, zero when not known
+	Time time.Time
+	// Trigger is the command whose allocation invoked the OOM killer
+	Trigger string
+	GFPMask string
+	// GFPFlags decode GFPMask, e.g. GFP_KERNEL, on kernels logging them
+	GFPFlags string `json:",omitempty"`
+	Order    int
+	// Constraint is CONSTRAINT_NONE when the system ran out of memory, CONSTRAINT_MEMCG
+	// when a cgroup hit its limit. Kernels before 4.19 don't log it.
+	Constraint string `json:",omitempty"`
+	// Memcg is the cgroup whose limit was hit, TaskMemcg the one of the victim
+	Memcg     string `json:",omitempty"`
+	TaskMemcg string `json:",omitempty"`
+	// MemcgUsage and MemcgLimit are in bytes, when a cgroup hit its limit
+	MemcgUsage uint64 `json:",omitempty"`
+	MemcgLimit uint64 `json:",omitempty"`
+	Victim     string
+	VictimPID  int
+	// Sizes of the victim in bytes
+	TotalVM  uint64
+	AnonRSS  uint64
+	FileRSS  uint64
+	ShmemRSS uint64
+	Tasks    []*OOMTask `json:",omitempty"`
+}
+
+// InCgroup tells whether a cgroup hit its limit, rather than the system running out of memory
+func (r *OOMReport) InCgroup() bool {
+	return r.Constraint == ConstraintMemcg || r.Memcg != ""
+}
+
+// RSS returns the resident memory of the victim in bytes
+func (r *OOMReport) RSS() uint64 {
+	return r.AnonRSS + r.FileRSS + r.ShmemRSS
+}
+
+// parseOOMTask parses a row of the tasks table. The columns between tgid and oom_score_adj
+// changed over time (nr_ptes and nr_pmds became pgtables_bytes), those at both ends didn't:
+// [  pid  ]   uid  tgid total_vm      rss pgtables_bytes swapents oom_score_adj name
+func parseOOMTask(pid string, rest string) *OOMTask {
+	fields := strings.Fields(rest)
+	if len(fields) < 6 {
+		return nil
+	}
+	t := &OOMTask{Name: fields[len(fields)-1]}
+	var err error
+	if t.PID, err = strconv.Atoi(pid); err != nil {
+		return nil
+	}
+	if t.UID, err = strconv.Atoi(fields[0]); err != nil {
+		return nil
+	}
+	if t.TGID, err = strconv.Atoi(fields[1]); err != nil {
+		return nil
+	}
+	if t.TotalVM, err = strconv.ParseUint(fields[2], 10, 64); err != nil {
+		return nil
+	}
+	if t.RSS, err = strconv.ParseUint(fields[3], 10, 64); err != nil {
+		return nil
+	}
+	if t.OOMScoreAdj, err = strconv.Atoi(fields[len(fields)-2]); err != nil {
+		return nil
+	}
+	return t
+}
+
+// ProcessOOM follows the lines of OOM reports, a report being complete once the victim
+// is killed, and returned then. Lines it doesn't recognise are ignored, reports whose
+// beginning rotated out of the ring buffer only have what was left.
+func (p *KernelRingBufferProbe) ProcessOOM(timestamp time.Time, message string) *OOMReport {
+	message = strings.TrimSpace(message)
+	if matches := oomInvokedRE.FindStringSubmatch(message); matches != nil {
+		order, _ := strconv.Atoi(matches[4])
+		p.oom = &OOMReport{
+			Trigger:  matches[1],
+			GFPMask:  matches[2],
+			GFPFlags: matches[3],
+			Order:    order,
+		}
+		return nil
+	}
+	if matches := oomKilledRE.FindStringSubmatch(message); matches != nil {
+		return p.processOOMKill(timestamp, message, matches)
+	}
+	// the rest only make sense within a report
+	if p.oom == nil {
+		return nil
+	}
+	if matches := oomMemcgUsageRE.FindStringSubmatch(message); matches != nil {
+		usage, _ := strconv.ParseUint(matches[1], 10, 64)
+		limit, _ := strconv.ParseUint(matches[2], 10, 64)
+		p.oom.MemcgUsage, p.oom.MemcgLimit = usage*1024, limit*1024
+		return nil
+	}
+	// the first stats are those of the cgroup at its limit, the only way to find it before 4.19
+	if matches := oomMemcgStatsRE.FindStringSubmatch(message); matches != nil {
+		if p.oom.Memcg == "" {
+			p.oom.Memcg = matches[1]
+		}
+		return nil
+	}
+	if matches := oomTaskRE.FindStringSubmatch(message); matches != nil {
+		if t := parseOOMTask(matches[1], matches[2]); t != nil {
+			p.oom.Tasks = append(p.oom.Tasks, t)
+		}
+		return nil
+	}
+	if matches := oomSummaryRE.FindStringSubmatch(message); matches != nil {
+		for _, kv := range strings.Split(matches[1], ",") {
+			k, v, found := strings.Cut(kv, "=")
+			if !found {
+				continue
+			}
+			switch k {
+			case "constraint":
+				p.oom.Constraint = v
+			case "oom_memcg":
+				p.oom.Memcg = v
+			case "task_memcg":
+				p.oom.TaskMemcg = v
+			}
+		}
+	}
+	return nil
+}
+
+func (p *KernelRingBufferProbe) processOOMKill(timestamp time.Time, message string, matches []string) *OOMReport {
+	r := p.oom
+	if r == nil {
+		r = &OOMReport{}
+	}
+	p.oom = nil
+	r.Time = timestamp
+	r.VictimPID, _ = strconv.Atoi(matches[1])
+	r.Victim = matches[2]
+	for _, size := range oomSizeRE.FindAllStringSubmatch(matches[3], -1) {
+		kB, _ := strconv.ParseUint(size[2], 10, 64)
+		switch size[1] {
+		case "total-vm":
+			r.TotalVM = kB * 1024
+		case "anon-rss":
+			r.AnonRSS = kB * 1024
+		case "file-rss":
+			r.FileRSS = kB * 1024
+		case "shmem-rss":
+			r.ShmemRSS = kB * 1024
+		}
+	}
+	// before 4.19 the constraint isn't logged, the kill is only told apart by its prefix
+	if r.Constraint == "" && strings.Contains(message, "Memory cgroup out of memory") {
+		r.Constraint = ConstraintMemcg
+	}
+	p.OOMReports = append(p.OOMReports, r)
+	p.OOMVictims[r.Victim]++
+	return r
+}
+
+// oomKillObservation tells about a single OOM kill as it happens
+func oomKillObservation(r *OOMReport) *analysis.Observation {
+	if r.InCgroup() {
+		memcg := r.Memcg
+		if memcg == "" {
+			memcg = "(unknown)"
+		}
+		limit := ""
+		if r.MemcgLimit > 0 {
+			limit = fmt.Sprintf(" of %s", humanize.IBytes(r.MemcgLimit))
+		}
+		return &analysis.Observation{
+			Type:    analysis.Warning,
+			Message: fmt.Sprintf("OOM kill of %s (pid %d, %s resident) in cgroup %s, at its limit%s", r.Victim, r.VictimPID, humanize.IBytes(r.RSS()), memcg, limit),
+			See:     "systemd-cgtop",
+		}
+	}
+	return &analysis.Observation{
+		Type:    analysis.Issue,
+		Message: fmt.Sprintf("OOM kill of %s (pid %d, %s resident), the system ran out of memory", r.Victim, r.VictimPID, humanize.IBytes(r.RSS())),
+		See:     "free",
+	}
+}
+
+// oomObservations tells which cgroups hit their limit, and how often the system ran out of memory
+func (p *KernelRingBufferProbe) oomObservations() (observations []*analysis.Observation) {
+	byMemcg := make(map[string][]*OOMReport)
+	var global []*OOMReport
+	for _, r := range p.OOMReports {
+		if r.InCgroup() {
+			byMemcg[r.Memcg] = append(byMemcg[r.Memcg], r)
+		} else {
+			global = append(global, r)
+		}
+	}
+	memcgs := make([]string, 0, len(byMemcg))
+	for memcg := range byMemcg {
+		memcgs = append(memcgs, memcg)
+	}
+	sort.Strings(memcgs)
+	for _, memcg := range memcgs {
+		cgroupReports := byMemcg[memcg]
+		last := cgroupReports[len(cgroupReports)-1]
+		if memcg == "" {
+			memcg = "(unknown)"
+		}
+		limit := ""
+		if last.MemcgLimit > 0 {
+			limit = fmt.Sprintf(" of %s", humanize.IBytes(last.MemcgLimit))
+		}
+		observationType, when := analysis.Warning, ""
+		if p.historic(last.Time) {
+			observationType, when = analysis.Note, " "+ago(p.Now, last.Time)
+		}
+		observations = append(observations, &analysis.Observation{
+			Type: observationType,
+			Message: fmt.Sprintf("Cgroup %s hit its memory limit%s %d time(s), last killing %s (pid %d, %s resident)%s - raise the limit or find what grows in it",
+				memcg, limit, len(cgroupReports), last.Victim, last.VictimPID, humanize.IBytes(last.RSS()), when),
+			See: "systemd-cgtop",
+		})
+	}
+	if len(global) > 0 {
+		last := global[len(global)-1]
+		trigger := ""
+		if last.Trigger != "" {
+			trigger = fmt.Sprintf(", an allocation of %s (gfp_mask=%s, order=%d) triggering it", last.Trigger, last.GFPMask, last.Order)
+		}
+		observationType, when := analysis.Issue, ""
+		if p.historic(last.Time) {
+			observationType, when = analysis.Note, " "+ago(p.Now, last.Time)
+		}
+		observations = append(observations, &analysis.Observation{
+			Type: observationType,
+			Message: fmt.Sprintf("The system ran out of memory %d time(s), last killing %s (pid %d, %s resident)%s%s",
+				len(global), last.Victim, last.VictimPID, humanize.IBytes(last.RSS()), when, trigger),
+			See: "free",
+		})
+	}
+	return
+}
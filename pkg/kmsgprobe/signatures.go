@@ -0,0 +1,141 @@
+package kmsgprobe
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/sredog/sre/pkg/analysis"
+)
+
+// Signature is a failure recognised by the lines the kernel logs about it
+type Signature struct {
+	ID string
+	// Description says what the failure means and where to look next
+	Description string
+	RE          *regexp.Regexp
+	// Type of the observation made when the signature matches
+	Type analysis.ObservationType
+	See  string
+}
+
+// MaxExamples is how many matching lines are kept per signature
+const MaxExamples = 3
+
+// SignatureMatches counts the lines matching a signature, keeping the first ones as examples
+type SignatureMatches struct {
+	Count int64
+	// Historic counts the lines logged more than RecentWindow ago
+	Historic int64
+	// Last is when the last line was logged, zero when not known
+	Last     time.Time
+	Examples []string
+}
+
+var signatures []*Signature
+
+// RegisterSignature adds a signature to the catalog the probes match messages against
+func RegisterSignature(s *Signature) {
+	for _, registered := range signatures {
+		if registered.ID == s.ID {
+			panic(fmt.Errorf("signature %q is already registered", s.ID))
+		}
+	}
+	signatures = append(signatures, s)
+}
+
+// Signatures returns the catalog, in the order the signatures were registered
+func Signatures() []*Signature {
+	return append([]*Signature(nil), signatures...)
+}
+
+func init() {
+	for _, s := range []*Signature{
+		{
+			ID:          "hung_task",
+			Description: "Tasks stuck in uninterruptible sleep for minutes, usually waiting on storage or NFS",
+			RE:          regexp.MustCompile(`task \S+ blocked for more than \d+ seconds`),
+			Type:        analysis.Issue,
+			See:         "dmesg",
+		},
+		{
+			ID:          "soft_lockup",
+			Description: "A CPU looped in the kernel without scheduling for seconds",
+			RE:          regexp.MustCompile(`soft lockup - CPU#\d+ stuck`),
+			Type:        analysis.Issue,
+			See:         "dmesg",
+		},
+		{
+			ID:          "hard_lockup",
+			Description: "A CPU stopped taking interrupts, a kernel or hardware bug",
+			RE:          regexp.MustCompile(`(?i)hard lockup`),
+			Type:        analysis.Issue,
+			See:         "dmesg",
+		},
+		{
+			ID:          "rcu_stall",
+			Description: "RCU grace periods stalled, a CPU hogged in the kernel or starved by interrupts or a hypervisor",
+			RE:          regexp.MustCompile(`rcu_\w+ (self-)?detected stall|rcu: INFO: .*detected stalls?`),
+			Type:        analysis.Issue,
+			See:         "dmesg",
+		},
+		{
+			ID:          "segfault",
+			Description: "Processes crashed on invalid memory accesses",
+			RE:          regexp.MustCompile(`\S+\[\d+\]: segfault at`),
+			Type:        analysis.Warning,
+			See:         "dmesg",
+		},
+		{
+			ID:          "general_protection",
+			Description: "General protection faults, crashes of processes or of the kernel",
+			RE:          regexp.MustCompile(`general protection( fault)?`),
+			Type:        analysis.Warning,
+			See:         "dmesg",
+		},
+		{
+			ID:          "io_error",
+			Description: "Block devices failed requests, check the disk health and cabling",
+			RE:          regexp.MustCompile(`I/O error,? (on )?dev|critical (medium|target) error, dev`),
+			Type:        analysis.Issue,
+			See:         "iostat",
+		},
+		{
+			ID:          "fs_error",
+			Description: "Filesystems found corruption, they may have gone read-only",
+			RE:          regexp.MustCompile(`EXT[234]-fs error|XFS \(\S+\): (Corruption|Metadata corruption|metadata I/O error|Internal error)|BTRFS (error|critical)`),
+			Type:        analysis.Issue,
+			See:         "df",
+		},
+		{
+			ID:          "link_down",
+			Description: "Network links went down, flapping links point at cables, transceivers or the switch",
+			RE:          regexp.MustCompile(`(?i)\blink (is )?down\b`),
+			Type:        analysis.Warning,
+			See:         "ethtool",
+		},
+		{
+			ID:          "machine_check",
+			Description: "The CPU reported hardware errors, e.g. in memory or caches",
+			RE:          regexp.MustCompile(`(?i)machine check|\[Hardware Error\]`),
+			Type:        analysis.Issue,
+			See:         "dmesg",
+		},
+		{
+			ID:          "conntrack_full",
+			Description: "The connection tracking table is full and packets are dropped, raise nf_conntrack_max",
+			RE:          regexp.MustCompile(`nf_conntrack: (nf_conntrack: )?table full, dropping packet`),
+			Type:        analysis.Issue,
+			See:         "nstat",
+		},
+		{
+			ID:          "syn_flood",
+			Description: "Listening sockets got more SYNs than their backlog holds, a flood or a too small backlog",
+			RE:          regexp.MustCompile(`Possible SYN flooding on port`),
+			Type:        analysis.Warning,
+			See:         "ss",
+		},
+	} {
+		RegisterSignature(s)
+	}
+}
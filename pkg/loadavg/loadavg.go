@@ -4,6 +4,7 @@
 package loadavg
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/enescakir/emoji"
@@ -14,10 +15,13 @@ import (
 
 type LoadAvgProvider interface {
 	LoadAvg() (*procfs.LoadAvg, error)
+	Stat() (procfs.Stat, error)
 }
 
 type LoadAverageProbe struct {
 	L *procfs.LoadAvg
+	// CPUCount puts the load in perspective
+	CPUCount int
 }
 
 // NewLoadAverage reads the load average data and returns its representation
@@ -26,12 +30,32 @@ func NewLoadAverage(p LoadAvgProvider) (*LoadAverageProbe, error) {
 	if err != nil {
 		return nil, err
 	}
+	stat, err := p.Stat()
+	if err != nil {
+		return nil, err
+	}
 	la := &LoadAverageProbe{
-		L: l,
+		L:        l,
+		CPUCount: len(stat.CPU),
 	}
 	return la, nil
 }
 
+func init() {
+	analysis.Register(&analysis.ProbeConfiguration{
+		ID:          "loadavg",
+		Aliases:     []string{"load"},
+		Description: "Load averages over 1, 5 and 15 minutes",
+		Build: func(ctx context.Context, env *analysis.Environment) (analysis.Probe, error) {
+			fs, err := env.Roots.ProcFS()
+			if err != nil {
+				return nil, err
+			}
+			return NewLoadAverage(fs)
+		},
+	})
+}
+
 const displayFormat = "%v Load avg: %v (1m), %v (5m), %v (15m)\n"
 
 func (la *LoadAverageProbe) Display() string {
@@ -44,6 +68,10 @@ func (la *LoadAverageProbe) Display() string {
 	)
 }
 
+func (la *LoadAverageProbe) Serialize() interface{} {
+	return la.L
+}
+
 func (la *LoadAverageProbe) Analysis() (observations []*analysis.Observation) {
 	epsilon := 0.01
 	if la.L.Load1 < epsilon && la.L.Load5 < epsilon && la.L.Load15 < epsilon {
@@ -64,6 +92,13 @@ func (la *LoadAverageProbe) Analysis() (observations []*analysis.Observation) {
 			Message: "The load is decreasing",
 		})
 	}
+	if perCPU := la.L.Load1 / float64(la.CPUCount); la.CPUCount > 0 && perCPU > 1 {
+		observations = append(observations, &analysis.Observation{
+			Type: analysis.Hint,
+			Message: fmt.Sprintf("The load is %0.2f per CPU, but it counts tasks waiting on I/O too - "+
+				"see whether tasks are stalling on CPU, memory or I/O with `sre quick --probes psi`", perCPU),
+		})
+	}
 	observations = append(observations, &analysis.Observation{
 		Type:    analysis.Hint,
 		Message: "Learn more about load averages https://www.brendangregg.com/blog/2017-08-08/linux-load-averages.html",
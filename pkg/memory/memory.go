@@ -4,6 +4,7 @@
 package memory
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/dustin/go-humanize"
@@ -12,6 +13,8 @@ import (
 	"github.com/prometheus/procfs"
 	"github.com/sredog/sre/pkg/analysis"
 	"github.com/sredog/sre/pkg/format"
+	"github.com/sredog/sre/pkg/slab"
+	"github.com/sredog/sre/pkg/vmstat"
 )
 
 type MemInfoProvider interface {
@@ -20,6 +23,10 @@ type MemInfoProvider interface {
 
 type MemoryProbe struct {
 	Meminfo *procfs.Meminfo
+	// Activity is the paging and reclaim over the interval, nil when not sampled
+	Activity *vmstat.VMStatProbe
+	// Slab ranks the slab caches, nil when /proc/slabinfo couldn't be read
+	Slab *slab.SlabProbe `json:"-"`
 }
 
 // NewMemoryProbe creates an instance of MemoryProbe
@@ -34,6 +41,38 @@ func NewMemoryProbe(p MemInfoProvider) (*MemoryProbe, error) {
 	return la, nil
 }
 
+func init() {
+	analysis.Register(&analysis.ProbeConfiguration{
+		ID:          "memory",
+		Aliases:     []string{"mem"},
+		Description: "Memory and swap usage from /proc/meminfo, and paging activity over the interval",
+		Build: func(ctx context.Context, env *analysis.Environment) (analysis.Probe, error) {
+			fs, err := env.Roots.ProcFS()
+			if err != nil {
+				return nil, err
+			}
+			p, err := NewMemoryProbe(fs)
+			if err != nil {
+				return nil, err
+			}
+			s, err := vmstat.SharedSample(ctx, env)
+			if err != nil {
+				return nil, err
+			}
+			p.Activity = vmstat.NewVMStatProbeFromSample(s)
+			if caches, err := slab.NewSlabProbe(fs, 0); err == nil && caches.Unavailable == "" {
+				p.Slab = caches
+			}
+			return p, nil
+		},
+	})
+}
+
+// Utilization returns the ratio of memory that is not available for new allocations
+func (p *MemoryProbe) Utilization() float64 {
+	return 1 - (float64(*p.Meminfo.MemAvailable) / float64(*p.Meminfo.MemTotal))
+}
+
 const displayFormat = `%v Memory is %s used
 Total: %v, available: %v (free: %v, caches: %v, buffers: %v)
 Swap total: %v, free: %v
@@ -42,13 +81,13 @@ Kernel slab: %v (reclaimable: %v, or %s)
 
 func (p *MemoryProbe) Display() string {
 	bold := color.New(color.Bold)
-	var memoryUtilization float64 = 1 - (float64(*p.Meminfo.MemAvailable) / float64(*p.Meminfo.MemTotal))
+	memoryUtilization := p.Utilization()
 	memoryColor := format.ColorForUtilization(memoryUtilization, 0.9, 0.75, 0.5)
 	var slabReclaimable float64 = (float64(*p.Meminfo.SReclaimable) / float64(*p.Meminfo.Slab))
 	var slabOfTotal float64 = (float64(*p.Meminfo.Slab) / float64(*p.Meminfo.MemTotal))
 	slabColor := format.ColorForUtilization(slabOfTotal, 0.2, 0.1, 0.075)
 	var factor uint64 = 1000
-	return fmt.Sprintf(displayFormat,
+	display := fmt.Sprintf(displayFormat,
 		emoji.ComputerDisk,
 		memoryColor.Sprintf("%0.2f%%", memoryUtilization*100),
 		// all the values in /proc/meminfo are in kB
@@ -63,12 +102,222 @@ func (p *MemoryProbe) Display() string {
 		bold.Sprint(humanize.Bytes(factor**p.Meminfo.SReclaimable)),
 		bold.Sprintf("%0.2f%%", slabReclaimable*100),
 	)
+	if p.Activity != nil {
+		display += p.Activity.Summary() + "\n"
+	}
+	return display
+}
+
+func (p *MemoryProbe) Serialize() interface{} {
+	var activity, slabCaches interface{}
+	if p.Activity != nil {
+		activity = p.Activity.Serialize()
+	}
+	if p.Slab != nil {
+		slabCaches = p.Slab.Serialize()
+	}
+	return struct {
+		*procfs.Meminfo
+		Activity interface{} `json:",omitempty"`
+		// SlabCaches break Slab of meminfo down
+		SlabCaches interface{} `json:",omitempty"`
+	}{
+		Meminfo:    p.Meminfo,
+		Activity:   activity,
+		SlabCaches: slabCaches,
+	}
+}
+
+// value returns a field of meminfo, 0 when the kernel doesn't report it
+func value(v *uint64) uint64 {
+	if v == nil {
+		return 0
+	}
+	return *v
+}
+
+// size formats kB of meminfo as the display does
+func size(kB uint64) string {
+	return humanize.Bytes(1000 * kB)
+}
+
+// share returns part of whole, 0 when whole is
+func share(part, whole uint64) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return float64(part) / float64(whole)
+}
+
+// rules are the memory pressure analysis, each rule saying what it fired on
+var rules = []func(mi *procfs.Meminfo) *analysis.Observation{
+	lowAvailable,
+	swapWhileAvailable,
+	unreclaimableSlab,
+	dirtyPages,
+	overcommit,
+	unusedHugePages,
+	largeShmem,
+}
+
+func lowAvailable(mi *procfs.Meminfo) *analysis.Observation {
+	total, available := value(mi.MemTotal), value(mi.MemAvailable)
+	availableShare := share(available, total)
+	var t analysis.ObservationType
+	switch {
+	case total == 0 || mi.MemAvailable == nil:
+		return nil
+	case availableShare < 0.05:
+		t = analysis.Issue
+	case availableShare < 0.1:
+		t = analysis.Warning
+	default:
+		return nil
+	}
+	return &analysis.Observation{
+		Type: t,
+		Message: fmt.Sprintf("Only %s (%0.1f%%) of the %s of memory is available, free: %s - the kernel reclaims hard and the OOM killer is close",
+			size(available), availableShare*100, size(total), size(value(mi.MemFree))),
+		See: "free",
+	}
+}
+
+func swapWhileAvailable(mi *procfs.Meminfo) *analysis.Observation {
+	swapTotal := value(mi.SwapTotal)
+	swapUsed := swapTotal - value(mi.SwapFree)
+	available := share(value(mi.MemAvailable), value(mi.MemTotal))
+	if swapTotal == 0 || share(swapUsed, swapTotal) < 0.1 || available < 0.5 {
+		return nil
+	}
+	return &analysis.Observation{
+		Type: analysis.Note,
+		Message: fmt.Sprintf("%s of swap (%0.0f%%) is in use while %0.0f%% of memory is available - left over from earlier pressure, or vm.swappiness favours swapping over dropping caches",
+			size(swapUsed), share(swapUsed, swapTotal)*100, available*100),
+		See: "vmstat",
+	}
+}
+
+func unreclaimableSlab(mi *procfs.Meminfo) *analysis.Observation {
+	unreclaimable, total := value(mi.SUnreclaim), value(mi.MemTotal)
+	if share(unreclaimable, total) < 0.1 {
+		return nil
+	}
+	return &analysis.Observation{
+		Type: analysis.Warning,
+		Message: fmt.Sprintf("The kernel holds %s of unreclaimable slab (%0.1f%% of memory, %s reclaimable) - a leak in a driver or module, or objects pinned by the workload",
+			size(unreclaimable), share(unreclaimable, total)*100, size(value(mi.SReclaimable))),
+		See: "slabtop",
+	}
+}
+
+func dirtyPages(mi *procfs.Meminfo) *analysis.Observation {
+	dirty, writeback, total := value(mi.Dirty), value(mi.Writeback), value(mi.MemTotal)
+	if share(dirty+writeback, total) < 0.1 {
+		return nil
+	}
+	return &analysis.Observation{
+		Type: analysis.Warning,
+		Message: fmt.Sprintf("%s of dirty pages and %s under writeback (%0.1f%% of memory) - the disks don't keep up with the writes, and writers get throttled near vm.dirty_ratio",
+			size(dirty), size(writeback), share(dirty+writeback, total)*100),
+		See: "iostat",
+	}
+}
+
+func overcommit(mi *procfs.Meminfo) *analysis.Observation {
+	committed, limit := value(mi.CommittedAS), value(mi.CommitLimit)
+	if limit == 0 || committed <= limit {
+		return nil
+	}
+	// allocations only fail past the limit with vm.overcommit_memory=2, otherwise the
+	// commitments are a risk once they can't be backed by memory and swap
+	backing := value(mi.MemTotal) + value(mi.SwapTotal)
+	t := analysis.Note
+	if committed > backing {
+		t = analysis.Warning
+	}
+	return &analysis.Observation{
+		Type: t,
+		Message: fmt.Sprintf("%s of memory is committed, %0.0f%% of the CommitLimit of %s and %0.0f%% of memory and swap - with vm.overcommit_memory=2 allocations fail, otherwise the OOM killer steps in if it all gets used",
+			size(committed), share(committed, limit)*100, size(limit), share(committed, backing)*100),
+		See: "meminfo",
+	}
+}
+
+func unusedHugePages(mi *procfs.Meminfo) *analysis.Observation {
+	pages, free, reserved := value(mi.HugePagesTotal), value(mi.HugePagesFree), value(mi.HugePagesRsvd)
+	if pages == 0 || free <= reserved {
+		return nil
+	}
+	// reserved pages are promised to mappings that didn't touch them yet
+	unused := free - reserved
+	unusedSize := unused * value(mi.Hugepagesize)
+	if share(unusedSize, value(mi.MemTotal)) < 0.05 {
+		return nil
+	}
+	return &analysis.Observation{
+		Type: analysis.Warning,
+		Message: fmt.Sprintf("%d of the %d huge pages are neither used nor reserved (%s, %0.1f%% of memory) - set aside and unavailable to anything else, check vm.nr_hugepages",
+			unused, pages, size(unusedSize), share(unusedSize, value(mi.MemTotal))*100),
+		See: "meminfo",
+	}
+}
+
+func largeShmem(mi *procfs.Meminfo) *analysis.Observation {
+	shmem, total := value(mi.Shmem), value(mi.MemTotal)
+	if share(shmem, total) < 0.2 {
+		return nil
+	}
+	return &analysis.Observation{
+		Type: analysis.Warning,
+		Message: fmt.Sprintf("%s of memory (%0.1f%%) is shared memory and tmpfs - it counts as cache but can only be freed by deleting files or swapping, check `df -t tmpfs` and `ipcs -m`",
+			size(shmem), share(shmem, total)*100),
+		See: "df",
+	}
+}
+
+// slabHigh is the share of memory in slab the display colors as high, and the
+// analysis names the largest caches from
+const slabHigh = 0.1
+
+// topSlabCaches is how many caches the analysis names
+const topSlabCaches = 3
+
+// slabCaches names the largest caches in the observation about the slab, making one when
+// the unreclaimable part isn't large enough for a rule to fire
+func (p *MemoryProbe) slabCaches(observations []*analysis.Observation) []*analysis.Observation {
+	slabSize, total := value(p.Meminfo.Slab), value(p.Meminfo.MemTotal)
+	if p.Slab == nil || share(slabSize, total) < slabHigh {
+		return observations
+	}
+	caches := fmt.Sprintf("largest caches: %s", p.Slab.Summary(topSlabCaches))
+	for _, o := range observations {
+		if o.See == "slabtop" {
+			o.Message += ". The " + caches
+			return observations
+		}
+	}
+	return append(observations, &analysis.Observation{
+		Type: analysis.Note,
+		Message: fmt.Sprintf("The kernel slab takes %s (%0.1f%% of memory, %s reclaimable), %s",
+			size(slabSize), share(slabSize, total)*100, size(value(p.Meminfo.SReclaimable)), caches),
+		See: "slabtop",
+	})
 }
 
 func (p *MemoryProbe) Analysis() (observations []*analysis.Observation) {
+	for _, rule := range rules {
+		if o := rule(p.Meminfo); o != nil {
+			observations = append(observations, o)
+		}
+	}
+	observations = p.slabCaches(observations)
+	if p.Activity != nil {
+		observations = append(observations, p.Activity.Analysis()...)
+	}
 	observations = append(observations, &analysis.Observation{
 		Type:    analysis.Learn,
 		Message: "Have you tried running `cat /proc/meminfo`?",
+		See:     "meminfo",
 	})
 	return
 }
@@ -0,0 +1,98 @@
+package memory
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/procfs"
+	"github.com/sredog/sre/pkg/analysis"
+	"github.com/sredog/sre/pkg/slab"
+)
+
+func kB(v uint64) *uint64 {
+	return &v
+}
+
+func TestRules(t *testing.T) {
+	p := &MemoryProbe{Meminfo: &procfs.Meminfo{
+		MemTotal:       kB(16000000),
+		MemFree:        kB(200000),
+		MemAvailable:   kB(600000),
+		SwapTotal:      kB(4000000),
+		SwapFree:       kB(1000000),
+		Dirty:          kB(1000000),
+		Writeback:      kB(700000),
+		Shmem:          kB(4000000),
+		Slab:           kB(2500000),
+		SReclaimable:   kB(500000),
+		SUnreclaim:     kB(2000000),
+		CommitLimit:    kB(12000000),
+		CommittedAS:    kB(24000000),
+		HugePagesTotal: kB(1024),
+		HugePagesFree:  kB(1000),
+		HugePagesRsvd:  kB(100),
+		Hugepagesize:   kB(2048),
+	}}
+	var messages []string
+	types := make(map[analysis.ObservationType]int)
+	for _, o := range p.Analysis() {
+		messages = append(messages, o.Message)
+		types[o.Type]++
+	}
+	// every rule but swapping while memory is available fires, along with the one to learn about meminfo
+	if len(messages) != len(rules) {
+		t.Fatalf("Expected %d observations, got %q", len(rules), messages)
+	}
+	if types[analysis.Issue] != 1 || types[analysis.Warning] != 5 {
+		t.Errorf("Expected low memory to be an issue and the rest warnings, got %v", types)
+	}
+	all := strings.Join(messages, "\n")
+	for _, number := range []string{
+		"Only 600 MB (3.8%) of the 16 GB",
+		"2.0 GB of unreclaimable slab (12.5% of memory",
+		"1.0 GB of dirty pages and 700 MB under writeback",
+		"200% of the CommitLimit of 12 GB and 120% of memory and swap",
+		"900 of the 1024 huge pages",
+		"4.0 GB of memory (25.0%) is shared memory",
+	} {
+		if !strings.Contains(all, number) {
+			t.Errorf("Expected %q in the observations, got %q", number, messages)
+		}
+	}
+
+	// the largest slab caches are named in the observation about the slab
+	p.Slab = &slab.SlabProbe{Caches: []*slab.Cache{{Name: "kmalloc-256", Size: 1500000000}, {Name: "dentry", Size: 300000000}}}
+	found := false
+	for _, o := range p.Analysis() {
+		if o.See == "slabtop" {
+			found = strings.HasSuffix(o.Message, "The largest caches: kmalloc-256 1.5 GB, dentry 300 MB")
+		}
+	}
+	if !found {
+		t.Errorf("Expected the slab observation to name the largest caches, got %q", messages)
+	}
+	// and serialized next to the Slab total of meminfo
+	serialized, err := json.Marshal(p.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{`"Slab":2500000`, `"SlabCaches":{"Caches":[{"Name":"kmalloc-256"`} {
+		if !strings.Contains(string(serialized), field) {
+			t.Errorf("Expected %s in %s", field, serialized)
+		}
+	}
+	p.Slab = nil
+
+	// swap left over while most of the memory is available
+	p.Meminfo = &procfs.Meminfo{
+		MemTotal:     kB(16000000),
+		MemAvailable: kB(12000000),
+		SwapTotal:    kB(4000000),
+		SwapFree:     kB(3000000),
+	}
+	observations := p.Analysis()
+	if len(observations) != 2 || observations[0].Type != analysis.Note || !strings.Contains(observations[0].Message, "1.0 GB of swap (25%) is in use while 75% of memory is available") {
+		t.Errorf("Expected a note about swap, got %+v", observations[0])
+	}
+}
@@ -0,0 +1,283 @@
+// Package network samples the counters of network interfaces from /proc/net/dev,
+// along with their link settings from /sys/class/net
+// See https://www.kernel.org/doc/Documentation/ABI/testing/sysfs-class-net
+package network
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/enescakir/emoji"
+	"github.com/fatih/color"
+	"github.com/prometheus/procfs"
+	"github.com/sredog/sre/pkg/analysis"
+	"github.com/sredog/sre/pkg/format"
+	"github.com/sredog/sre/pkg/fsroot"
+)
+
+// Sample is /proc/net/dev before and after the interval
+type Sample analysis.Sample[procfs.NetDev]
+
+func readNetDev(roots *fsroot.Roots) (procfs.NetDev, error) {
+	fs, err := roots.ProcFS()
+	if err != nil {
+		return nil, err
+	}
+	return fs.NetDev()
+}
+
+// TakeSample samples the counters of every interface
+func TakeSample(ctx context.Context, roots *fsroot.Roots, interval time.Duration) (*Sample, error) {
+	s, err := analysis.TakeSample(ctx, roots.Proc, interval, func() (procfs.NetDev, error) {
+		return readNetDev(roots)
+	})
+	return (*Sample)(s), err
+}
+
+// Link holds the settings of an interface from /sys/class/net
+type Link struct {
+	OperState string
+	MTU       int64
+	// Speed in Mbit/s, 0 when the driver doesn't report it, e.g. virtio
+	Speed int64
+	// CarrierChanges counts the link going up and down since boot
+	CarrierChanges int64
+}
+
+// ReadLink reads the settings of the interface, best-effort
+func ReadLink(roots *fsroot.Roots, name string) *Link {
+	l := &Link{}
+	fs, err := roots.SysFS()
+	if err != nil {
+		return l
+	}
+	iface, err := fs.NetClassByIface(name)
+	if err != nil {
+		return l
+	}
+	l.OperState = iface.OperState
+	if iface.MTU != nil {
+		l.MTU = *iface.MTU
+	}
+	if iface.Speed != nil && *iface.Speed > 0 {
+		l.Speed = *iface.Speed
+	}
+	if iface.CarrierChanges != nil {
+		l.CarrierChanges = *iface.CarrierChanges
+	}
+	return l
+}
+
+// Rates are per second
+type Rates struct {
+	RxBytes   float64
+	TxBytes   float64
+	RxPackets float64
+	TxPackets float64
+	RxErrors  float64
+	TxErrors  float64
+	RxDropped float64
+	TxDropped float64
+	// RxFIFO and TxFIFO are overruns of the NIC's buffers
+	RxFIFO float64
+	TxFIFO float64
+}
+
+// Errors returns the errors per second, framing and carrier errors included
+func (r *Rates) Errors() float64 {
+	return r.RxErrors + r.TxErrors
+}
+
+// Drops returns the packets dropped or overrun per second
+func (r *Rates) Drops() float64 {
+	return r.RxDropped + r.TxDropped + r.RxFIFO + r.TxFIFO
+}
+
+// Interface holds the rates of a single interface over the sample
+type Interface struct {
+	Name string
+	*Link
+	Rates
+}
+
+// NewInterface computes the rates between the counters, before can be the zero value for since boot
+func NewInterface(name string, before, after procfs.NetDevLine, elapsed time.Duration, link *Link) *Interface {
+	seconds := elapsed.Seconds()
+	rate := func(before, after uint64) float64 {
+		// counters reset when a driver is reloaded
+		if after < before {
+			return 0
+		}
+		return float64(after-before) / seconds
+	}
+	return &Interface{
+		Name: name,
+		Link: link,
+		Rates: Rates{
+			RxBytes:   rate(before.RxBytes, after.RxBytes),
+			TxBytes:   rate(before.TxBytes, after.TxBytes),
+			RxPackets: rate(before.RxPackets, after.RxPackets),
+			TxPackets: rate(before.TxPackets, after.TxPackets),
+			RxErrors:  rate(before.RxErrors+before.RxFrame, after.RxErrors+after.RxFrame),
+			TxErrors:  rate(before.TxErrors+before.TxCarrier, after.TxErrors+after.TxCarrier),
+			RxDropped: rate(before.RxDropped, after.RxDropped),
+			TxDropped: rate(before.TxDropped, after.TxDropped),
+			RxFIFO:    rate(before.RxFIFO, after.RxFIFO),
+			TxFIFO:    rate(before.TxFIFO, after.TxFIFO),
+		},
+	}
+}
+
+// Utilization returns the share of the link speed used by the busier direction, the link
+// being full duplex. It's 0 when the speed isn't known, some drivers report -1.
+func (i *Interface) Utilization() float64 {
+	if i.Link == nil || i.Speed <= 0 {
+		return 0
+	}
+	busier := i.RxBytes
+	if i.TxBytes > busier {
+		busier = i.TxBytes
+	}
+	return busier * 8 / (float64(i.Speed) * 1000 * 1000)
+}
+
+type NetworkProbe struct {
+	// Interfaces sorted by name, without loopback
+	Interfaces []*Interface
+	Interval   time.Duration
+}
+
+// NewNetworkProbe reads /proc/net/dev twice, interval apart, or once for the rates since boot
+func NewNetworkProbe(ctx context.Context, roots *fsroot.Roots, interval time.Duration) (*NetworkProbe, error) {
+	s, err := TakeSample(ctx, roots, interval)
+	if err != nil {
+		return nil, err
+	}
+	return NewNetworkProbeFromSample(roots, s), nil
+}
+
+// NewNetworkProbeFromSample works out the rates of the interfaces in the sample, loopback
+// left out, and reads their link state and speed from sysfs
+func NewNetworkProbeFromSample(roots *fsroot.Roots, s *Sample) *NetworkProbe {
+	p := &NetworkProbe{
+		Interval: s.Interval,
+	}
+	for name, after := range s.After {
+		if name == "lo" {
+			continue
+		}
+		var before procfs.NetDevLine
+		if s.Before != nil {
+			var ok bool
+			// interfaces created while sampling
+			if before, ok = s.Before[name]; !ok {
+				continue
+			}
+		}
+		p.Interfaces = append(p.Interfaces, NewInterface(name, before, after, s.Elapsed, ReadLink(roots, name)))
+	}
+	sort.Slice(p.Interfaces, func(i, j int) bool { return p.Interfaces[i].Name < p.Interfaces[j].Name })
+	return p
+}
+
+func init() {
+	analysis.Register(&analysis.ProbeConfiguration{
+		ID:          "network",
+		Aliases:     []string{"net"},
+		Description: "Network interfaces throughput, errors, drops, speed, MTU and state over the interval",
+		Build: func(ctx context.Context, env *analysis.Environment) (analysis.Probe, error) {
+			return NewNetworkProbe(ctx, env.Roots, env.Interval)
+		},
+	})
+}
+
+func (p *NetworkProbe) period() string {
+	if p.Interval == 0 {
+		return "since boot"
+	}
+	return fmt.Sprintf("over %v", p.Interval)
+}
+
+const displayFormat = "%v %v network interface(s) %v\n"
+
+const columns = "%-16s %-8s %10s %6s %10s %10s %9s %9s %8s %8s %7s\n"
+
+func (p *NetworkProbe) Display() string {
+	bold := color.New(color.Bold)
+	var sb strings.Builder
+	fmt.Fprintf(&sb, displayFormat, emoji.GlobeWithMeridians, bold.Sprint(len(p.Interfaces)), p.period())
+	if len(p.Interfaces) == 0 {
+		return sb.String()
+	}
+	fmt.Fprintf(&sb, columns, "Interface", "State", "Speed", "MTU", "rxB/s", "txB/s", "rxpck/s", "txpck/s", "errs/s", "drops/s", "%util")
+	for _, i := range p.Interfaces {
+		speed, util := "-", "-"
+		if i.Speed > 0 {
+			speed = fmt.Sprintf("%d Mb/s", i.Speed)
+			util = fmt.Sprintf("%0.1f", i.Utilization()*100)
+		}
+		// padded before coloring, the escape codes would throw the widths off
+		util = fmt.Sprintf("%7s", util)
+		fmt.Fprintf(&sb, columns,
+			i.Name,
+			i.OperState,
+			speed,
+			fmt.Sprint(i.MTU),
+			humanize.Bytes(uint64(i.RxBytes)),
+			humanize.Bytes(uint64(i.TxBytes)),
+			fmt.Sprintf("%0.1f", i.RxPackets),
+			fmt.Sprintf("%0.1f", i.TxPackets),
+			fmt.Sprintf("%0.1f", i.Errors()),
+			fmt.Sprintf("%0.1f", i.Drops()),
+			format.ColorForUtilization(i.Utilization(), 0.9, 0.7, 0.4).Sprint(util),
+		)
+	}
+	return sb.String()
+}
+
+func (p *NetworkProbe) Serialize() interface{} {
+	return struct {
+		IntervalSeconds float64
+		Interfaces      []*Interface
+	}{
+		IntervalSeconds: p.Interval.Seconds(),
+		Interfaces:      p.Interfaces,
+	}
+}
+
+// SinceBoot tells whether the rates are averaged since boot
+func (p *NetworkProbe) SinceBoot() bool {
+	return p.Interval == 0
+}
+
+func (p *NetworkProbe) Analysis() (observations []*analysis.Observation) {
+	for _, i := range p.Interfaces {
+		if i.Errors() > 0 {
+			observations = append(observations, &analysis.Observation{
+				Type: analysis.Warning,
+				Message: fmt.Sprintf("%s has %0.1f errors/s %s (rx %0.1f, tx %0.1f) - check the cable, the NIC and `ethtool -S %s`",
+					i.Name, i.Errors(), p.period(), i.RxErrors, i.TxErrors, i.Name),
+				See: "ethtool",
+			})
+		}
+		if i.Drops() > 0 {
+			observations = append(observations, &analysis.Observation{
+				Type: analysis.Warning,
+				Message: fmt.Sprintf("%s drops %0.1f packets/s %s (rx %0.1f, tx %0.1f, overruns %0.1f) - ring buffers too small, or packets nobody listens for",
+					i.Name, i.Drops(), p.period(), i.RxDropped, i.TxDropped, i.RxFIFO+i.TxFIFO),
+				See: "nstat",
+			})
+		}
+		if utilization := i.Utilization(); utilization > 0.9 {
+			observations = append(observations, &analysis.Observation{
+				Type:    analysis.Warning,
+				Message: fmt.Sprintf("%s runs at %0.0f%% of its %d Mbit/s line rate %s", i.Name, utilization*100, i.Speed, p.period()),
+			})
+		}
+	}
+	return
+}
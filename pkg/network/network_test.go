@@ -0,0 +1,78 @@
+package network
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/procfs"
+	"github.com/sredog/sre/pkg/analysis"
+	"github.com/sredog/sre/pkg/fsroot"
+)
+
+// writeLink writes the sysfs files of an interface
+func writeLink(t *testing.T, sys string, name string, speed string) {
+	dir := filepath.Join(sys, "class", "net", name)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for file, content := range map[string]string{"operstate": "up", "mtu": "1500", "speed": speed} {
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(content+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRates(t *testing.T) {
+	sys := t.TempDir()
+	writeLink(t, sys, "eth0", "1000")
+	// virtio and some bonds report -1
+	writeLink(t, sys, "eth1", "-1")
+	roots := &fsroot.Roots{Sys: sys}
+	p := NewNetworkProbeFromSample(roots, &Sample{
+		Before: procfs.NetDev{
+			"lo":   {Name: "lo"},
+			"eth0": {Name: "eth0", RxBytes: 1000, TxBytes: 0, RxPackets: 10, RxErrors: 1, RxDropped: 0},
+			"eth1": {Name: "eth1", RxBytes: 0},
+		},
+		After: procfs.NetDev{
+			"lo": {Name: "lo", RxBytes: 1 << 30},
+			// 240 MB over 2s, 960 Mbit/s on a 1000 Mbit/s link
+			"eth0": {Name: "eth0", RxBytes: 240001000, TxBytes: 2000, RxPackets: 210, RxErrors: 5, RxFrame: 2, RxDropped: 4, TxFIFO: 2},
+			"eth1": {Name: "eth1", RxBytes: 240000000},
+			// created while sampling
+			"veth9": {Name: "veth9", RxBytes: 100},
+		},
+		Interval: 2 * time.Second,
+		Elapsed:  2 * time.Second,
+	})
+	if len(p.Interfaces) != 2 || p.Interfaces[0].Name != "eth0" || p.Interfaces[1].Name != "eth1" {
+		t.Fatalf("Expected eth0 and eth1 only, got %+v", p.Interfaces)
+	}
+	eth0, eth1 := p.Interfaces[0], p.Interfaces[1]
+	if eth0.RxBytes != 120000000 || eth0.TxBytes != 1000 || eth0.RxPackets != 100 || eth0.Errors() != 3 || eth0.Drops() != 3 {
+		t.Errorf("Unexpected rates %+v", eth0.Rates)
+	}
+	if eth0.Speed != 1000 || eth0.Utilization() < 0.959 || eth0.Utilization() > 0.961 {
+		t.Errorf("Expected 96%% of 1000 Mbit/s, got %0.3f of %d", eth0.Utilization(), eth0.Speed)
+	}
+	if eth1.Speed != 0 || eth1.Utilization() != 0 {
+		t.Errorf("Expected an unknown speed and no utilization, got %0.3f of %d", eth1.Utilization(), eth1.Speed)
+	}
+	if u := (&Interface{Link: &Link{Speed: -1}, Rates: Rates{RxBytes: 1000}}).Utilization(); u != 0 {
+		t.Errorf("Expected no utilization for a speed of -1, got %0.3f", u)
+	}
+
+	var warnings []string
+	for _, o := range p.Analysis() {
+		if o.Type == analysis.Warning {
+			warnings = append(warnings, o.Message)
+		}
+	}
+	all := strings.Join(warnings, "\n")
+	if len(warnings) != 3 || !strings.Contains(all, "eth0 has 3.0 errors/s") || !strings.Contains(all, "eth0 drops 3.0 packets/s") || !strings.Contains(all, "eth0 runs at 96%") {
+		t.Errorf("Expected errors, drops and utilization of eth0 as warnings, got %q", warnings)
+	}
+}
@@ -0,0 +1,350 @@
+// Package numa shows how memory and CPUs split across the NUMA nodes, and how often
+// allocations land on another node than the one they wanted
+// See https://www.kernel.org/doc/html/latest/admin-guide/numastat.html
+// and https://www.kernel.org/doc/Documentation/ABI/stable/sysfs-devices-node
+package numa
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/enescakir/emoji"
+	"github.com/fatih/color"
+	"github.com/sredog/sre/pkg/analysis"
+	"github.com/sredog/sre/pkg/format"
+	"github.com/sredog/sre/pkg/fsroot"
+	"github.com/sredog/sre/pkg/vmstat"
+)
+
+// Node is what sysfs tells about a NUMA node
+type Node struct {
+	ID int
+	// CPUs is the cpulist of the node, e.g. 0-15,32-47, empty for memory-only nodes
+	CPUs string
+	// Meminfo of the node in kB, except for the HugePages counts
+	Meminfo map[string]uint64
+	// Stat are the numastat counters, in pages
+	Stat map[string]uint64
+}
+
+// ParseNodeMeminfo parses the meminfo of a node, whose lines are prefixed with it:
+// Node 0 MemTotal:       16318040 kB
+// Node 0 HugePages_Total:     0
+func ParseNodeMeminfo(r io.Reader) (map[string]uint64, error) {
+	meminfo := make(map[string]uint64)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || fields[0] != "Node" {
+			continue
+		}
+		name := strings.TrimSuffix(fields[2], ":")
+		val, err := strconv.ParseUint(fields[3], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse %s: %w", name, err)
+		}
+		meminfo[name] = val
+	}
+	return meminfo, scanner.Err()
+}
+
+func readNodeMeminfo(path string) (map[string]uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	meminfo, err := ParseNodeMeminfo(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return meminfo, nil
+}
+
+// ReadNodes reads the online NUMA nodes from sysfs, ordered by ID. Kernels built
+// without NUMA support have none, the error is then os.ErrNotExist.
+func ReadNodes(roots *fsroot.Roots) ([]*Node, error) {
+	dirs, err := filepath.Glob(roots.SysPath("devices", "system", "node", "node[0-9]*"))
+	if err != nil {
+		return nil, err
+	}
+	if len(dirs) == 0 {
+		return nil, fmt.Errorf("no NUMA nodes in %s: %w", roots.SysPath("devices", "system", "node"), os.ErrNotExist)
+	}
+	nodes := make([]*Node, 0, len(dirs))
+	for _, dir := range dirs {
+		id, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(dir), "node"))
+		if err != nil {
+			continue
+		}
+		n := &Node{ID: id}
+		if cpus, err := ioutil.ReadFile(filepath.Join(dir, "cpulist")); err == nil {
+			n.CPUs = strings.TrimSpace(string(cpus))
+		}
+		if n.Meminfo, err = readNodeMeminfo(filepath.Join(dir, "meminfo")); err != nil {
+			return nil, err
+		}
+		// numastat has the same "name value" lines as /proc/vmstat
+		if n.Stat, err = vmstat.ReadVMStat(filepath.Join(dir, "numastat")); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+	return nodes, nil
+}
+
+// Sample is the nodes before and after the interval
+type Sample analysis.Sample[[]*Node]
+
+// TakeSample samples the numastat counters of the nodes, along with their memory
+func TakeSample(ctx context.Context, roots *fsroot.Roots, interval time.Duration) (*Sample, error) {
+	s, err := analysis.TakeSample(ctx, roots.Proc, interval, func() ([]*Node, error) {
+		return ReadNodes(roots)
+	})
+	return (*Sample)(s), err
+}
+
+// NodeStats are the memory of a node in bytes, and its numastat counters in pages per second
+type NodeStats struct {
+	ID        int
+	CPUs      string
+	MemTotal  uint64
+	MemFree   uint64
+	FilePages uint64
+	// Hit are the pages allocated on the node they were meant for
+	Hit float64
+	// Miss are the pages allocated on the node although meant for another,
+	// Foreign those meant for the node but allocated on another
+	Miss    float64
+	Foreign float64
+	// LocalNode and OtherNode are the pages allocated on the node by processes
+	// running on it, and on other nodes
+	LocalNode float64
+	OtherNode float64
+}
+
+// FreeShare returns the share of the node's memory that is free
+func (n *NodeStats) FreeShare() float64 {
+	if n.MemTotal == 0 {
+		return 0
+	}
+	return float64(n.MemFree) / float64(n.MemTotal)
+}
+
+// NewNodeStats computes the stats of the nodes over the sample. Nodes that went
+// offline in between are left out, those that came online count since boot.
+func NewNodeStats(s *Sample) []*NodeStats {
+	before := make(map[int]*Node, len(s.Before))
+	for _, n := range s.Before {
+		before[n.ID] = n
+	}
+	seconds := s.Elapsed.Seconds()
+	stats := make([]*NodeStats, 0, len(s.After))
+	for _, n := range s.After {
+		rate := func(name string) float64 {
+			after, previous := n.Stat[name], uint64(0)
+			if b, ok := before[n.ID]; ok {
+				previous = b.Stat[name]
+			}
+			if after < previous || seconds == 0 {
+				return 0
+			}
+			return float64(after-previous) / seconds
+		}
+		stats = append(stats, &NodeStats{
+			ID:        n.ID,
+			CPUs:      n.CPUs,
+			MemTotal:  n.Meminfo["MemTotal"] * 1024,
+			MemFree:   n.Meminfo["MemFree"] * 1024,
+			FilePages: n.Meminfo["FilePages"] * 1024,
+			Hit:       rate("numa_hit"),
+			Miss:      rate("numa_miss"),
+			Foreign:   rate("numa_foreign"),
+			LocalNode: rate("local_node"),
+			OtherNode: rate("other_node"),
+		})
+	}
+	return stats
+}
+
+type NUMAProbe struct {
+	Nodes    []*NodeStats
+	Interval time.Duration
+	// Unavailable explains why sysfs has no NUMA nodes
+	Unavailable string `json:",omitempty"`
+}
+
+// unavailable gives an empty probe saying why when sysfs has no nodes, e.g. kernels
+// built without CONFIG_NUMA, instead of an error
+func unavailable(err error) (*NUMAProbe, error) {
+	if errors.Is(err, os.ErrNotExist) {
+		return &NUMAProbe{Unavailable: fmt.Sprintf("%v, the kernel may be built without CONFIG_NUMA", err)}, nil
+	}
+	return nil, err
+}
+
+// NewNUMAProbe samples the nodes, or reports NUMA as unavailable without them
+func NewNUMAProbe(ctx context.Context, roots *fsroot.Roots, interval time.Duration) (*NUMAProbe, error) {
+	s, err := TakeSample(ctx, roots, interval)
+	if err != nil {
+		return unavailable(err)
+	}
+	return NewNUMAProbeFromSample(s), nil
+}
+
+// NewNUMAProbeFromSample works out the numastat rates of each node in the sample
+func NewNUMAProbeFromSample(s *Sample) *NUMAProbe {
+	return &NUMAProbe{
+		Nodes:    NewNodeStats(s),
+		Interval: s.Interval,
+	}
+}
+
+func init() {
+	analysis.Register(&analysis.ProbeConfiguration{
+		ID:          "numa",
+		Aliases:     []string{"numastat", "nodes"},
+		Description: "Memory and CPUs per NUMA node, and allocations missing their node over the interval",
+		Build: func(ctx context.Context, env *analysis.Environment) (analysis.Probe, error) {
+			return NewNUMAProbe(ctx, env.Roots, env.Interval)
+		},
+	})
+}
+
+func (p *NUMAProbe) period() string {
+	if p.Interval == 0 {
+		return "since boot"
+	}
+	return fmt.Sprintf("over %v", p.Interval)
+}
+
+// MissShare returns the share of the allocations that landed on another node than wanted
+func (p *NUMAProbe) MissShare() float64 {
+	var hit, miss float64
+	for _, n := range p.Nodes {
+		hit += n.Hit
+		miss += n.Miss
+	}
+	if hit+miss == 0 {
+		return 0
+	}
+	return miss / (hit + miss)
+}
+
+func (p *NUMAProbe) Display() string {
+	bold := color.New(color.Bold)
+	var sb strings.Builder
+	if p.Unavailable != "" {
+		fmt.Fprintf(&sb, "%v NUMA: not available\n", emoji.Compass)
+		return sb.String()
+	}
+	fmt.Fprintf(&sb, "%v NUMA: %v node(s), allocations missing their node %v: %v\n",
+		emoji.Compass,
+		bold.Sprint(len(p.Nodes)),
+		p.period(),
+		bold.Sprintf("%0.2f%%", p.MissShare()*100),
+	)
+	for _, n := range p.Nodes {
+		cpus := n.CPUs
+		if cpus == "" {
+			cpus = "none"
+		}
+		// the less free, the hotter
+		freeColor := format.ColorForUtilization(1-n.FreeShare(), 0.95, 0.9, 0.8)
+		fmt.Fprintf(&sb, "Node %d: CPUs %v, free %v of %v (%v), page cache %v, miss %0.1f pages/s, foreign %0.1f pages/s\n",
+			n.ID,
+			bold.Sprint(cpus),
+			bold.Sprint(humanize.Bytes(n.MemFree)),
+			humanize.Bytes(n.MemTotal),
+			freeColor.Sprintf("%0.1f%%", n.FreeShare()*100),
+			humanize.Bytes(n.FilePages),
+			n.Miss,
+			n.Foreign,
+		)
+	}
+	return sb.String()
+}
+
+func (p *NUMAProbe) Serialize() interface{} {
+	return struct {
+		IntervalSeconds float64
+		Nodes           []*NodeStats
+		MissShare       float64
+		Unavailable     string `json:",omitempty"`
+	}{
+		IntervalSeconds: p.Interval.Seconds(),
+		Nodes:           p.Nodes,
+		MissShare:       p.MissShare(),
+		Unavailable:     p.Unavailable,
+	}
+}
+
+// Thresholds of the analysis
+const (
+	// a node is exhausted below lowFree, while another has more than plentyFree
+	lowFree    = 0.05
+	plentyFree = 0.2
+	// missShareWarning of the allocations landing on another node than wanted
+	missShareWarning = 0.1
+)
+
+// SinceBoot tells whether the counters are averaged since boot
+func (p *NUMAProbe) SinceBoot() bool {
+	return p.Interval == 0
+}
+
+func (p *NUMAProbe) Analysis() (observations []*analysis.Observation) {
+	// a single node, or none, has nothing to balance
+	if len(p.Nodes) < 2 {
+		return
+	}
+	var freest *NodeStats
+	for _, n := range p.Nodes {
+		if n.MemTotal > 0 && (freest == nil || n.FreeShare() > freest.FreeShare()) {
+			freest = n
+		}
+	}
+	for _, n := range p.Nodes {
+		if n.MemTotal == 0 || n == freest || n.FreeShare() >= lowFree || freest.FreeShare() < plentyFree {
+			continue
+		}
+		observations = append(observations, &analysis.Observation{
+			Type: analysis.Warning,
+			Message: fmt.Sprintf("Node %d is down to %s free (%0.1f%%) while node %d has %s free (%0.0f%%) - allocations bound to it reclaim or spill to remote memory, check the numactl policies and cpusets",
+				n.ID, humanize.Bytes(n.MemFree), n.FreeShare()*100, freest.ID, humanize.Bytes(freest.MemFree), freest.FreeShare()*100),
+			See: "numastat",
+		})
+	}
+	if share := p.MissShare(); share >= missShareWarning {
+		// the node most allocations were meant for, and didn't get
+		wanted := p.Nodes[0]
+		for _, n := range p.Nodes {
+			if n.Foreign > wanted.Foreign {
+				wanted = n
+			}
+		}
+		observationType := analysis.Warning
+		if p.Interval == 0 {
+			observationType = analysis.Note
+		}
+		observations = append(observations, &analysis.Observation{
+			Type: observationType,
+			Message: fmt.Sprintf("%0.1f%% of the allocations landed on another node than wanted %s, most meant for node %d (%0.0f pages/s) - processes access slower remote memory",
+				share*100, p.period(), wanted.ID, wanted.Foreign),
+			See: "numastat",
+		})
+	}
+	return
+}
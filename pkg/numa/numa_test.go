@@ -0,0 +1,70 @@
+package numa
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sredog/sre/pkg/analysis"
+	"github.com/sredog/sre/pkg/fsroot"
+)
+
+// writeNode writes the sysfs files of a node of 16 GiB, with memFree kB free and miss numa_miss pages
+func writeNode(t *testing.T, sys string, node string, cpus string, memFree string, miss string) {
+	dir := filepath.Join(sys, "devices", "system", "node", "node"+node)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"cpulist": cpus + "\n",
+		"meminfo": "Node " + node + " MemTotal:       16777216 kB\n" +
+			"Node " + node + " MemFree:        " + memFree + " kB\n" +
+			"Node " + node + " FilePages:        524288 kB\n" +
+			"Node " + node + " HugePages_Total:     0\n",
+		"numastat": "numa_hit 900000\nnuma_miss " + miss + "\nnuma_foreign 0\nlocal_node 900000\nother_node 0\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestExhaustedNode(t *testing.T) {
+	sys := t.TempDir()
+	writeNode(t, sys, "0", "0-7,16-23", "8388608", "0")
+	writeNode(t, sys, "1", "8-15,24-31", "262144", "300000")
+	nodes, err := ReadNodes(&fsroot.Roots{Sys: sys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 2 || nodes[1].CPUs != "8-15,24-31" || nodes[1].Meminfo["MemFree"] != 262144 || nodes[1].Meminfo["HugePages_Total"] != 0 {
+		t.Fatalf("Unexpected nodes %+v %+v", nodes[0], nodes[1])
+	}
+	p := NewNUMAProbeFromSample(&Sample{After: nodes, Elapsed: time.Hour})
+	if p.Nodes[1].MemFree != 256<<20 || p.MissShare() < 0.14 || p.MissShare() > 0.15 {
+		t.Errorf("Unexpected stats %+v, miss share %0.2f", p.Nodes[1], p.MissShare())
+	}
+	var warnings, notes int
+	for _, o := range p.Analysis() {
+		switch o.Type {
+		case analysis.Warning:
+			warnings++
+		case analysis.Note:
+			notes++
+		}
+	}
+	// node 1 exhausted while node 0 is half free, and misses since boot
+	if warnings != 1 || notes != 1 {
+		t.Errorf("Expected a warning and a note, got %+v", p.Analysis())
+	}
+}
+
+func TestNoNodes(t *testing.T) {
+	p, err := NewNUMAProbe(context.Background(), &fsroot.Roots{Sys: t.TempDir()}, time.Millisecond)
+	if err != nil || p.Unavailable == "" || len(p.Analysis()) != 0 {
+		t.Errorf("Expected an empty probe saying NUMA is unavailable, got %+v, %v", p, err)
+	}
+}
@@ -0,0 +1,364 @@
+// Package pid takes a deep dive into a single process
+// See https://man7.org/linux/man-pages/man5/proc.5.html
+package pid
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/enescakir/emoji"
+	"github.com/fatih/color"
+	"github.com/prometheus/procfs"
+	"github.com/sredog/sre/pkg/analysis"
+	"github.com/sredog/sre/pkg/format"
+)
+
+// userHZ is the unit of the CPU times in /proc/[pid]/stat, see sysconf(_SC_CLK_TCK)
+const userHZ = 100
+
+// maxParents stops walking the parent chain of processes in pathological cases
+const maxParents = 64
+
+var stateNames = map[string]string{
+	"R": "running",
+	"S": "sleeping",
+	"D": "uninterruptible sleep",
+	"Z": "zombie",
+	"T": "stopped",
+	"t": "tracing stop",
+	"X": "dead",
+	"I": "idle",
+}
+
+type ProcProvider interface {
+	Proc(pid int) (procfs.Proc, error)
+}
+
+// Process is a PID with its command name
+type Process struct {
+	PID  int
+	Comm string
+}
+
+type PIDProbe struct {
+	PID         int
+	Comm        string
+	CmdLine     []string
+	Stat        *procfs.ProcStat
+	Wchan       string
+	Parents     []Process
+	SMapsRollup *procfs.ProcSMapsRollup
+	FDs         int
+	Limits      *procfs.ProcLimits
+	Cgroups     []procfs.Cgroup
+	Namespaces  procfs.Namespaces
+	IO          *procfs.ProcIO
+	OOMScore    int
+	OOMScoreAdj int
+	// NUMAMemory is the resident memory on each NUMA node in bytes, from numa_maps
+	NUMAMemory map[int]uint64 `json:",omitempty"`
+	// Unreadable lists the parts of /proc/[pid] that couldn't be read, usually due to permissions
+	Unreadable map[string]string
+}
+
+func readInt(path string) (int, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(content)))
+}
+
+// ReadNUMAMaps sums the pages of the mappings in /proc/[pid]/numa_maps per node, in bytes:
+// 7f2a1c000000 default file=/usr/lib/libc.so.6 mapped=80 mapmax=40 N0=50 N1=30 kernelpagesize_kB=4
+func ReadNUMAMaps(path string) (map[int]uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	memory := make(map[int]uint64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		pages := make(map[int]uint64)
+		var pageSize uint64
+		for _, field := range strings.Fields(scanner.Text()) {
+			k, v, found := strings.Cut(field, "=")
+			if !found {
+				continue
+			}
+			n, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				continue
+			}
+			if k == "kernelpagesize_kB" {
+				pageSize = n * 1024
+			} else if node, err := strconv.Atoi(strings.TrimPrefix(k, "N")); err == nil && strings.HasPrefix(k, "N") {
+				pages[node] += n
+			}
+		}
+		for node, n := range pages {
+			memory[node] += n * pageSize
+		}
+	}
+	return memory, scanner.Err()
+}
+
+// NewPIDProbe reads everything it can about a process from procfs mounted at procfsRoot.
+// Only the process's stat is required, the rest is collected on a best-effort basis.
+func NewPIDProbe(provider ProcProvider, procfsRoot string, pid int) (*PIDProbe, error) {
+	proc, err := provider.Proc(pid)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := proc.Stat()
+	if err != nil {
+		return nil, err
+	}
+	p := &PIDProbe{
+		PID:        pid,
+		Comm:       stat.Comm,
+		Stat:       &stat,
+		Unreadable: make(map[string]string),
+	}
+	unreadable := func(what string, err error) {
+		p.Unreadable[what] = err.Error()
+	}
+	if p.CmdLine, err = proc.CmdLine(); err != nil {
+		unreadable("cmdline", err)
+	}
+	if p.Wchan, err = proc.Wchan(); err != nil {
+		unreadable("wchan", err)
+	}
+	if rollup, err := proc.ProcSMapsRollup(); err != nil {
+		unreadable("smaps_rollup", err)
+	} else {
+		p.SMapsRollup = &rollup
+	}
+	if p.FDs, err = proc.FileDescriptorsLen(); err != nil {
+		unreadable("fd", err)
+	}
+	if limits, err := proc.Limits(); err != nil {
+		unreadable("limits", err)
+	} else {
+		p.Limits = &limits
+	}
+	if p.Cgroups, err = proc.Cgroups(); err != nil {
+		unreadable("cgroup", err)
+	}
+	if p.Namespaces, err = proc.Namespaces(); err != nil {
+		unreadable("ns", err)
+	}
+	if io, err := proc.IO(); err != nil {
+		unreadable("io", err)
+	} else {
+		p.IO = &io
+	}
+	dir := filepath.Join(procfsRoot, strconv.Itoa(pid))
+	if p.OOMScore, err = readInt(filepath.Join(dir, "oom_score")); err != nil {
+		unreadable("oom_score", err)
+	}
+	if p.OOMScoreAdj, err = readInt(filepath.Join(dir, "oom_score_adj")); err != nil {
+		unreadable("oom_score_adj", err)
+	}
+	// kernels without NUMA support have no numa_maps
+	if p.NUMAMemory, err = ReadNUMAMaps(filepath.Join(dir, "numa_maps")); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		unreadable("numa_maps", err)
+	}
+	p.Parents = parentChain(provider, stat.PPID)
+	return p, nil
+}
+
+// parentChain walks up the process tree, starting with the closest parent
+func parentChain(provider ProcProvider, ppid int) (parents []Process) {
+	for ppid > 0 && len(parents) < maxParents {
+		proc, err := provider.Proc(ppid)
+		if err != nil {
+			return
+		}
+		stat, err := proc.Stat()
+		if err != nil {
+			return
+		}
+		parents = append(parents, Process{PID: ppid, Comm: stat.Comm})
+		ppid = stat.PPID
+	}
+	return
+}
+
+// FDUtilization returns the ratio of open file descriptors to RLIMIT_NOFILE
+func (p *PIDProbe) FDUtilization() (float64, bool) {
+	if p.Limits == nil || p.Limits.OpenFiles == 0 || p.Unreadable["fd"] != "" {
+		return 0, false
+	}
+	return float64(p.FDs) / float64(p.Limits.OpenFiles), true
+}
+
+func ticks(t uint) time.Duration {
+	return time.Duration(t) * time.Second / userHZ
+}
+
+func (p *PIDProbe) Display() string {
+	bold := color.New(color.Bold)
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%v PID %v (%v) is %v, %v threads, nice %v\n",
+		emoji.MagnifyingGlassTiltedRight,
+		bold.Sprint(p.PID),
+		bold.Sprint(p.Comm),
+		bold.Sprintf("%s (%s)", p.Stat.State, stateNames[p.Stat.State]),
+		bold.Sprint(p.Stat.NumThreads),
+		p.Stat.Nice,
+	)
+	if len(p.CmdLine) > 0 {
+		fmt.Fprintf(&sb, "Command: %v\n", strings.Join(p.CmdLine, " "))
+	}
+	if p.Wchan != "" && p.Wchan != "0" {
+		fmt.Fprintf(&sb, "Waiting in: %v\n", p.Wchan)
+	}
+	if len(p.Parents) > 0 {
+		chain := make([]string, 0, len(p.Parents))
+		for i := len(p.Parents) - 1; i >= 0; i-- {
+			chain = append(chain, fmt.Sprintf("%d (%s)", p.Parents[i].PID, p.Parents[i].Comm))
+		}
+		fmt.Fprintf(&sb, "Parents: %v\n", strings.Join(chain, " > "))
+	}
+	fmt.Fprintf(&sb, "CPU time: user %v, system %v (children: user %v, system %v)\n",
+		bold.Sprint(ticks(p.Stat.UTime)),
+		bold.Sprint(ticks(p.Stat.STime)),
+		ticks(p.Stat.CUTime),
+		ticks(p.Stat.CSTime),
+	)
+	if p.SMapsRollup != nil {
+		fmt.Fprintf(&sb, "Memory: RSS %v, PSS %v, swap %v (anonymous %v)\n",
+			bold.Sprint(humanize.Bytes(p.SMapsRollup.Rss)),
+			bold.Sprint(humanize.Bytes(p.SMapsRollup.Pss)),
+			bold.Sprint(humanize.Bytes(p.SMapsRollup.Swap)),
+			humanize.Bytes(p.SMapsRollup.Anonymous),
+		)
+	} else {
+		fmt.Fprintf(&sb, "Memory: RSS %v, virtual %v\n",
+			bold.Sprint(humanize.Bytes(uint64(p.Stat.ResidentMemory()))),
+			bold.Sprint(humanize.Bytes(uint64(p.Stat.VirtualMemory()))),
+		)
+	}
+	// a single node has nothing to tell apart
+	if len(p.NUMAMemory) > 1 {
+		nodes := make([]int, 0, len(p.NUMAMemory))
+		for node := range p.NUMAMemory {
+			nodes = append(nodes, node)
+		}
+		sort.Ints(nodes)
+		perNode := make([]string, 0, len(nodes))
+		for _, node := range nodes {
+			perNode = append(perNode, fmt.Sprintf("node %d %v", node, bold.Sprint(humanize.Bytes(p.NUMAMemory[node]))))
+		}
+		fmt.Fprintf(&sb, "Memory per NUMA node: %v\n", strings.Join(perNode, ", "))
+	}
+	if utilization, ok := p.FDUtilization(); ok {
+		fdColor := format.ColorForUtilization(utilization, 0.9, 0.75, 0.5)
+		fmt.Fprintf(&sb, "File descriptors: %v of %v (%v)\n",
+			bold.Sprint(p.FDs),
+			bold.Sprint(p.Limits.OpenFiles),
+			fdColor.Sprintf("%0.2f%%", utilization*100),
+		)
+	}
+	if p.IO != nil {
+		fmt.Fprintf(&sb, "I/O: read %v from disk (%v syscalls), written %v to disk (%v syscalls)\n",
+			bold.Sprint(humanize.Bytes(p.IO.ReadBytes)),
+			p.IO.SyscR,
+			bold.Sprint(humanize.Bytes(p.IO.WriteBytes)),
+			p.IO.SyscW,
+		)
+	}
+	fmt.Fprintf(&sb, "OOM score: %v (adj %v)\n", bold.Sprint(p.OOMScore), p.OOMScoreAdj)
+	for _, cgroup := range p.Cgroups {
+		fmt.Fprintf(&sb, "Cgroup: %d:%s:%s\n", cgroup.HierarchyID, strings.Join(cgroup.Controllers, ","), cgroup.Path)
+	}
+	if len(p.Namespaces) > 0 {
+		names := make([]string, 0, len(p.Namespaces))
+		for name := range p.Namespaces {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		namespaces := make([]string, 0, len(names))
+		for _, name := range names {
+			namespaces = append(namespaces, fmt.Sprintf("%s:[%d]", name, p.Namespaces[name].Inode))
+		}
+		fmt.Fprintf(&sb, "Namespaces: %v\n", strings.Join(namespaces, " "))
+	}
+	return sb.String()
+}
+
+func (p *PIDProbe) Serialize() interface{} {
+	return p
+}
+
+func (p *PIDProbe) Analysis() (observations []*analysis.Observation) {
+	switch p.Stat.State {
+	case "D":
+		observations = append(observations, &analysis.Observation{
+			Type:    analysis.Warning,
+			Message: fmt.Sprintf("Process in D state (uninterruptible sleep), waiting in %q - usually stuck on I/O", p.Wchan),
+		})
+	case "Z":
+		observations = append(observations, &analysis.Observation{
+			Type:    analysis.Warning,
+			Message: "Process is a zombie - its parent hasn't reaped it",
+		})
+	case "T", "t":
+		observations = append(observations, &analysis.Observation{
+			Type:    analysis.Note,
+			Message: "Process is stopped",
+		})
+	}
+	if utilization, ok := p.FDUtilization(); ok && utilization > 0.75 {
+		observationType := analysis.Warning
+		if utilization > 0.9 {
+			observationType = analysis.Issue
+		}
+		observations = append(observations, &analysis.Observation{
+			Type:    observationType,
+			Message: fmt.Sprintf("File descriptor usage at %0.0f%% of limit (%d of %d)", utilization*100, p.FDs, p.Limits.OpenFiles),
+		})
+	}
+	if p.OOMScoreAdj > 500 {
+		observations = append(observations, &analysis.Observation{
+			Type:    analysis.Note,
+			Message: fmt.Sprintf("High oom_score_adj (%d): the OOM killer will pick this process first", p.OOMScoreAdj),
+		})
+	}
+	if p.OOMScoreAdj == -1000 {
+		observations = append(observations, &analysis.Observation{
+			Type:    analysis.Note,
+			Message: "oom_score_adj is -1000: the OOM killer will never pick this process",
+		})
+	}
+	if p.SMapsRollup != nil && p.SMapsRollup.Swap > 0 {
+		observations = append(observations, &analysis.Observation{
+			Type:    analysis.Note,
+			Message: fmt.Sprintf("%s of the process's memory is swapped out", humanize.Bytes(p.SMapsRollup.Swap)),
+		})
+	}
+	if len(p.Unreadable) > 0 {
+		names := make([]string, 0, len(p.Unreadable))
+		for name := range p.Unreadable {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		observations = append(observations, &analysis.Observation{
+			Type:    analysis.Hint,
+			Message: fmt.Sprintf("Could not read %s of PID %d, so some data is missing. Running as root usually helps", strings.Join(names, ", "), p.PID),
+		})
+	}
+	return
+}
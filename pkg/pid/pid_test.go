@@ -0,0 +1,153 @@
+package pid
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/procfs"
+	"github.com/sredog/sre/pkg/analysis"
+)
+
+const (
+	stat = "42 (nginx) S 1 42 42 0 -1 4194304 85 0 0 0 0 0 0 0 20 0 1 0 545171 2703360 327 18446744073709551615 0 0 0 0 0 0 0 0 0 0 0 0 17 0 0 0 0 0 0 0 0 0 0 0 0 0 0\n"
+
+	limits = `Limit                     Soft Limit           Hard Limit           Units
+Max cpu time              unlimited            unlimited            seconds
+Max open files            100                  4096                 files
+`
+
+	// 4 kB pages, and 2 MB huge pages bound to node 1
+	numaMaps = `55d0c0000000 default file=/usr/sbin/nginx mapped=10 N0=6 N1=4 kernelpagesize_kB=4
+7f2a1c000000 default anon=3 dirty=3 N0=3 kernelpagesize_kB=4
+7f2a40000000 bind:1 file=/dev/hugepages/buffers huge dirty=2 N1=2 kernelpagesize_kB=2048
+7ffd00000000 default stack anon=1 dirty=1 active=0 N0=1 kernelpagesize_kB=4
+`
+)
+
+// writeProc builds /proc/42 under a temporary directory, with the files given and fds open file descriptors
+func writeProc(t *testing.T, files map[string]string, fds int) string {
+	t.Helper()
+	root := t.TempDir()
+	dir := filepath.Join(root, "42")
+	if err := os.MkdirAll(filepath.Join(dir, "fd"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for fd := 0; fd < fds; fd++ {
+		if err := os.WriteFile(filepath.Join(dir, "fd", strconv.Itoa(fd)), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestReadNUMAMaps(t *testing.T) {
+	root := writeProc(t, map[string]string{"numa_maps": numaMaps}, 0)
+	memory, err := ReadNUMAMaps(filepath.Join(root, "42", "numa_maps"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[int]uint64{
+		0: (6 + 3 + 1) * 4096,
+		1: 4*4096 + 2*2048*1024,
+	}
+	if !reflect.DeepEqual(memory, expected) {
+		t.Errorf("Expected %v, got %v", expected, memory)
+	}
+}
+
+func TestNewPIDProbe(t *testing.T) {
+	root := writeProc(t, map[string]string{
+		"stat":          stat,
+		"limits":        limits,
+		"numa_maps":     numaMaps,
+		"oom_score":     "666\n",
+		"oom_score_adj": "1000\n",
+	}, 95)
+	fs, err := procfs.NewFS(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewPIDProbe(fs, root, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Comm != "nginx" || p.FDs != 95 || p.Limits == nil || p.Limits.OpenFiles != 100 || p.OOMScoreAdj != 1000 {
+		t.Errorf("Expected nginx with 95 of 100 fds and an oom_score_adj of 1000, got %+v", p)
+	}
+	if p.NUMAMemory[1] != 4*4096+2*2048*1024 {
+		t.Errorf("Expected the huge pages in the memory of node 1, got %v", p.NUMAMemory)
+	}
+	// parent 1 isn't in the fixture
+	if len(p.Parents) != 0 {
+		t.Errorf("Expected no parents, got %v", p.Parents)
+	}
+	var unreadable []string
+	for name := range p.Unreadable {
+		unreadable = append(unreadable, name)
+	}
+	expected := []string{"cgroup", "cmdline", "io", "ns", "smaps_rollup", "wchan"}
+	sort.Strings(unreadable)
+	if !reflect.DeepEqual(unreadable, expected) {
+		t.Errorf("Expected %v to be unreadable, got %v", expected, unreadable)
+	}
+
+	var messages []string
+	types := make(map[analysis.ObservationType]int)
+	for _, o := range p.Analysis() {
+		messages = append(messages, o.Message)
+		types[o.Type]++
+	}
+	all := strings.Join(messages, "\n")
+	for _, substring := range []string{
+		"File descriptor usage at 95% of limit (95 of 100)",
+		"High oom_score_adj (1000)",
+		"Could not read cgroup, cmdline, io, ns, smaps_rollup, wchan of PID 42",
+	} {
+		if !strings.Contains(all, substring) {
+			t.Errorf("Expected %q in the observations:\n%s", substring, all)
+		}
+	}
+	if types[analysis.Issue] != 1 {
+		t.Errorf("Expected the fd usage to be an issue, got %v", types)
+	}
+}
+
+func TestFDUtilization(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		probe      *PIDProbe
+		expected   analysis.ObservationType
+		observed   bool
+		unreadable bool
+	}{
+		{"warning", &PIDProbe{FDs: 80, Limits: &procfs.ProcLimits{OpenFiles: 100}}, analysis.Warning, true, false},
+		{"issue", &PIDProbe{FDs: 91, Limits: &procfs.ProcLimits{OpenFiles: 100}}, analysis.Issue, true, false},
+		{"fine", &PIDProbe{FDs: 10, Limits: &procfs.ProcLimits{OpenFiles: 100}}, 0, false, false},
+		{"no limits", &PIDProbe{FDs: 91}, 0, false, true},
+		{"fd unreadable", &PIDProbe{Limits: &procfs.ProcLimits{OpenFiles: 100}, Unreadable: map[string]string{"fd": "permission denied"}}, 0, false, true},
+	} {
+		tc.probe.Stat = &procfs.ProcStat{State: "S"}
+		if _, ok := tc.probe.FDUtilization(); ok == tc.unreadable {
+			t.Errorf("%s: expected the utilization to be known: %v", tc.name, !tc.unreadable)
+		}
+		var found *analysis.Observation
+		for _, o := range tc.probe.Analysis() {
+			if strings.HasPrefix(o.Message, "File descriptor usage") {
+				found = o
+			}
+		}
+		if (found != nil) != tc.observed || (found != nil && found.Type != tc.expected) {
+			t.Errorf("%s: expected a %v observation: %v, got %+v", tc.name, tc.expected, tc.observed, found)
+		}
+	}
+}
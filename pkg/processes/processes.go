@@ -1,24 +1,28 @@
 package processes
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strconv"
+	"time"
 
 	"github.com/enescakir/emoji"
 	"github.com/fatih/color"
 	"github.com/prometheus/procfs"
 	"github.com/sredog/sre/pkg/analysis"
+	"github.com/sredog/sre/pkg/cpu"
 	"github.com/sredog/sre/pkg/format"
 )
 
-const PIDMaxPath = "/proc/sys/kernel/pid_max"
-const ProcPath = "/proc"
+// PIDMaxPath is relative to the procfs root
+const PIDMaxPath = "sys/kernel/pid_max"
 
-// ReadPIDMax returns the value of pid_max on the system
-func ReadPIDMax() (uint64, error) {
-	content, err := ioutil.ReadFile(PIDMaxPath)
+// ReadPIDMax returns the value of pid_max on the system with procfs mounted at procfsRoot
+func ReadPIDMax(procfsRoot string) (uint64, error) {
+	content, err := ioutil.ReadFile(filepath.Join(procfsRoot, PIDMaxPath))
 	if err != nil {
 		return 0, err
 	}
@@ -35,16 +39,17 @@ func ReadPIDMax() (uint64, error) {
 	return limit, nil
 }
 
-func CountProcesses() (uint64, error) {
-	fd, err := os.Open(ProcPath)
+// CountProcesses counts the PID directories in procfs mounted at procfsRoot
+func CountProcesses(procfsRoot string) (uint64, error) {
+	fd, err := os.Open(procfsRoot)
 	if err != nil {
-		return 0, nil
+		return 0, err
 	}
 	defer fd.Close()
 	var count uint64
 	names, err := fd.Readdirnames(0)
 	if err != nil {
-		return 0, nil
+		return 0, err
 	}
 	for _, name := range names {
 		if _, err := strconv.ParseInt(name, 10, 64); err == nil {
@@ -54,42 +59,70 @@ func CountProcesses() (uint64, error) {
 	return count, nil
 }
 
-type StatProvider interface {
-	Stat() (procfs.Stat, error)
-}
-
 type ProcessesProbe struct {
 	Stat       *procfs.Stat
+	Sample     *cpu.Sample
 	TotalProcs uint64
 	PIDMax     uint64
 }
 
-// NewProcessesProbe provides insights into the number of processes
-func NewProcessesProbe(provider StatProvider) (*ProcessesProbe, error) {
-	s, err := provider.Stat()
+// NewProcessesProbe provides insights into the number of processes, and how many are
+// forked per second
+func NewProcessesProbe(ctx context.Context, provider cpu.StatProvider, procfsRoot string, interval time.Duration) (*ProcessesProbe, error) {
+	s, err := cpu.TakeSample(ctx, provider, procfsRoot, interval)
 	if err != nil {
 		return nil, err
 	}
-	limit, err := ReadPIDMax()
+	return NewProcessesProbeFromSample(s, procfsRoot)
+}
+
+// NewProcessesProbeFromSample counts the processes against pid_max, and takes the fork and
+// context switch rates from the sample of /proc/stat
+func NewProcessesProbeFromSample(s *cpu.Sample, procfsRoot string) (*ProcessesProbe, error) {
+	limit, err := ReadPIDMax(procfsRoot)
 	if err != nil {
 		return nil, err
 	}
-	total, err := CountProcesses()
+	total, err := CountProcesses(procfsRoot)
 	if err != nil {
 		return nil, err
 	}
 	u := &ProcessesProbe{
-		Stat:       &s,
+		Stat:       s.After,
+		Sample:     s,
 		TotalProcs: total,
 		PIDMax:     limit,
 	}
 	return u, nil
 }
 
+func init() {
+	analysis.Register(&analysis.ProbeConfiguration{
+		ID:          "processes",
+		Aliases:     []string{"procs"},
+		Description: "Number of processes against pid_max, running and blocked, fork and context switch rates",
+		Build: func(ctx context.Context, env *analysis.Environment) (analysis.Probe, error) {
+			s, err := cpu.SharedSample(ctx, env)
+			if err != nil {
+				return nil, err
+			}
+			return NewProcessesProbeFromSample(s, env.Roots.Proc)
+		},
+	})
+}
+
 const displayFormat = `%v Total processes: %v (%v utilization)
 %v running, %v blocked, %v max pid
+%v forks/s, %v context switches/s %v
 `
 
+func (p *ProcessesProbe) period() string {
+	if p.Sample.SinceBoot() {
+		return "since boot"
+	}
+	return fmt.Sprintf("over %v", p.Sample.Interval)
+}
+
 func (p *ProcessesProbe) Display() string {
 	bold := color.New(color.Bold)
 	var utilization float64 = float64(p.TotalProcs) / float64(p.PIDMax)
@@ -101,9 +134,37 @@ func (p *ProcessesProbe) Display() string {
 		bold.Sprintf("%d", p.Stat.ProcessesRunning),
 		bold.Sprintf("%d", p.Stat.ProcessesBlocked),
 		bold.Sprintf("%d", p.PIDMax),
+		bold.Sprintf("%0.1f", p.Sample.ForkRate()),
+		bold.Sprintf("%0.0f", p.Sample.ContextSwitchRate()),
+		p.period(),
 	)
 }
 
+func (p *ProcessesProbe) Serialize() interface{} {
+	return struct {
+		TotalProcs        uint64
+		PIDMax            uint64
+		ProcessesRunning  uint64
+		ProcessesBlocked  uint64
+		IntervalSeconds   float64
+		ForkRate          float64
+		ContextSwitchRate float64
+	}{
+		TotalProcs:        p.TotalProcs,
+		PIDMax:            p.PIDMax,
+		ProcessesRunning:  p.Stat.ProcessesRunning,
+		ProcessesBlocked:  p.Stat.ProcessesBlocked,
+		IntervalSeconds:   p.Sample.Interval.Seconds(),
+		ForkRate:          p.Sample.ForkRate(),
+		ContextSwitchRate: p.Sample.ContextSwitchRate(),
+	}
+}
+
+// SinceBoot tells whether the fork and context switch rates are averaged since boot
+func (p *ProcessesProbe) SinceBoot() bool {
+	return p.Sample.SinceBoot()
+}
+
 func (p *ProcessesProbe) Analysis() (observations []*analysis.Observation) {
 	var utilization float64 = float64(p.TotalProcs) / float64(p.PIDMax)
 	if utilization > 0.75 {
@@ -112,5 +173,20 @@ func (p *ProcessesProbe) Analysis() (observations []*analysis.Observation) {
 			Message: "You're running out of PIDs - check for zombies",
 		})
 	}
+	if forks := p.Sample.ForkRate(); forks > 1000 {
+		observations = append(observations, &analysis.Observation{
+			Type:    analysis.Warning,
+			Message: fmt.Sprintf("%0.0f processes created per second %s - short-lived processes burn CPU without showing up in top", forks, p.period()),
+			See:     "execsnoop",
+		})
+	}
+	cpus := float64(len(p.Stat.CPU))
+	if switches := p.Sample.ContextSwitchRate(); cpus > 0 && switches/cpus > 100000 {
+		observations = append(observations, &analysis.Observation{
+			Type:    analysis.Warning,
+			Message: fmt.Sprintf("%0.0f context switches per second per CPU %s - lock contention or too many threads", switches/cpus, p.period()),
+			See:     "pidstat",
+		})
+	}
 	return
 }
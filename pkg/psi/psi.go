@@ -0,0 +1,161 @@
+// Package psi reads the Pressure Stall Information: the share of time tasks were
+// stalled waiting on CPU, memory or I/O
+// See https://www.kernel.org/doc/html/latest/accounting/psi.html
+package psi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/enescakir/emoji"
+	"github.com/fatih/color"
+	"github.com/prometheus/procfs"
+	"github.com/sredog/sre/pkg/analysis"
+	"github.com/sredog/sre/pkg/format"
+)
+
+// Resources PSI is reported for
+var Resources = []string{"cpu", "memory", "io"}
+
+type PSIProvider interface {
+	PSIStatsForResource(resource string) (procfs.PSIStats, error)
+}
+
+// Resource holds the pressure on a single resource. Some is the share of time at least
+// one task was stalled on it, Full the share of time all non-idle tasks were.
+type Resource struct {
+	Name string
+	Some *procfs.PSILine
+	// Full is nil for cpu before Linux 5.13
+	Full *procfs.PSILine
+}
+
+type PSIProbe struct {
+	Resources []*Resource
+	// Unavailable explains why the kernel doesn't report PSI
+	Unavailable string `json:",omitempty"`
+}
+
+// NewPSIProbe reads the pressure of every resource. Kernels without PSI give an
+// empty probe saying so instead of an error.
+func NewPSIProbe(provider PSIProvider) (*PSIProbe, error) {
+	p := &PSIProbe{}
+	for _, name := range Resources {
+		stats, err := provider.PSIStatsForResource(name)
+		if errors.Is(err, os.ErrNotExist) {
+			p.Unavailable = "PSI needs Linux 4.20 or newer built with CONFIG_PSI, and psi=1 on the kernel command line when CONFIG_PSI_DEFAULT_DISABLED is set"
+			return p, nil
+		}
+		// the pressure files can't be read with psi=0 on the kernel command line
+		if errors.Is(err, syscall.EOPNOTSUPP) {
+			p.Unavailable = fmt.Sprintf("PSI is disabled: %v", err)
+			return p, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		p.Resources = append(p.Resources, &Resource{
+			Name: name,
+			Some: stats.Some,
+			Full: stats.Full,
+		})
+	}
+	return p, nil
+}
+
+func init() {
+	analysis.Register(&analysis.ProbeConfiguration{
+		ID:          "psi",
+		Aliases:     []string{"pressure"},
+		Description: "Pressure Stall Information: time stalled waiting on CPU, memory and I/O",
+		Build: func(ctx context.Context, env *analysis.Environment) (analysis.Probe, error) {
+			fs, err := env.Roots.ProcFS()
+			if err != nil {
+				return nil, err
+			}
+			return NewPSIProbe(fs)
+		},
+	})
+}
+
+const displayFormat = "%v Pressure stalls (avg10 / avg60 / avg300)\n"
+
+func line(l *procfs.PSILine) string {
+	if l == nil {
+		return "-"
+	}
+	colored := func(avg float64) string {
+		return format.ColorForUtilization(avg/100, 0.25, 0.1, 0.01).Sprintf("%0.2f%%", avg)
+	}
+	return fmt.Sprintf("%v / %v / %v (total %v)",
+		colored(l.Avg10), colored(l.Avg60), colored(l.Avg300), time.Duration(l.Total)*time.Microsecond)
+}
+
+func (p *PSIProbe) Display() string {
+	bold := color.New(color.Bold)
+	var sb strings.Builder
+	fmt.Fprintf(&sb, displayFormat, emoji.HourglassNotDone)
+	if p.Unavailable != "" {
+		fmt.Fprintln(&sb, "Not available")
+		return sb.String()
+	}
+	for _, r := range p.Resources {
+		fmt.Fprintf(&sb, "%-7s some: %v\n", bold.Sprint(r.Name), line(r.Some))
+		fmt.Fprintf(&sb, "%-7s full: %v\n", "", line(r.Full))
+	}
+	return sb.String()
+}
+
+func (p *PSIProbe) Serialize() interface{} {
+	return p
+}
+
+// pressureThresholds are the avg60 and avg300 percentages of sustained pressure
+var pressureThresholds = map[analysis.ObservationType][2]float64{
+	analysis.Issue:   {25, 10},
+	analysis.Warning: {10, 5},
+}
+
+// sustained rates pressure that lasted for the past minutes, not just a spike
+func sustained(l *procfs.PSILine) (analysis.ObservationType, bool) {
+	if l == nil {
+		return 0, false
+	}
+	for _, t := range []analysis.ObservationType{analysis.Issue, analysis.Warning} {
+		thresholds := pressureThresholds[t]
+		if l.Avg60 >= thresholds[0] && l.Avg300 >= thresholds[1] {
+			return t, true
+		}
+	}
+	return 0, false
+}
+
+func (p *PSIProbe) Analysis() (observations []*analysis.Observation) {
+	if p.Unavailable != "" {
+		observations = append(observations, &analysis.Observation{
+			Type:    analysis.Note,
+			Message: fmt.Sprintf("Pressure Stall Information isn't available: %s", p.Unavailable),
+		})
+		return
+	}
+	for _, r := range p.Resources {
+		// at the system level all tasks can't be stalled on CPU, some is what tells
+		stall, kind := r.Full, "all non-idle tasks were"
+		if r.Name == "cpu" {
+			stall, kind = r.Some, "some tasks were"
+		}
+		if t, ok := sustained(stall); ok {
+			observations = append(observations, &analysis.Observation{
+				Type: t,
+				Message: fmt.Sprintf("For %0.1f%% of the last minute (%0.1f%% of the last 5) %s stalled waiting on %s",
+					stall.Avg60, stall.Avg300, kind, r.Name),
+			})
+		}
+	}
+	return
+}
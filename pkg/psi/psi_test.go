@@ -0,0 +1,50 @@
+package psi
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/prometheus/procfs"
+	"github.com/sredog/sre/pkg/analysis"
+)
+
+type stubProvider map[string]procfs.PSIStats
+
+func (s stubProvider) PSIStatsForResource(resource string) (procfs.PSIStats, error) {
+	stats, ok := s[resource]
+	if !ok {
+		return procfs.PSIStats{}, fmt.Errorf("psi_stats: unavailable for %q: %w", resource, os.ErrNotExist)
+	}
+	return stats, nil
+}
+
+func TestUnavailable(t *testing.T) {
+	p, err := NewPSIProbe(stubProvider{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	observations := p.Analysis()
+	if p.Unavailable == "" || len(observations) != 1 || observations[0].Type != analysis.Note {
+		t.Errorf("Expected a note about PSI being unavailable, got %+v", observations)
+	}
+}
+
+func TestSustainedPressure(t *testing.T) {
+	calm := &procfs.PSILine{Avg10: 50, Avg60: 1, Avg300: 1}
+	p, err := NewPSIProbe(stubProvider{
+		"cpu":    {Some: calm, Full: calm},
+		"memory": {Some: &procfs.PSILine{Avg60: 40, Avg300: 30}, Full: &procfs.PSILine{Avg60: 30, Avg300: 12}},
+		"io":     {Some: &procfs.PSILine{Avg60: 20, Avg300: 10}, Full: &procfs.PSILine{Avg60: 12, Avg300: 6}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	observations := p.Analysis()
+	if len(observations) != 2 {
+		t.Fatalf("Expected memory and io pressure, got %d observations", len(observations))
+	}
+	if observations[0].Type != analysis.Issue || observations[1].Type != analysis.Warning {
+		t.Errorf("Expected an issue for memory and a warning for io, got %v and %v", observations[0].Type, observations[1].Type)
+	}
+}
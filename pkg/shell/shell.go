@@ -0,0 +1,392 @@
+// Package shell is an interactive prompt running probes by their ID, and everything
+// else in a sub-shell, with the output analysed like `sre analysis` would.
+package shell
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/sredog/sre/pkg/toolparse"
+	"golang.org/x/term"
+)
+
+// Command is run by the shell itself instead of a sub-shell
+type Command struct {
+	ID          string
+	Aliases     []string
+	Description string
+	// Complete offers completions for an argument, optional
+	Complete func(prefix string) []string
+	Run      func(ctx context.Context, w io.Writer, args []string) error
+}
+
+type Shell struct {
+	Prompt   string
+	Commands []*Command
+	// Exec runs the lines that aren't commands, writing their output to w, SubShell by default
+	Exec func(ctx context.Context, w io.Writer, line string) error
+	// HistoryFile keeps the history between sessions, optional
+	HistoryFile string
+	History     []string
+}
+
+// SubShell runs the line with /bin/sh, writing its stdout and stderr to w as they come
+func SubShell(ctx context.Context, w io.Writer, line string) error {
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", line)
+	cmd.Stdout = w
+	cmd.Stderr = w
+	return cmd.Run()
+}
+
+var builtins = []*Command{
+	{ID: "help", Description: "List the commands"},
+	{ID: "history", Description: "Show the history"},
+	{ID: "exit", Aliases: []string{"quit"}, Description: "Leave the shell"},
+}
+
+func (s *Shell) find(name string) *Command {
+	for _, c := range append(builtins, s.Commands...) {
+		if c.ID == name {
+			return c
+		}
+		for _, alias := range c.Aliases {
+			if alias == name {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Shell) loadHistory() {
+	if s.HistoryFile == "" {
+		return
+	}
+	content, err := ioutil.ReadFile(s.HistoryFile)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		if line != "" {
+			s.History = append(s.History, line)
+		}
+	}
+}
+
+func (s *Shell) addHistory(line string) {
+	s.History = append(s.History, line)
+	if s.HistoryFile == "" {
+		return
+	}
+	f, err := os.OpenFile(s.HistoryFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintln(f, line)
+}
+
+// Run reads lines from in until it's exhausted, the context is done or the user exits.
+// A terminal gets line editing, history and tab completion, anything else is read as a script.
+func (s *Shell) Run(ctx context.Context, in io.Reader, out io.Writer) error {
+	s.loadHistory()
+	if f, ok := in.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		return s.interactive(ctx, f, out)
+	}
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		exit, err := s.Execute(ctx, out, scanner.Text())
+		if err != nil {
+			fmt.Fprintf(out, "%v\n", err)
+		}
+		if exit {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+func (s *Shell) interactive(ctx context.Context, in *os.File, out io.Writer) error {
+	state, err := term.MakeRaw(int(in.Fd()))
+	if err != nil {
+		return err
+	}
+	defer term.Restore(int(in.Fd()), state)
+	keys := &interruptReader{Reader: in}
+	t, err := s.newTerminal(keys, out)
+	if err != nil {
+		return err
+	}
+	for ctx.Err() == nil {
+		line, err := t.ReadLine()
+		if err == io.EOF && keys.interrupted {
+			// Ctrl-C drops the line being edited instead of leaving, like other shells
+			keys.interrupted = false
+			fmt.Fprint(out, "^C\r\n")
+			if t, err = s.newTerminal(keys, out); err != nil {
+				return err
+			}
+			continue
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// the commands get a regular terminal, and the terminal writer turns \n into \r\n
+		term.Restore(int(in.Fd()), state)
+		exit, err := s.Execute(ctx, t, line)
+		if err != nil {
+			fmt.Fprintf(t, "%v\n", err)
+		}
+		if _, rawErr := term.MakeRaw(int(in.Fd())); rawErr != nil {
+			return rawErr
+		}
+		if exit {
+			return nil
+		}
+	}
+	return ctx.Err()
+}
+
+// interruptReader notes the Ctrl-C typed at the prompt, which the terminal reports
+// as io.EOF just like Ctrl-D
+type interruptReader struct {
+	io.Reader
+	interrupted bool
+}
+
+func (r *interruptReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if bytes.IndexByte(p[:n], ctrlC) >= 0 {
+		r.interrupted = true
+	}
+	return n, err
+}
+
+const ctrlC = 3
+
+// discardWriter drops the writes until Writer is set
+type discardWriter struct {
+	io.Writer
+}
+
+func (w *discardWriter) Write(p []byte) (int, error) {
+	if w.Writer == nil {
+		return len(p), nil
+	}
+	return w.Writer.Write(p)
+}
+
+// newTerminal starts a line editor with the history. The terminal has no way to seed it,
+// so the lines are typed into it before it's handed the input.
+func (s *Shell) newTerminal(in io.Reader, out io.Writer) (*term.Terminal, error) {
+	history := s.History
+	if len(history) > maxTerminalHistory {
+		history = history[len(history)-maxTerminalHistory:]
+	}
+	var replay strings.Builder
+	for _, line := range history {
+		fmt.Fprintf(&replay, "%s\r", line)
+	}
+	w := &discardWriter{}
+	t := term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{io.MultiReader(strings.NewReader(replay.String()), in), w}, s.Prompt)
+	for range history {
+		if _, err := t.ReadLine(); err != nil {
+			return nil, err
+		}
+	}
+	w.Writer = out
+	t.AutoCompleteCallback = s.autoComplete
+	return t, nil
+}
+
+// maxTerminalHistory is how many lines the terminal keeps to go through with the arrow keys
+const maxTerminalHistory = 100
+
+// interruptible returns a context cancelled by an interrupt, e.g. Ctrl-C, so that it stops
+// the line being run instead of the shell, until stop is called
+func interruptible(ctx context.Context) (_ context.Context, stop func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		select {
+		case <-interrupts:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(interrupts)
+		cancel()
+	}
+}
+
+// Execute runs a single line, and tells whether the shell should exit. An interrupt stops
+// the line, the shell carries on with the next one.
+func (s *Shell) Execute(ctx context.Context, w io.Writer, line string) (bool, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return false, nil
+	}
+	s.addHistory(line)
+	fields := strings.Fields(line)
+	command := s.find(fields[0])
+	ctx, stop := interruptible(ctx)
+	defer stop()
+	switch {
+	case command == nil:
+		return false, s.exec(ctx, w, line)
+	case command.ID == "exit":
+		return true, nil
+	case command.ID == "help":
+		s.help(w)
+		return false, nil
+	case command.ID == "history":
+		for i, entry := range s.History {
+			fmt.Fprintf(w, "%5d  %s\n", i+1, entry)
+		}
+		return false, nil
+	}
+	return false, command.Run(ctx, w, fields[1:])
+}
+
+func (s *Shell) help(w io.Writer) {
+	bold := color.New(color.Bold)
+	for _, c := range append(builtins, s.Commands...) {
+		names := append([]string{c.ID}, c.Aliases...)
+		fmt.Fprintf(w, "%s\t%s\n", bold.Sprint(strings.Join(names, ", ")), c.Description)
+	}
+	fmt.Fprintln(w, "Everything else runs in a sub-shell, and the output of tools `sre analysis` knows gets analysed.")
+}
+
+// exec runs the line in a sub-shell, and analyses the output if it comes from a known tool.
+// The output is shown as it comes, with a copy kept for the analysis.
+func (s *Shell) exec(ctx context.Context, w io.Writer, line string) error {
+	run := s.Exec
+	if run == nil {
+		run = SubShell
+	}
+	var output bytes.Buffer
+	if err := run(ctx, io.MultiWriter(w, &output), line); err != nil {
+		return err
+	}
+	lines, err := toolparse.ReadLines(&output)
+	if err != nil {
+		return err
+	}
+	parser, err := toolparse.Detect(lines)
+	if err != nil {
+		// not a tool we know
+		return nil
+	}
+	probe, err := parser.Parse(lines)
+	if err != nil {
+		return err
+	}
+	for _, observation := range probe.Analysis() {
+		fmt.Fprintf(w, "%s\n", observation.Format())
+	}
+	return nil
+}
+
+// Complete returns the candidates for the last word of the line
+func (s *Shell) Complete(line string) []string {
+	fields := strings.Fields(line)
+	prefix := ""
+	if len(fields) > 0 && !strings.HasSuffix(line, " ") {
+		prefix = fields[len(fields)-1]
+		fields = fields[:len(fields)-1]
+	}
+	var candidates []string
+	switch {
+	case strings.HasPrefix(prefix, "/"):
+		// e.g. cgroup paths like /sys/fs/cgroup/kubepods.slice/
+		candidates = completePath(prefix)
+	case len(fields) == 0:
+		for _, c := range append(builtins, s.Commands...) {
+			for _, name := range append([]string{c.ID}, c.Aliases...) {
+				if strings.HasPrefix(name, prefix) {
+					candidates = append(candidates, name)
+				}
+			}
+		}
+	default:
+		if c := s.find(fields[0]); c != nil && c.Complete != nil {
+			for _, candidate := range c.Complete(prefix) {
+				if strings.HasPrefix(candidate, prefix) {
+					candidates = append(candidates, candidate)
+				}
+			}
+		}
+	}
+	sort.Strings(candidates)
+	return candidates
+}
+
+func completePath(prefix string) (candidates []string) {
+	dir, base := filepath.Split(prefix)
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name(), base) {
+			continue
+		}
+		candidate := dir + e.Name()
+		if e.IsDir() {
+			candidate += "/"
+		}
+		candidates = append(candidates, candidate)
+	}
+	return
+}
+
+func commonPrefix(candidates []string) string {
+	prefix := candidates[0]
+	for _, c := range candidates[1:] {
+		for !strings.HasPrefix(c, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
+
+// autoComplete extends the word under the cursor on tab, as far as the candidates agree
+func (s *Shell) autoComplete(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' {
+		return "", 0, false
+	}
+	head, tail := line[:pos], line[pos:]
+	candidates := s.Complete(head)
+	if len(candidates) == 0 {
+		return "", 0, false
+	}
+	start := strings.LastIndexAny(head, " \t") + 1
+	completion := commonPrefix(candidates)
+	if len(candidates) == 1 && !strings.HasSuffix(completion, "/") {
+		completion += " "
+	}
+	head = head[:start] + completion
+	return head + tail, len(head), true
+}
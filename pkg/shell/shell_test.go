@@ -0,0 +1,160 @@
+package shell
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const vmstatOutput = `procs -----------memory---------- ---swap-- -----io---- -system-- ------cpu-----
+ r  b   swpd   free   buff  cache   si   so    bi    bo   in   cs us sy id wa st
+ 1  0      0 123456  12345 234567    0    0     1     2    3    4  1  1 98  0  0
+ 9  3      0 123456  12345 234567   50   80     1     2    3    4 60 38  2  0  0
+`
+
+func testShell(t *testing.T) (*Shell, *[]string) {
+	t.Helper()
+	var ran []string
+	s := &Shell{
+		Commands: []*Command{{
+			ID:      "uptime",
+			Aliases: []string{"up"},
+			Run: func(ctx context.Context, w io.Writer, args []string) error {
+				ran = append(ran, "uptime "+strings.Join(args, " "))
+				fmt.Fprintln(w, "probed")
+				return nil
+			},
+		}, {
+			ID:       "pid",
+			Complete: func(prefix string) []string { return []string{"1", "12", "2"} },
+			Run: func(ctx context.Context, w io.Writer, args []string) error {
+				return fmt.Errorf("no such process")
+			},
+		}},
+		Exec: func(ctx context.Context, w io.Writer, line string) error {
+			ran = append(ran, "exec "+line)
+			switch line {
+			case "vmstat 1 2":
+				fmt.Fprint(w, vmstatOutput)
+			case "sleep infinity":
+				// interrupted like Ctrl-C would
+				fmt.Fprintln(w, "sleeping")
+				p, err := os.FindProcess(os.Getpid())
+				if err != nil {
+					return err
+				}
+				if err := p.Signal(os.Interrupt); err != nil {
+					return err
+				}
+				<-ctx.Done()
+				return ctx.Err()
+			default:
+				fmt.Fprintln(w, "hello")
+			}
+			return nil
+		},
+		HistoryFile: filepath.Join(t.TempDir(), "history"),
+	}
+	return s, &ran
+}
+
+func TestScript(t *testing.T) {
+	s, ran := testShell(t)
+	var out strings.Builder
+	script := "up\n# a comment\n\npid 1\necho hello\nvmstat 1 2\nexit\nuptime\n"
+	if err := s.Run(context.Background(), strings.NewReader(script), &out); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"uptime ", "exec echo hello", "exec vmstat 1 2"}
+	if !reflect.DeepEqual(*ran, expected) {
+		t.Fatalf("Expected %v to run, got %v", expected, *ran)
+	}
+	for _, substring := range []string{"probed", "no such process", "hello", "The system is swapping"} {
+		if !strings.Contains(out.String(), substring) {
+			t.Errorf("Expected %q in the output:\n%s", substring, out.String())
+		}
+	}
+
+	// the history is kept for the next session
+	next, _ := testShell(t)
+	next.HistoryFile = s.HistoryFile
+	out.Reset()
+	if err := next.Run(context.Background(), strings.NewReader("history\n"), &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "vmstat 1 2") {
+		t.Errorf("Expected the previous session in the history:\n%s", out.String())
+	}
+}
+
+func TestInterrupt(t *testing.T) {
+	s, ran := testShell(t)
+	var out strings.Builder
+	if err := s.Run(context.Background(), strings.NewReader("sleep infinity\nup\n"), &out); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"exec sleep infinity", "uptime "}
+	if !reflect.DeepEqual(*ran, expected) {
+		t.Fatalf("Expected %v to run, got %v", expected, *ran)
+	}
+	for _, substring := range []string{"sleeping", context.Canceled.Error(), "probed"} {
+		if !strings.Contains(out.String(), substring) {
+			t.Errorf("Expected %q in the output:\n%s", substring, out.String())
+		}
+	}
+}
+
+func TestTerminalHistory(t *testing.T) {
+	s, _ := testShell(t)
+	s.History = []string{"up", "vmstat 1 2"}
+	s.Prompt = "sre> "
+	var out strings.Builder
+	// arrow up twice, then enter
+	term, err := s.newTerminal(strings.NewReader("\x1b[A\x1b[A\r"), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, err := term.ReadLine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "up" {
+		t.Errorf("Expected the history to be seeded, got %q", line)
+	}
+	if n := strings.Count(out.String(), s.Prompt); n != 1 {
+		t.Errorf("Expected the seeding to stay off the output, got %d prompts in %q", n, out.String())
+	}
+}
+
+func TestComplete(t *testing.T) {
+	s, _ := testShell(t)
+	dir := t.TempDir()
+	for line, expected := range map[string][]string{
+		"u":           {"up", "uptime"},
+		"upt":         {"uptime"},
+		"up":          {"up", "uptime"},
+		"pid 1":       {"1", "12"},
+		"pid ":        {"1", "12", "2"},
+		"uptime ":     nil,
+		"cat " + dir:  {dir + "/"},
+		"nosuchcmd x": nil,
+	} {
+		if got := s.Complete(line); !reflect.DeepEqual(got, expected) {
+			t.Errorf("Expected %q to complete to %v, got %v", line, expected, got)
+		}
+	}
+
+	line, pos, ok := s.autoComplete("pid 1", 5, '\t')
+	if !ok || line != "pid 1" || pos != 5 {
+		t.Errorf("Expected the common prefix to stay, got %q %d %v", line, pos, ok)
+	}
+	line, pos, ok = s.autoComplete("upt", 3, '\t')
+	if !ok || line != "uptime " || pos != 7 {
+		t.Errorf("Expected uptime to be completed, got %q %d %v", line, pos, ok)
+	}
+}
@@ -0,0 +1,212 @@
+// Package slab ranks the kernel's slab caches by size, to tell which one a large
+// Slab in /proc/meminfo is made of
+// See https://man7.org/linux/man-pages/man5/slabinfo.5.html
+// sudo slabtop -o -s c
+package slab
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+	"github.com/enescakir/emoji"
+	"github.com/fatih/color"
+	"github.com/prometheus/procfs"
+	"github.com/sredog/sre/pkg/analysis"
+)
+
+type SlabInfoProvider interface {
+	SlabInfo() (procfs.SlabInfo, error)
+}
+
+// Suspect is a cache known to grow large, with what it holds and where to look next
+type Suspect struct {
+	RE  *regexp.Regexp
+	Why string
+}
+
+// suspects are the usual culprits of a large slab
+var suspects = []*Suspect{
+	{
+		RE:  regexp.MustCompile(`^dentry$`),
+		Why: "directory entries, bloated by lookups of missing files (negative dentries) or huge directory trees, reclaimed under pressure",
+	},
+	{
+		RE:  regexp.MustCompile(`inode_cache$|^xfs_inode$`),
+		Why: "inodes of cached files, held as long as their dentries",
+	},
+	{
+		RE:  regexp.MustCompile(`^(dma-)?kmalloc-`),
+		Why: "generic kernel allocations, steady growth is a leak in a driver or module",
+	},
+	{
+		RE:  regexp.MustCompile(`^nf_conntrack`),
+		Why: "connection tracking entries, one per flow through netfilter, bounded by nf_conntrack_max",
+	},
+	{
+		RE:  regexp.MustCompile(`^sock_inode_cache$|^(TCP|UDP|RAW)(v6)?$`),
+		Why: "sockets, connections piling up or leaked by a process show in ss",
+	},
+}
+
+// Cache is a slab cache with its size in bytes
+type Cache struct {
+	Name          string
+	Objects       int64
+	ActiveObjects int64
+	ObjectSize    int64
+	Size          uint64
+	// Suspect says what the cache holds, when it's a usual suspect
+	Suspect string `json:",omitempty"`
+}
+
+// ActiveShare returns the share of the objects in use, the rest being free slots in the slabs
+func (c *Cache) ActiveShare() float64 {
+	if c.Objects == 0 {
+		return 0
+	}
+	return float64(c.ActiveObjects) / float64(c.Objects)
+}
+
+type SlabProbe struct {
+	// Caches ordered by size, the largest first
+	Caches []*Cache
+	// Total is the size of all the caches in bytes
+	Total uint64
+	// Top limits how many caches are displayed
+	Top int
+	// Unavailable explains why /proc/slabinfo couldn't be read
+	Unavailable string `json:",omitempty"`
+}
+
+// NewSlabProbe ranks the caches of /proc/slabinfo by size. It's only readable by root,
+// the probe says so instead of failing.
+func NewSlabProbe(provider SlabInfoProvider, top int) (*SlabProbe, error) {
+	p := &SlabProbe{Top: top}
+	info, err := provider.SlabInfo()
+	if errors.Is(err, fs.ErrPermission) {
+		p.Unavailable = "/proc/slabinfo is only readable by root, running as root helps"
+		return p, nil
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		p.Unavailable = "the kernel has no /proc/slabinfo, it may be built with SLOB"
+		return p, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	pageSize := uint64(os.Getpagesize())
+	for _, s := range info.Slabs {
+		c := &Cache{
+			Name:          s.Name,
+			Objects:       s.ObjNum,
+			ActiveObjects: s.ObjActive,
+			ObjectSize:    s.ObjSize,
+			Size:          uint64(s.SlabNum) * uint64(s.PagesPerSlab) * pageSize,
+		}
+		for _, suspect := range suspects {
+			if suspect.RE.MatchString(c.Name) {
+				c.Suspect = suspect.Why
+				break
+			}
+		}
+		p.Caches = append(p.Caches, c)
+		p.Total += c.Size
+	}
+	sort.SliceStable(p.Caches, func(i, j int) bool { return p.Caches[i].Size > p.Caches[j].Size })
+	return p, nil
+}
+
+func init() {
+	analysis.Register(&analysis.ProbeConfiguration{
+		ID:          "slab",
+		Aliases:     []string{"slabinfo", "slabtop"},
+		Description: "The largest kernel slab caches from /proc/slabinfo, readable by root",
+		Build: func(ctx context.Context, env *analysis.Environment) (analysis.Probe, error) {
+			fs, err := env.Roots.ProcFS()
+			if err != nil {
+				return nil, err
+			}
+			return NewSlabProbe(fs, 10)
+		},
+	})
+}
+
+// TopCaches returns the n largest caches
+func (p *SlabProbe) TopCaches(n int) []*Cache {
+	if n > len(p.Caches) {
+		n = len(p.Caches)
+	}
+	return p.Caches[:n]
+}
+
+// Summary names the n largest caches with their size, e.g. for the observations of the memory probe
+func (p *SlabProbe) Summary(n int) string {
+	names := make([]string, 0, n)
+	for _, c := range p.TopCaches(n) {
+		names = append(names, fmt.Sprintf("%s %s", c.Name, humanize.Bytes(c.Size)))
+	}
+	return strings.Join(names, ", ")
+}
+
+func (p *SlabProbe) Display() string {
+	bold := color.New(color.Bold)
+	var sb strings.Builder
+	if p.Unavailable != "" {
+		fmt.Fprintf(&sb, "%v Slab caches: not available\n", emoji.Brick)
+		return sb.String()
+	}
+	fmt.Fprintf(&sb, "%v Slab caches: %v in %v caches\n",
+		emoji.Brick,
+		bold.Sprint(humanize.Bytes(p.Total)),
+		bold.Sprint(len(p.Caches)),
+	)
+	for _, c := range p.TopCaches(p.Top) {
+		fmt.Fprintf(&sb, "%-24s %v, %d objects of %d B, %0.0f%% active\n",
+			c.Name,
+			bold.Sprintf("%8s", humanize.Bytes(c.Size)),
+			c.Objects,
+			c.ObjectSize,
+			c.ActiveShare()*100,
+		)
+	}
+	return sb.String()
+}
+
+func (p *SlabProbe) Serialize() interface{} {
+	return p
+}
+
+// A usual suspect is pointed at once it takes suspectShare of the slab, and suspectSize bytes
+const (
+	suspectShare = 0.25
+	suspectSize  = 256 << 20
+)
+
+func (p *SlabProbe) Analysis() (observations []*analysis.Observation) {
+	if p.Unavailable != "" {
+		observations = append(observations, &analysis.Observation{
+			Type:    analysis.Hint,
+			Message: fmt.Sprintf("Could not rank the slab caches: %s", p.Unavailable),
+		})
+		return
+	}
+	for _, c := range p.TopCaches(p.Top) {
+		if c.Suspect == "" || c.Size < suspectSize || float64(c.Size)/float64(p.Total) < suspectShare {
+			continue
+		}
+		observations = append(observations, &analysis.Observation{
+			Type: analysis.Note,
+			Message: fmt.Sprintf("%s takes %s, %0.0f%% of the slab: %s",
+				c.Name, humanize.Bytes(c.Size), float64(c.Size)/float64(p.Total)*100, c.Suspect),
+			See: "slabtop",
+		})
+	}
+	return
+}
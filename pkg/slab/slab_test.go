@@ -0,0 +1,48 @@
+package slab
+
+import (
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/procfs"
+	"github.com/sredog/sre/pkg/analysis"
+)
+
+type fakeSlabInfo struct {
+	info procfs.SlabInfo
+	err  error
+}
+
+func (f *fakeSlabInfo) SlabInfo() (procfs.SlabInfo, error) {
+	return f.info, f.err
+}
+
+func TestSuspects(t *testing.T) {
+	pages := int64((1 << 30) / os.Getpagesize())
+	p, err := NewSlabProbe(&fakeSlabInfo{info: procfs.SlabInfo{Slabs: []*procfs.Slab{
+		{Name: "kmalloc-64", ObjActive: 100, ObjNum: 128, ObjSize: 64, PagesPerSlab: 1, SlabNum: 2},
+		// 1 GiB of negative dentries
+		{Name: "dentry", ObjActive: 5000000, ObjNum: 5592405, ObjSize: 192, PagesPerSlab: 1, SlabNum: pages},
+		{Name: "buffer_head", ObjActive: 1000, ObjNum: 1000, ObjSize: 104, PagesPerSlab: 1, SlabNum: 100},
+	}}}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Caches[0].Name != "dentry" || p.Caches[2].Name != "kmalloc-64" || p.Caches[2].Suspect == "" || p.Caches[1].Suspect != "" {
+		t.Errorf("Expected the caches ranked by size with the suspects told apart, got %+v %+v %+v", p.Caches[0], p.Caches[1], p.Caches[2])
+	}
+	observations := p.Analysis()
+	if len(observations) != 1 || !strings.HasPrefix(observations[0].Message, "dentry takes 1.1 GB, 100% of the slab") {
+		t.Errorf("Expected a note about dentry only, got %+v", observations)
+	}
+	if summary := p.Summary(2); !strings.HasPrefix(summary, "dentry 1.1 GB, buffer_head") {
+		t.Errorf("Unexpected summary %q", summary)
+	}
+
+	p, err = NewSlabProbe(&fakeSlabInfo{err: fs.ErrPermission}, 10)
+	if err != nil || p.Unavailable == "" || p.Analysis()[0].Type != analysis.Hint {
+		t.Errorf("Expected the probe to say slabinfo is unreadable, got %+v, %v", p, err)
+	}
+}
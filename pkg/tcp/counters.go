@@ -0,0 +1,97 @@
+package tcp
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sredog/sre/pkg/analysis"
+	"github.com/sredog/sre/pkg/fsroot"
+)
+
+// Counters of /proc/net/snmp and /proc/net/netstat keyed by protocol and name,
+// e.g. "Tcp.RetransSegs" or "TcpExt.ListenOverflows"
+type Counters map[string]int64
+
+// ParseCounters parses the pairs of lines with the names and the values of a protocol's counters:
+//
+//	Tcp: RtoAlgorithm RtoMin RtoMax MaxConn ActiveOpens ...
+//	Tcp: 1 200 120000 -1 48 ...
+func ParseCounters(r io.Reader, counters Counters) error {
+	scanner := bufio.NewScanner(r)
+	// TcpExt has well over a hundred counters
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	var names []string
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || !strings.HasSuffix(fields[0], ":") {
+			continue
+		}
+		protocol := strings.TrimSuffix(fields[0], ":")
+		if names == nil {
+			names = fields
+			continue
+		}
+		if names[0] != fields[0] || len(names) != len(fields) {
+			return fmt.Errorf("%s values don't match the names", protocol)
+		}
+		for i, field := range fields[1:] {
+			val, err := strconv.ParseInt(field, 10, 64)
+			if err != nil {
+				return fmt.Errorf("could not parse %s.%s: %w", protocol, names[i+1], err)
+			}
+			counters[protocol+"."+names[i+1]] = val
+		}
+		names = nil
+	}
+	return scanner.Err()
+}
+
+// ReadCounters reads /proc/net/snmp and /proc/net/netstat from procfs mounted at procfsRoot
+func ReadCounters(procfsRoot string) (Counters, error) {
+	counters := make(Counters)
+	for _, name := range []string{"snmp", "netstat"} {
+		f, err := os.Open(filepath.Join(procfsRoot, "net", name))
+		if err != nil {
+			return nil, err
+		}
+		err = ParseCounters(f, counters)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return counters, nil
+}
+
+// Sample is the counters of snmp and netstat before and after the interval
+type Sample analysis.Sample[Counters]
+
+// TakeSample samples the counters, see analysis.TakeSample
+func TakeSample(ctx context.Context, roots *fsroot.Roots, interval time.Duration) (*Sample, error) {
+	s, err := analysis.TakeSample(ctx, roots.Proc, interval, func() (Counters, error) {
+		return ReadCounters(roots.Proc)
+	})
+	return (*Sample)(s), err
+}
+
+// Delta returns how much the counter increased over the sample
+func (s *Sample) Delta(name string) int64 {
+	delta := s.After[name] - s.Before[name]
+	// counters of older kernels are 32 bits and wrap
+	if delta < 0 {
+		return 0
+	}
+	return delta
+}
+
+// Rate returns the increase of the counter per second
+func (s *Sample) Rate(name string) float64 {
+	return float64(s.Delta(name)) / s.Elapsed.Seconds()
+}
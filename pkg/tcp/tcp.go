@@ -0,0 +1,362 @@
+// Package tcp reports the health of the TCP and UDP stacks: retransmits, accept queue
+// overflows, memory pressure, orphaned and TIME-WAIT sockets and UDP buffer errors
+// See https://www.kernel.org/doc/Documentation/networking/proc_net_tcp.txt
+// and https://www.kernel.org/doc/Documentation/networking/ip-sysctl.txt
+package tcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/enescakir/emoji"
+	"github.com/fatih/color"
+	"github.com/prometheus/procfs"
+	"github.com/sredog/sre/pkg/analysis"
+	"github.com/sredog/sre/pkg/format"
+	"github.com/sredog/sre/pkg/fsroot"
+)
+
+// Rates of the counters that only go up when something goes wrong, per second
+type Rates struct {
+	// Retransmits and OutSegments are TCP segments
+	Retransmits float64
+	OutSegments float64
+	// InErrors are segments received with bad checksums or headers
+	InErrors float64
+	// ListenOverflows are connections dropped because the accept queue was full,
+	// ListenDrops counts them along with the other reasons to drop a SYN
+	ListenOverflows float64
+	ListenDrops     float64
+	// SyncookiesSent are sent when the SYN queue is full, under a SYN flood or a burst of connections
+	SyncookiesSent float64
+	// MemoryPressures counts TCP entering memory pressure, AbortOnMemory the connections
+	// killed for lack of memory
+	MemoryPressures float64
+	AbortOnMemory   float64
+	// TimeWaitOverflows are TIME-WAIT sockets not created because tcp_max_tw_buckets was reached
+	TimeWaitOverflows float64
+	// UDP datagrams dropped because the socket buffer was full, or with errors
+	UDPRcvbufErrors float64
+	UDPSndbufErrors float64
+	UDPInErrors     float64
+}
+
+// RetransmitShare returns the share of the segments sent that were retransmits
+func (r *Rates) RetransmitShare() float64 {
+	if r.OutSegments == 0 {
+		return 0
+	}
+	return r.Retransmits / r.OutSegments
+}
+
+// NewRates computes the rates over the sample
+func NewRates(s *Sample) Rates {
+	return Rates{
+		Retransmits:       s.Rate("Tcp.RetransSegs"),
+		OutSegments:       s.Rate("Tcp.OutSegs"),
+		InErrors:          s.Rate("Tcp.InErrs"),
+		ListenOverflows:   s.Rate("TcpExt.ListenOverflows"),
+		ListenDrops:       s.Rate("TcpExt.ListenDrops"),
+		SyncookiesSent:    s.Rate("TcpExt.SyncookiesSent"),
+		MemoryPressures:   s.Rate("TcpExt.TCPMemoryPressures"),
+		AbortOnMemory:     s.Rate("TcpExt.TCPAbortOnMemory"),
+		TimeWaitOverflows: s.Rate("TcpExt.TCPTimeWaitOverflow"),
+		UDPRcvbufErrors:   s.Rate("Udp.RcvbufErrors"),
+		UDPSndbufErrors:   s.Rate("Udp.SndbufErrors"),
+		UDPInErrors:       s.Rate("Udp.InErrors"),
+	}
+}
+
+// Sockets holds the current number of sockets from /proc/net/sockstat and sockstat6
+type Sockets struct {
+	// InUse are the TCP sockets over IPv4 and IPv6, listening ones included
+	InUse    int
+	Orphan   int
+	TimeWait int
+	// MemoryPages is the memory used by TCP buffers, in pages like tcp_mem
+	MemoryPages int
+	UDPInUse    int
+}
+
+// ReadSockets reads sockstat, and sockstat6 when IPv6 is enabled.
+// The orphans, TIME-WAIT and memory of sockstat already cover IPv6.
+func ReadSockets(fs procfs.FS) (*Sockets, error) {
+	stat, err := fs.NetSockstat()
+	if err != nil {
+		return nil, err
+	}
+	s := &Sockets{}
+	s.add(stat)
+	stat6, err := fs.NetSockstat6()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if stat6 != nil {
+		s.add(stat6)
+	}
+	return s, nil
+}
+
+func (s *Sockets) add(stat *procfs.NetSockstat) {
+	value := func(v *int) int {
+		if v == nil {
+			return 0
+		}
+		return *v
+	}
+	for _, p := range stat.Protocols {
+		switch p.Protocol {
+		case "TCP", "TCP6":
+			s.InUse += p.InUse
+			s.Orphan += value(p.Orphan)
+			s.TimeWait += value(p.TW)
+			s.MemoryPages += value(p.Mem)
+		case "UDP", "UDP6":
+			s.UDPInUse += p.InUse
+		}
+	}
+}
+
+// Limits are the sysctls the sockets are compared with, 0 when they can't be read
+type Limits struct {
+	// TCPMem are the low, pressure and high thresholds of tcp_mem, in pages
+	TCPMem [3]int64
+	// MaxOrphans is tcp_max_orphans
+	MaxOrphans int64
+	// EphemeralPorts is the size of ip_local_port_range, the ports a client can connect from
+	EphemeralPorts int64
+}
+
+// readSysctl reads the whitespace separated integers of a file under /proc/sys/net/ipv4
+func readSysctl(roots *fsroot.Roots, name string) []int64 {
+	content, err := ioutil.ReadFile(roots.ProcPath("sys", "net", "ipv4", name))
+	if err != nil {
+		return nil
+	}
+	var values []int64
+	for _, field := range strings.Fields(string(content)) {
+		v, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return nil
+		}
+		values = append(values, v)
+	}
+	return values
+}
+
+// ReadLimits reads the sysctls, best-effort
+func ReadLimits(roots *fsroot.Roots) *Limits {
+	l := &Limits{}
+	if mem := readSysctl(roots, "tcp_mem"); len(mem) == 3 {
+		copy(l.TCPMem[:], mem)
+	}
+	if orphans := readSysctl(roots, "tcp_max_orphans"); len(orphans) == 1 {
+		l.MaxOrphans = orphans[0]
+	}
+	if ports := readSysctl(roots, "ip_local_port_range"); len(ports) == 2 {
+		l.EphemeralPorts = ports[1] - ports[0] + 1
+	}
+	return l
+}
+
+type TCPProbe struct {
+	Interval time.Duration
+	Rates
+	Sockets *Sockets
+	Limits  *Limits
+}
+
+// NewTCPProbe samples the snmp and netstat counters, then reads the sockets and the limits
+func NewTCPProbe(ctx context.Context, roots *fsroot.Roots, interval time.Duration) (*TCPProbe, error) {
+	s, err := TakeSample(ctx, roots, interval)
+	if err != nil {
+		return nil, err
+	}
+	fs, err := roots.ProcFS()
+	if err != nil {
+		return nil, err
+	}
+	sockets, err := ReadSockets(fs)
+	if err != nil {
+		return nil, err
+	}
+	return NewTCPProbeFromSample(s, sockets, ReadLimits(roots)), nil
+}
+
+// NewTCPProbeFromSample builds the probe from counters and sockets read elsewhere
+func NewTCPProbeFromSample(s *Sample, sockets *Sockets, limits *Limits) *TCPProbe {
+	return &TCPProbe{
+		Interval: s.Interval,
+		Rates:    NewRates(s),
+		Sockets:  sockets,
+		Limits:   limits,
+	}
+}
+
+func init() {
+	analysis.Register(&analysis.ProbeConfiguration{
+		ID:          "tcp",
+		Aliases:     []string{"sockets", "snmp"},
+		Description: "TCP retransmits, accept queue overflows, memory pressure, orphans, TIME-WAIT and UDP buffer errors",
+		Build: func(ctx context.Context, env *analysis.Environment) (analysis.Probe, error) {
+			return NewTCPProbe(ctx, env.Roots, env.Interval)
+		},
+	})
+}
+
+func (p *TCPProbe) period() string {
+	if p.Interval == 0 {
+		return "since boot"
+	}
+	return fmt.Sprintf("over %v", p.Interval)
+}
+
+// share returns part of whole, 0 when whole isn't known
+func share(part int, whole int64) float64 {
+	if whole <= 0 {
+		return 0
+	}
+	return float64(part) / float64(whole)
+}
+
+const displayFormat = "%v %v TCP socket(s), %v orphaned, %v in TIME-WAIT, %v UDP socket(s)\n"
+
+func (p *TCPProbe) Display() string {
+	bold := color.New(color.Bold)
+	var sb strings.Builder
+	fmt.Fprintf(&sb, displayFormat,
+		emoji.ElectricPlug,
+		bold.Sprint(p.Sockets.InUse),
+		format.ColorForUtilization(share(p.Sockets.Orphan, p.Limits.MaxOrphans), 0.5, 0.25, 0.1).Sprint(p.Sockets.Orphan),
+		format.ColorForUtilization(share(p.Sockets.TimeWait, p.Limits.EphemeralPorts), 0.5, 0.25, 0.1).Sprint(p.Sockets.TimeWait),
+		bold.Sprint(p.Sockets.UDPInUse),
+	)
+	fmt.Fprintf(&sb, "TCP memory: %v pages (pressure at %d, limit %d)\n",
+		format.ColorForUtilization(share(p.Sockets.MemoryPages, p.Limits.TCPMem[1]), 1, 0.8, 0.5).Sprint(p.Sockets.MemoryPages),
+		p.Limits.TCPMem[1], p.Limits.TCPMem[2])
+	fmt.Fprintf(&sb, "Retransmits: %v/s (%v of segments sent) %v\n",
+		bold.Sprintf("%0.1f", p.Retransmits),
+		format.ColorForUtilization(p.RetransmitShare(), 0.05, 0.01, 0.001).Sprintf("%0.2f%%", p.RetransmitShare()*100),
+		p.period())
+	fmt.Fprintf(&sb, "Listen overflows: %0.1f/s, listen drops: %0.1f/s, SYN cookies: %0.1f/s, UDP receive buffer errors: %0.1f/s\n",
+		p.ListenOverflows, p.ListenDrops, p.SyncookiesSent, p.UDPRcvbufErrors)
+	return sb.String()
+}
+
+func (p *TCPProbe) Serialize() interface{} {
+	return struct {
+		IntervalSeconds float64
+		Rates           Rates
+		RetransmitShare float64
+		Sockets         *Sockets
+		Limits          *Limits
+	}{
+		IntervalSeconds: p.Interval.Seconds(),
+		Rates:           p.Rates,
+		RetransmitShare: p.RetransmitShare(),
+		Sockets:         p.Sockets,
+		Limits:          p.Limits,
+	}
+}
+
+// retransmitThresholds are the shares of segments retransmitted worth a warning and an issue
+var retransmitThresholds = [2]float64{0.01, 0.05}
+
+// SinceBoot tells whether the rates are averaged since boot
+func (p *TCPProbe) SinceBoot() bool {
+	return p.Interval == 0
+}
+
+func (p *TCPProbe) Analysis() (observations []*analysis.Observation) {
+	// since boot, counters that went up once long ago are worth a note, not a warning
+	increasing := analysis.Warning
+	if p.Interval == 0 {
+		increasing = analysis.Note
+	}
+	if share := p.RetransmitShare(); share >= retransmitThresholds[0] {
+		t := increasing
+		if share >= retransmitThresholds[1] && p.Interval > 0 {
+			t = analysis.Issue
+		}
+		observations = append(observations, &analysis.Observation{
+			Type: t,
+			Message: fmt.Sprintf("%0.1f%% of the TCP segments sent %s were retransmits (%0.1f/s) - packet loss or congestion on the path, see which connections with `ss -ti`",
+				share*100, p.period(), p.Retransmits),
+			See: "ss",
+		})
+	}
+	if p.ListenOverflows > 0 || p.ListenDrops > 0 {
+		observations = append(observations, &analysis.Observation{
+			Type: increasing,
+			Message: fmt.Sprintf("Listening sockets dropped %0.1f connections/s %s, %0.1f/s of them with a full accept queue - the application doesn't accept fast enough, or its backlog is too small (check Recv-Q with `ss -lnt`)",
+				p.ListenDrops, p.period(), p.ListenOverflows),
+			See: "ss",
+		})
+	}
+	if p.SyncookiesSent > 0 {
+		observations = append(observations, &analysis.Observation{
+			Type:    increasing,
+			Message: fmt.Sprintf("%0.1f SYN cookies/s were sent %s, the SYN queue overflowed - a SYN flood, or tcp_max_syn_backlog too small for the connection rate", p.SyncookiesSent, p.period()),
+			See:     "nstat",
+		})
+	}
+	if p.MemoryPressures > 0 || p.AbortOnMemory > 0 {
+		observations = append(observations, &analysis.Observation{
+			Type: increasing,
+			Message: fmt.Sprintf("TCP entered memory pressure %0.1f times/s and aborted %0.1f connections/s for lack of memory %s - see tcp_mem",
+				p.MemoryPressures, p.AbortOnMemory, p.period()),
+			See: "nstat",
+		})
+	}
+	if pressure := p.Limits.TCPMem[1]; pressure > 0 && int64(p.Sockets.MemoryPages) >= pressure {
+		observations = append(observations, &analysis.Observation{
+			Type:    analysis.Warning,
+			Message: fmt.Sprintf("TCP buffers use %d pages, above the pressure threshold of tcp_mem (%d) - the kernel is shrinking socket buffers", p.Sockets.MemoryPages, pressure),
+			See:     "ss",
+		})
+	}
+	if o := share(p.Sockets.Orphan, p.Limits.MaxOrphans); o >= 0.5 {
+		observations = append(observations, &analysis.Observation{
+			Type:    analysis.Warning,
+			Message: fmt.Sprintf("%d orphaned TCP sockets, %0.0f%% of tcp_max_orphans (%d) - closed by the application but still holding memory until the peer is done", p.Sockets.Orphan, o*100, p.Limits.MaxOrphans),
+			See:     "ss",
+		})
+	}
+	if tw := share(p.Sockets.TimeWait, p.Limits.EphemeralPorts); tw >= 0.5 {
+		observations = append(observations, &analysis.Observation{
+			Type: analysis.Warning,
+			Message: fmt.Sprintf("%d TCP sockets in TIME-WAIT, %0.0f%% of the %d ephemeral ports - clients opening short connections to the same destination may run out of ports, reuse connections",
+				p.Sockets.TimeWait, tw*100, p.Limits.EphemeralPorts),
+			See: "ss",
+		})
+	}
+	if p.TimeWaitOverflows > 0 {
+		observations = append(observations, &analysis.Observation{
+			Type:    increasing,
+			Message: fmt.Sprintf("%0.1f TIME-WAIT sockets/s weren't created %s because tcp_max_tw_buckets was reached", p.TimeWaitOverflows, p.period()),
+			See:     "nstat",
+		})
+	}
+	if p.InErrors > 0 {
+		observations = append(observations, &analysis.Observation{
+			Type:    increasing,
+			Message: fmt.Sprintf("%0.1f TCP segments/s were received with errors %s, e.g. bad checksums", p.InErrors, p.period()),
+			See:     "nstat",
+		})
+	}
+	if p.UDPRcvbufErrors > 0 || p.UDPSndbufErrors > 0 {
+		observations = append(observations, &analysis.Observation{
+			Type: increasing,
+			Message: fmt.Sprintf("UDP dropped %0.1f datagrams/s with a full receive buffer and %0.1f/s with a full send buffer %s - the application doesn't read fast enough, or rmem_max is too small",
+				p.UDPRcvbufErrors, p.UDPSndbufErrors, p.period()),
+			See: "nstat",
+		})
+	}
+	return
+}
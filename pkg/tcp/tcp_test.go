@@ -0,0 +1,84 @@
+package tcp
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sredog/sre/pkg/analysis"
+)
+
+const snmp = `Ip: Forwarding DefaultTTL InReceives
+Ip: 1 64 1000
+Tcp: RtoAlgorithm RtoMin RtoMax MaxConn ActiveOpens RetransSegs OutSegs InErrs
+Tcp: 1 200 120000 -1 48 %d %d 0
+Udp: InDatagrams NoPorts InErrors OutDatagrams RcvbufErrors SndbufErrors
+Udp: 100 0 0 100 0 0
+`
+
+const netstat = `TcpExt: SyncookiesSent ListenOverflows ListenDrops TCPMemoryPressures
+TcpExt: 0 %d %d 0
+`
+
+func counters(t *testing.T, retrans, out, overflows int) Counters {
+	c := make(Counters)
+	for _, content := range []string{
+		fmt.Sprintf(snmp, retrans, out),
+		fmt.Sprintf(netstat, overflows, overflows),
+	} {
+		if err := ParseCounters(strings.NewReader(content), c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return c
+}
+
+func TestParseCounters(t *testing.T) {
+	c := counters(t, 5, 1000, 2)
+	for name, expected := range map[string]int64{
+		"Tcp.MaxConn":            -1,
+		"Tcp.RetransSegs":        5,
+		"TcpExt.ListenOverflows": 2,
+		"Udp.RcvbufErrors":       0,
+	} {
+		if c[name] != expected {
+			t.Errorf("Expected %s to be %d, got %d", name, expected, c[name])
+		}
+	}
+	err := ParseCounters(strings.NewReader("Tcp: RtoAlgorithm RtoMin\nTcp: 1\n"), make(Counters))
+	if err == nil {
+		t.Error("Expected an error when the values don't match the names")
+	}
+}
+
+func TestAnalysis(t *testing.T) {
+	s := &Sample{
+		Before:   counters(t, 100, 10000, 0),
+		After:    counters(t, 400, 14000, 30),
+		Interval: time.Second,
+		Elapsed:  time.Second,
+	}
+	p := NewTCPProbeFromSample(s,
+		&Sockets{InUse: 100, TimeWait: 20000},
+		&Limits{TCPMem: [3]int64{100, 200, 300}, MaxOrphans: 1000, EphemeralPorts: 28232})
+	if p.Retransmits != 300 || p.RetransmitShare() != 0.075 {
+		t.Errorf("Expected 300 retransmits/s, 7.5%% of the segments, got %0.1f and %0.3f", p.Retransmits, p.RetransmitShare())
+	}
+	types := make(map[string]analysis.ObservationType)
+	for _, o := range p.Analysis() {
+		types[strings.Fields(o.Message)[1]] = o.Type
+	}
+	for word, expected := range map[string]analysis.ObservationType{
+		"of":      analysis.Issue,   // 7.5% of the TCP segments sent
+		"sockets": analysis.Warning, // Listening sockets dropped
+		"TCP":     analysis.Warning, // 20000 TCP sockets in TIME-WAIT
+	} {
+		if got, ok := types[word]; !ok || got != expected {
+			t.Errorf("Expected a %v about %q, got %+v", expected, word, types)
+		}
+	}
+	if len(types) != 3 {
+		t.Errorf("Expected 3 observations, got %+v", types)
+	}
+}
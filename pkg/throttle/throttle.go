@@ -0,0 +1,278 @@
+// Package throttle ranks cgroups by how much CFS bandwidth control throttled them
+// See https://www.kernel.org/doc/Documentation/scheduler/sched-bwc.txt
+// and https://www.kernel.org/doc/Documentation/admin-guide/cgroup-v2.rst
+package throttle
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/enescakir/emoji"
+	"github.com/fatih/color"
+	"github.com/sredog/sre/pkg/analysis"
+	"github.com/sredog/sre/pkg/format"
+)
+
+// cgroup v1 mounts the cpu controller under one of these names, depending on the distro
+var v1CPUDirs = []string{"cpu,cpuacct", "cpu", "cpuacct,cpu"}
+
+// DetectHierarchy finds the hierarchy with the cpu controller under cgroupRoot
+// (usually /sys/fs/cgroup) and tells whether it's cgroup v1 or v2
+func DetectHierarchy(cgroupRoot string) (string, int, error) {
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err == nil {
+		return cgroupRoot, 2, nil
+	}
+	for _, dir := range v1CPUDirs {
+		path := filepath.Join(cgroupRoot, dir)
+		if _, err := os.Stat(filepath.Join(path, "cpu.stat")); err == nil {
+			return path, 1, nil
+		}
+	}
+	return "", 0, fmt.Errorf("no cgroup v1 or v2 cpu hierarchy found in %s", cgroupRoot)
+}
+
+// CPUStat holds the bandwidth control counters from cpu.stat
+type CPUStat struct {
+	Periods       uint64
+	Throttled     uint64
+	ThrottledTime time.Duration
+}
+
+// ParseCPUStat reads cpu.stat from cgroup v1 (throttled_time in ns) or v2 (throttled_usec)
+func ParseCPUStat(r io.Reader) (*CPUStat, error) {
+	// $ cat /sys/fs/cgroup/cpu.stat
+	// usage_usec 4215716
+	// nr_periods 130
+	// nr_throttled 12
+	// throttled_usec 803458
+	stat := &CPUStat{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		val, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse %s: %w", fields[0], err)
+		}
+		switch fields[0] {
+		case "nr_periods":
+			stat.Periods = val
+		case "nr_throttled":
+			stat.Throttled = val
+		case "throttled_usec":
+			stat.ThrottledTime = time.Duration(val) * time.Microsecond
+		case "throttled_time":
+			stat.ThrottledTime = time.Duration(val) * time.Nanosecond
+		}
+	}
+	return stat, scanner.Err()
+}
+
+// Process is a PID with its command name
+type Process struct {
+	PID  int
+	Comm string
+}
+
+// Cgroup is a throttled cgroup with the processes in it
+type Cgroup struct {
+	Path string
+	CPUStat
+	Processes []Process
+}
+
+// Ratio returns the share of enforcement periods in which the cgroup was throttled
+func (c *Cgroup) Ratio() float64 {
+	if c.Periods == 0 {
+		return 0
+	}
+	return float64(c.Throttled) / float64(c.Periods)
+}
+
+type ThrottleProbe struct {
+	Hierarchy string
+	Version   int
+	// Cgroups with CPU quota set, the most throttled first
+	Cgroups []*Cgroup
+	// Top limits how many cgroups are displayed
+	Top int
+}
+
+// NewThrottleProbe walks the cgroup hierarchy under cgroupRoot and maps the cgroups
+// to processes using procfs mounted at procfsRoot
+func NewThrottleProbe(procfsRoot, cgroupRoot string, top int) (*ThrottleProbe, error) {
+	hierarchy, version, err := DetectHierarchy(cgroupRoot)
+	if err != nil {
+		return nil, err
+	}
+	p := &ThrottleProbe{
+		Hierarchy: hierarchy,
+		Version:   version,
+		Top:       top,
+	}
+	err = filepath.WalkDir(hierarchy, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// cgroups come and go while we walk
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		f, err := os.Open(filepath.Join(path, "cpu.stat"))
+		if err != nil {
+			return nil
+		}
+		defer f.Close()
+		stat, err := ParseCPUStat(f)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		// no periods means no quota was ever enforced
+		if stat.Periods == 0 {
+			return nil
+		}
+		rel, err := filepath.Rel(hierarchy, path)
+		if err != nil {
+			return err
+		}
+		p.Cgroups = append(p.Cgroups, &Cgroup{
+			Path:      "/" + strings.TrimPrefix(rel, "."),
+			CPUStat:   *stat,
+			Processes: readProcesses(procfsRoot, path),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(p.Cgroups, func(i, j int) bool {
+		if p.Cgroups[i].ThrottledTime != p.Cgroups[j].ThrottledTime {
+			return p.Cgroups[i].ThrottledTime > p.Cgroups[j].ThrottledTime
+		}
+		return p.Cgroups[i].Ratio() > p.Cgroups[j].Ratio()
+	})
+	return p, nil
+}
+
+// DefaultTop is how many cgroups are displayed unless told otherwise
+const DefaultTop = 20
+
+func init() {
+	analysis.Register(&analysis.ProbeConfiguration{
+		ID:          "throttle",
+		Description: "cgroups ranked by CPU throttling",
+		Build: func(ctx context.Context, env *analysis.Environment) (analysis.Probe, error) {
+			return NewThrottleProbe(env.Roots.Proc, env.Roots.CgroupPath(), DefaultTop)
+		},
+	})
+}
+
+// readProcesses lists the processes directly in a cgroup
+func readProcesses(procfsRoot, cgroupPath string) (processes []Process) {
+	content, err := ioutil.ReadFile(filepath.Join(cgroupPath, "cgroup.procs"))
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Fields(string(content)) {
+		pid, err := strconv.Atoi(line)
+		if err != nil {
+			continue
+		}
+		comm := "?"
+		if c, err := ioutil.ReadFile(filepath.Join(procfsRoot, line, "comm")); err == nil {
+			comm = strings.TrimSpace(string(c))
+		}
+		processes = append(processes, Process{PID: pid, Comm: comm})
+	}
+	return
+}
+
+// top returns the cgroups worth displaying
+func (p *ThrottleProbe) top() []*Cgroup {
+	if p.Top > 0 && len(p.Cgroups) > p.Top {
+		return p.Cgroups[:p.Top]
+	}
+	return p.Cgroups
+}
+
+func processSummary(processes []Process) string {
+	const max = 3
+	names := make([]string, 0, max)
+	for i, proc := range processes {
+		if i == max {
+			names = append(names, fmt.Sprintf("+%d more", len(processes)-max))
+			break
+		}
+		names = append(names, fmt.Sprintf("%d (%s)", proc.PID, proc.Comm))
+	}
+	return strings.Join(names, ", ")
+}
+
+const displayFormat = "%v %v cgroup(s) with CPU quota in cgroup v%d hierarchy %v\n"
+
+func (p *ThrottleProbe) Display() string {
+	bold := color.New(color.Bold)
+	var sb strings.Builder
+	fmt.Fprintf(&sb, displayFormat,
+		emoji.Stopwatch,
+		bold.Sprint(len(p.Cgroups)),
+		p.Version,
+		p.Hierarchy,
+	)
+	for _, c := range p.top() {
+		ratioColor := format.ColorForUtilization(c.Ratio(), 0.5, 0.25, 0.1)
+		fmt.Fprintf(&sb, "%v throttled in %v of %d periods: %v",
+			bold.Sprint(c.ThrottledTime.Round(time.Millisecond)),
+			ratioColor.Sprintf("%0.2f%%", c.Ratio()*100),
+			c.Periods,
+			c.Path,
+		)
+		if len(c.Processes) > 0 {
+			fmt.Fprintf(&sb, " [%s]", processSummary(c.Processes))
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+func (p *ThrottleProbe) Serialize() interface{} {
+	return p
+}
+
+func (p *ThrottleProbe) Analysis() (observations []*analysis.Observation) {
+	for _, c := range p.top() {
+		var observationType analysis.ObservationType
+		switch ratio := c.Ratio(); {
+		case ratio > 0.5:
+			observationType = analysis.Issue
+		case ratio > 0.1:
+			observationType = analysis.Warning
+		default:
+			continue
+		}
+		observations = append(observations, &analysis.Observation{
+			Type:    observationType,
+			Message: fmt.Sprintf("%s was throttled in %0.0f%% of periods (%v in total) - its CPU quota is too low for its load", c.Path, c.Ratio()*100, c.ThrottledTime.Round(time.Millisecond)),
+		})
+	}
+	observations = append(observations, &analysis.Observation{
+		Type:    analysis.Learn,
+		Message: "Learn more about CFS bandwidth control https://www.kernel.org/doc/Documentation/scheduler/sched-bwc.txt",
+	})
+	return
+}
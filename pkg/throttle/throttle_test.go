@@ -0,0 +1,67 @@
+package throttle
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestThrottleV2(t *testing.T) {
+	proc := t.TempDir()
+	cgroup := t.TempDir()
+	writeFile(t, filepath.Join(cgroup, "cgroup.controllers"), "cpu io memory\n")
+	writeFile(t, filepath.Join(cgroup, "cpu.stat"), "usage_usec 100\n")
+	writeFile(t, filepath.Join(cgroup, "kubepods/pod1/cpu.stat"), "usage_usec 100\nnr_periods 100\nnr_throttled 80\nthrottled_usec 2000000\n")
+	writeFile(t, filepath.Join(cgroup, "kubepods/pod1/cgroup.procs"), "42\n")
+	writeFile(t, filepath.Join(cgroup, "kubepods/pod2/cpu.stat"), "usage_usec 100\nnr_periods 100\nnr_throttled 5\nthrottled_usec 3000000\n")
+	writeFile(t, filepath.Join(proc, "42/comm"), "java\n")
+
+	p, err := NewThrottleProbe(proc, cgroup, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Version != 2 {
+		t.Errorf("Expected cgroup v2, got %d", p.Version)
+	}
+	if len(p.Cgroups) != 2 {
+		t.Fatalf("Expected 2 throttled cgroups, got %v", p.Cgroups)
+	}
+	if p.Cgroups[0].Path != "/kubepods/pod2" || p.Cgroups[0].ThrottledTime != 3*time.Second {
+		t.Errorf("Expected pod2 throttled for 3s first, got %+v", p.Cgroups[0])
+	}
+	pod1 := p.Cgroups[1]
+	if pod1.Ratio() != 0.8 {
+		t.Errorf("Expected 0.8 throttled ratio, got %f", pod1.Ratio())
+	}
+	if len(pod1.Processes) != 1 || pod1.Processes[0].PID != 42 || pod1.Processes[0].Comm != "java" {
+		t.Errorf("Expected java with PID 42, got %v", pod1.Processes)
+	}
+}
+
+func TestThrottleV1(t *testing.T) {
+	cgroup := t.TempDir()
+	writeFile(t, filepath.Join(cgroup, "cpu,cpuacct/cpu.stat"), "nr_periods 0\nnr_throttled 0\nthrottled_time 0\n")
+	writeFile(t, filepath.Join(cgroup, "cpu,cpuacct/docker/abc/cpu.stat"), "nr_periods 10\nnr_throttled 1\nthrottled_time 1500000\n")
+
+	p, err := NewThrottleProbe(t.TempDir(), cgroup, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Version != 1 {
+		t.Errorf("Expected cgroup v1, got %d", p.Version)
+	}
+	if len(p.Cgroups) != 1 || p.Cgroups[0].ThrottledTime != 1500*time.Microsecond {
+		t.Errorf("Expected docker/abc throttled for 1.5ms, got %v", p.Cgroups)
+	}
+}
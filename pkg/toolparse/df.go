@@ -0,0 +1,119 @@
+package toolparse
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/enescakir/emoji"
+	"github.com/sredog/sre/pkg/analysis"
+	"github.com/sredog/sre/pkg/format"
+)
+
+var dfParser = &Parser{
+	Tool: "df",
+	Detect: func(lines []string) bool {
+		return len(lines) > 0 && strings.HasPrefix(lines[0], "Filesystem") && strings.Contains(lines[0], "Mounted on")
+	},
+	Parse: parseDF,
+}
+
+// DFMount is a row of df (or df -i)
+type DFMount struct {
+	Filesystem string
+	MountPoint string
+	Used       float64
+}
+
+// DFOutput holds the usage of every mount, either of blocks or of inodes (df -i)
+type DFOutput struct {
+	Inodes bool
+	Mounts []DFMount
+}
+
+func parseDF(lines []string) (analysis.Probe, error) {
+	// Filesystem     1K-blocks    Used Available Use% Mounted on
+	// /dev/sda1       10000000 9500000    500000  95% /
+	d := &DFOutput{
+		Inodes: strings.Contains(lines[0], "IUse%"),
+	}
+	pending := ""
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		// df wraps long filesystem names onto their own line
+		if len(fields) == 1 {
+			pending = fields[0]
+			continue
+		}
+		if pending != "" {
+			fields = append([]string{pending}, fields...)
+			pending = ""
+		}
+		usage := -1
+		for i, field := range fields {
+			if strings.HasSuffix(field, "%") {
+				usage = i
+				break
+			}
+		}
+		if usage < 1 || usage == len(fields)-1 {
+			continue
+		}
+		used, err := parseFloat(strings.TrimSuffix(fields[usage], "%"))
+		if err != nil {
+			// df prints "-" for filesystems without inodes
+			continue
+		}
+		d.Mounts = append(d.Mounts, DFMount{
+			Filesystem: fields[0],
+			MountPoint: strings.Join(fields[usage+1:], " "),
+			Used:       used / 100,
+		})
+	}
+	if len(d.Mounts) == 0 {
+		return nil, fmt.Errorf("no filesystems found")
+	}
+	return d, nil
+}
+
+func (d *DFOutput) what() string {
+	if d.Inodes {
+		return "inodes"
+	}
+	return "space"
+}
+
+func (d *DFOutput) Display() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%v df: %d filesystem(s), %s usage\n", emoji.FileCabinet, len(d.Mounts), d.what())
+	for _, m := range d.Mounts {
+		fmt.Fprintf(&sb, "%v %s (%s)\n",
+			format.ColorForUtilization(m.Used, 0.95, 0.9, 0.75).Sprintf("%3.0f%%", m.Used*100),
+			m.MountPoint,
+			m.Filesystem,
+		)
+	}
+	return sb.String()
+}
+
+func (d *DFOutput) Serialize() interface{} {
+	return d
+}
+
+func (d *DFOutput) Analysis() (observations []*analysis.Observation) {
+	for _, m := range d.Mounts {
+		var observationType analysis.ObservationType
+		switch {
+		case m.Used >= 0.95:
+			observationType = analysis.Issue
+		case m.Used >= 0.9:
+			observationType = analysis.Warning
+		default:
+			continue
+		}
+		observations = append(observations, &analysis.Observation{
+			Type:    observationType,
+			Message: fmt.Sprintf("%s (%s) has %0.0f%% of its %s used", m.MountPoint, m.Filesystem, m.Used*100, d.what()),
+		})
+	}
+	return
+}
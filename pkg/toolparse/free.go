@@ -0,0 +1,128 @@
+package toolparse
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/enescakir/emoji"
+	"github.com/fatih/color"
+	"github.com/sredog/sre/pkg/analysis"
+	"github.com/sredog/sre/pkg/format"
+)
+
+var freeParser = &Parser{
+	Tool: "free",
+	Detect: func(lines []string) bool {
+		return findHeader(lines, "total", "used", "free") >= 0 && hasPrefix(lines, "Mem:")
+	},
+	Parse: parseFree,
+}
+
+// FreeOutput holds the values of free, in the unit free printed them in
+type FreeOutput struct {
+	MemTotal     float64
+	MemUsed      float64
+	MemFree      float64
+	MemAvailable float64
+	SwapTotal    float64
+	SwapUsed     float64
+}
+
+func parseFree(lines []string) (analysis.Probe, error) {
+	//                total        used        free      shared  buff/cache   available
+	// Mem:        16262708     5123456     1234567      123456     9904685    10700000
+	// Swap:        2097148      100000     1997148
+	header := strings.Fields(lines[findHeader(lines, "total", "used", "free")])
+	column := func(name string) int {
+		for i, h := range header {
+			if h == name {
+				return i
+			}
+		}
+		return -1
+	}
+	f := &FreeOutput{}
+	hasAvailable := false
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || (fields[0] != "Mem:" && fields[0] != "Swap:") {
+			continue
+		}
+		values := make([]float64, 0, len(fields)-1)
+		for _, field := range fields[1:] {
+			val, err := parseSize(field)
+			if err != nil {
+				return nil, fmt.Errorf("could not parse %q: %w", line, err)
+			}
+			values = append(values, val)
+		}
+		value := func(name string) float64 {
+			i := column(name)
+			if i < 0 || i >= len(values) {
+				return 0
+			}
+			return values[i]
+		}
+		switch fields[0] {
+		case "Mem:":
+			f.MemTotal, f.MemUsed, f.MemFree = value("total"), value("used"), value("free")
+			if i := column("available"); i >= 0 && i < len(values) {
+				f.MemAvailable = values[i]
+				hasAvailable = true
+			}
+		case "Swap:":
+			f.SwapTotal, f.SwapUsed = value("total"), value("used")
+		}
+	}
+	if f.MemTotal == 0 {
+		return nil, fmt.Errorf("no total memory found")
+	}
+	if !hasAvailable {
+		// old versions of free don't know about MemAvailable, the closest is free memory
+		f.MemAvailable = f.MemFree
+	}
+	return f, nil
+}
+
+func (f *FreeOutput) Display() string {
+	used := 1 - ratio(f.MemAvailable, f.MemTotal)
+	usedColor := format.ColorForUtilization(used, 0.9, 0.75, 0.5)
+	bold := color.New(color.Bold)
+	return fmt.Sprintf("%v free: memory is %v used, swap is %v used\n",
+		emoji.ComputerDisk,
+		usedColor.Sprintf("%0.2f%%", used*100),
+		bold.Sprintf("%0.2f%%", ratio(f.SwapUsed, f.SwapTotal)*100),
+	)
+}
+
+func (f *FreeOutput) Serialize() interface{} {
+	return f
+}
+
+func (f *FreeOutput) Analysis() (observations []*analysis.Observation) {
+	available := ratio(f.MemAvailable, f.MemTotal)
+	if available < 0.1 {
+		observations = append(observations, &analysis.Observation{
+			Type:    analysis.Warning,
+			Message: fmt.Sprintf("Only %0.2f%% of memory is available", available*100),
+		})
+	}
+	swapUsed := ratio(f.SwapUsed, f.SwapTotal)
+	if swapUsed > 0.5 {
+		observations = append(observations, &analysis.Observation{
+			Type:    analysis.Warning,
+			Message: fmt.Sprintf("%0.2f%% of swap is used", swapUsed*100),
+		})
+	}
+	if f.SwapUsed > 0 && available > 0.5 {
+		observations = append(observations, &analysis.Observation{
+			Type:    analysis.Note,
+			Message: "Swap is in use even though most memory is available - check vm.swappiness",
+		})
+	}
+	observations = append(observations, &analysis.Observation{
+		Type:    analysis.Learn,
+		Message: "\"available\" is what matters, not \"free\": https://www.linuxatemyram.com/",
+	})
+	return
+}
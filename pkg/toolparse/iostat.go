@@ -0,0 +1,183 @@
+package toolparse
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/enescakir/emoji"
+	"github.com/fatih/color"
+	"github.com/sredog/sre/pkg/analysis"
+	"github.com/sredog/sre/pkg/format"
+)
+
+var iostatParser = &Parser{
+	Tool: "iostat -x",
+	Detect: func(lines []string) bool {
+		for _, line := range lines {
+			if strings.HasPrefix(line, "Device") && strings.Contains(line, "%util") {
+				return true
+			}
+		}
+		return false
+	},
+	Parse: parseIOStat,
+}
+
+// IOStatOutput holds the extended device statistics of the last report iostat printed
+type IOStatOutput struct {
+	// Devices maps device names to their columns, e.g. "r_await" or "%util"
+	Devices map[string]map[string]float64
+	// CPU holds the avg-cpu columns, e.g. "%iowait"
+	CPU     map[string]float64
+	Reports int
+}
+
+func parseIOStat(lines []string) (analysis.Probe, error) {
+	// avg-cpu:  %user   %nice %system %iowait  %steal   %idle
+	//            1.00    0.00    0.50    0.20    0.00   98.30
+	//
+	// Device            r/s     rkB/s   rrqm/s  %rrqm r_await rareq-sz     w/s ...  aqu-sz  %util
+	// sda              0.50     10.00     0.00   0.00    0.50    20.00    1.00 ...    0.00   0.10
+	o := &IOStatOutput{
+		Devices: make(map[string]map[string]float64),
+		CPU:     make(map[string]float64),
+	}
+	for i := 0; i < len(lines); i++ {
+		fields := strings.Fields(lines[i])
+		if len(fields) == 0 {
+			continue
+		}
+		switch {
+		case fields[0] == "avg-cpu:" && i+1 < len(lines):
+			header := fields[1:]
+			values := strings.Fields(lines[i+1])
+			for j := 0; j < len(header) && j < len(values); j++ {
+				if val, err := parseFloat(values[j]); err == nil {
+					o.CPU[header[j]] = val
+				}
+			}
+			i++
+		case strings.TrimSuffix(fields[0], ":") == "Device":
+			// every report after the first one overrides the averages since boot
+			o.Reports++
+			header := fields[1:]
+			for i+1 < len(lines) && strings.TrimSpace(lines[i+1]) != "" {
+				i++
+				row := strings.Fields(lines[i])
+				if len(row) != len(header)+1 {
+					continue
+				}
+				device := make(map[string]float64, len(header))
+				for j, name := range header {
+					val, err := parseFloat(row[j+1])
+					if err != nil {
+						return nil, fmt.Errorf("could not parse %s of %s: %w", name, row[0], err)
+					}
+					device[name] = val
+				}
+				o.Devices[row[0]] = device
+			}
+		}
+	}
+	if len(o.Devices) == 0 {
+		return nil, fmt.Errorf("no devices found")
+	}
+	return o, nil
+}
+
+func (o *IOStatOutput) deviceNames() []string {
+	names := make([]string, 0, len(o.Devices))
+	for name := range o.Devices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// await returns the average wait of a device in ms, from either the new
+// split r_await/w_await columns or the old combined await
+func await(device map[string]float64) float64 {
+	if await, ok := device["await"]; ok {
+		return await
+	}
+	r, w := device["r/s"], device["w/s"]
+	if r+w == 0 {
+		return 0
+	}
+	return (device["r_await"]*r + device["w_await"]*w) / (r + w)
+}
+
+// queueSize handles the column being renamed from avgqu-sz to aqu-sz
+func queueSize(device map[string]float64) float64 {
+	if q, ok := device["aqu-sz"]; ok {
+		return q
+	}
+	return device["avgqu-sz"]
+}
+
+func (o *IOStatOutput) Display() string {
+	bold := color.New(color.Bold)
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%v iostat: %d device(s) in %d report(s), iowait %v\n",
+		emoji.FloppyDisk,
+		len(o.Devices),
+		o.Reports,
+		bold.Sprintf("%0.2f%%", o.CPU["%iowait"]),
+	)
+	for _, name := range o.deviceNames() {
+		device := o.Devices[name]
+		utilColor := format.ColorForUtilization(device["%util"]/100, 0.9, 0.75, 0.5)
+		fmt.Fprintf(&sb, "%v: %v util, await %v ms, queue %v, r/s %0.1f, w/s %0.1f\n",
+			name,
+			utilColor.Sprintf("%0.2f%%", device["%util"]),
+			bold.Sprintf("%0.2f", await(device)),
+			bold.Sprintf("%0.2f", queueSize(device)),
+			device["r/s"],
+			device["w/s"],
+		)
+	}
+	return sb.String()
+}
+
+func (o *IOStatOutput) Serialize() interface{} {
+	return o
+}
+
+func (o *IOStatOutput) Analysis() (observations []*analysis.Observation) {
+	if o.Reports == 1 {
+		observations = append(observations, &analysis.Observation{
+			Type:    analysis.Hint,
+			Message: "The first iostat report is the average since boot, run `iostat -x 1 3` to see what's happening now",
+			See:     "iostat",
+		})
+	}
+	for _, name := range o.deviceNames() {
+		device := o.Devices[name]
+		if util := device["%util"]; util > 90 {
+			observations = append(observations, &analysis.Observation{
+				Type:    analysis.Warning,
+				Message: fmt.Sprintf("%s is %0.2f%% busy", name, util),
+			})
+		}
+		if a := await(device); a > 50 {
+			observations = append(observations, &analysis.Observation{
+				Type:    analysis.Warning,
+				Message: fmt.Sprintf("%s requests wait %0.2f ms on average", name, a),
+			})
+		}
+		if q := queueSize(device); q > 1 {
+			observations = append(observations, &analysis.Observation{
+				Type:    analysis.Note,
+				Message: fmt.Sprintf("%s has %0.2f requests queued on average - it may be saturated", name, q),
+			})
+		}
+	}
+	if iowait := o.CPU["%iowait"]; iowait > 20 {
+		observations = append(observations, &analysis.Observation{
+			Type:    analysis.Warning,
+			Message: fmt.Sprintf("CPUs spend %0.2f%% of time waiting on I/O", iowait),
+		})
+	}
+	return
+}
@@ -0,0 +1,133 @@
+package toolparse
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/enescakir/emoji"
+	"github.com/fatih/color"
+	"github.com/sredog/sre/pkg/analysis"
+	"github.com/sredog/sre/pkg/format"
+)
+
+var mpstatParser = &Parser{
+	Tool: "mpstat",
+	Detect: func(lines []string) bool {
+		for _, t := range parseTimeTables(lines) {
+			if t.has("CPU", "%usr", "%idle") {
+				return true
+			}
+		}
+		return false
+	},
+	Parse: parseMPStat,
+}
+
+// CPUTable holds per-CPU utilisation percentages by column name, including the "all" row
+type CPUTable struct {
+	CPUCount int
+	CPUs     []string
+	Values   map[string]map[string]float64
+}
+
+func parseMPStat(lines []string) (analysis.Probe, error) {
+	for _, t := range parseTimeTables(lines) {
+		if t.has("CPU", "%usr", "%idle") {
+			return newCPUTable(lines, t)
+		}
+	}
+	return nil, fmt.Errorf("no CPU table found")
+}
+
+func newCPUTable(lines []string, t *timeTable) (*CPUTable, error) {
+	cpus, values := t.latest("CPU")
+	if len(cpus) == 0 {
+		return nil, fmt.Errorf("no CPU samples found")
+	}
+	count := cpuCount(lines)
+	if count == 0 {
+		// without the banner, count the CPUs other than "all"
+		count = len(cpus) - 1
+	}
+	return &CPUTable{
+		CPUCount: count,
+		CPUs:     cpus,
+		Values:   values,
+	}, nil
+}
+
+// busy returns the utilisation of a CPU as a ratio
+func (c *CPUTable) busy(cpu string) float64 {
+	return 1 - c.Values[cpu]["%idle"]/100
+}
+
+func (c *CPUTable) Display() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%v %v CPUs:", emoji.Fire, color.New(color.Bold).Sprint(c.CPUCount))
+	for _, cpu := range c.CPUs {
+		busyColor := format.ColorForUtilization(c.busy(cpu), 0.95, 0.85, 0.5)
+		fmt.Fprintf(&sb, " %s=%v", cpu, busyColor.Sprintf("%0.0f%%", c.busy(cpu)*100))
+	}
+	sb.WriteString("\n")
+	return sb.String()
+}
+
+func (c *CPUTable) Serialize() interface{} {
+	return c
+}
+
+// value returns the first of the columns the CPU has, as mpstat and sar name some of them differently
+func (c *CPUTable) value(cpu string, columns ...string) float64 {
+	for _, column := range columns {
+		if v, ok := c.Values[cpu][column]; ok {
+			return v
+		}
+	}
+	return 0
+}
+
+func (c *CPUTable) Analysis() (observations []*analysis.Observation) {
+	all, hasAll := c.Values["all"]
+	if hasAll {
+		if all["%idle"] < 10 {
+			observations = append(observations, &analysis.Observation{
+				Type:    analysis.Warning,
+				Message: fmt.Sprintf("CPUs are only %0.2f%% idle", all["%idle"]),
+			})
+		}
+		if all["%iowait"] > 20 {
+			observations = append(observations, &analysis.Observation{
+				Type:    analysis.Warning,
+				Message: fmt.Sprintf("CPUs spend %0.2f%% of time waiting on I/O", all["%iowait"]),
+			})
+		}
+		if all["%steal"] > 10 {
+			observations = append(observations, &analysis.Observation{
+				Type:    analysis.Warning,
+				Message: fmt.Sprintf("%0.2f%% of CPU time is stolen by the hypervisor", all["%steal"]),
+			})
+		}
+	}
+	var hot []string
+	for _, cpu := range c.CPUs {
+		if cpu == "all" {
+			continue
+		}
+		if c.busy(cpu) > 0.9 && hasAll && all["%idle"] > 50 {
+			hot = append(hot, cpu)
+		}
+		if irq := c.value(cpu, "%irq") + c.value(cpu, "%soft"); irq > 30 {
+			observations = append(observations, &analysis.Observation{
+				Type:    analysis.Warning,
+				Message: fmt.Sprintf("CPU %s spends %0.2f%% of time in interrupts - check IRQ affinity in /proc/interrupts", cpu, irq),
+			})
+		}
+	}
+	if len(hot) > 0 {
+		observations = append(observations, &analysis.Observation{
+			Type:    analysis.Warning,
+			Message: fmt.Sprintf("CPU(s) %s are saturated while the rest is mostly idle - look for a single-threaded bottleneck", strings.Join(hot, ", ")),
+		})
+	}
+	return
+}
@@ -0,0 +1,117 @@
+package toolparse
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/enescakir/emoji"
+	"github.com/fatih/color"
+	"github.com/sredog/sre/pkg/analysis"
+	"github.com/sredog/sre/pkg/format"
+)
+
+var sarParser = &Parser{
+	Tool: "sar",
+	Detect: func(lines []string) bool {
+		for _, t := range parseTimeTables(lines) {
+			if t.has("CPU", "%user", "%idle") || t.has("%memused") || t.has("runq-sz") {
+				return true
+			}
+		}
+		return false
+	},
+	Parse: parseSar,
+}
+
+// SarOutput holds the reports sar knows: CPU (sar -u), memory (sar -r) and load (sar -q)
+type SarOutput struct {
+	CPU      *CPUTable
+	Memory   map[string]float64
+	Load     map[string]float64
+	CPUCount int
+}
+
+func parseSar(lines []string) (analysis.Probe, error) {
+	s := &SarOutput{
+		CPUCount: cpuCount(lines),
+	}
+	for _, t := range parseTimeTables(lines) {
+		switch {
+		case t.has("CPU", "%user", "%idle"):
+			cpu, err := newCPUTable(lines, t)
+			if err != nil {
+				return nil, err
+			}
+			s.CPU = cpu
+		case t.has("%memused"):
+			_, rows := t.latest("")
+			s.Memory = rows[""]
+		case t.has("runq-sz"):
+			_, rows := t.latest("")
+			s.Load = rows[""]
+		}
+	}
+	if s.CPU == nil && s.Memory == nil && s.Load == nil {
+		return nil, fmt.Errorf("no CPU, memory or queue report found")
+	}
+	return s, nil
+}
+
+func (s *SarOutput) Display() string {
+	bold := color.New(color.Bold)
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%v sar\n", emoji.BarChart)
+	if s.CPU != nil {
+		sb.WriteString(s.CPU.Display())
+	}
+	if s.Memory != nil {
+		used := s.Memory["%memused"] / 100
+		fmt.Fprintf(&sb, "%v Memory is %v used (including caches), %v committed\n",
+			emoji.ComputerDisk,
+			format.ColorForUtilization(used, 0.9, 0.75, 0.5).Sprintf("%0.2f%%", used*100),
+			bold.Sprintf("%0.2f%%", s.Memory["%commit"]),
+		)
+	}
+	if s.Load != nil {
+		fmt.Fprintf(&sb, "%v Run queue: %v, load avg: %v (1m), %v (5m), %v (15m), blocked: %v\n",
+			emoji.ChartIncreasing,
+			bold.Sprintf("%0.0f", s.Load["runq-sz"]),
+			bold.Sprintf("%0.2f", s.Load["ldavg-1"]),
+			bold.Sprintf("%0.2f", s.Load["ldavg-5"]),
+			bold.Sprintf("%0.2f", s.Load["ldavg-15"]),
+			bold.Sprintf("%0.0f", s.Load["blocked"]),
+		)
+	}
+	return sb.String()
+}
+
+func (s *SarOutput) Serialize() interface{} {
+	return s
+}
+
+func (s *SarOutput) Analysis() (observations []*analysis.Observation) {
+	if s.CPU != nil {
+		observations = append(observations, s.CPU.Analysis()...)
+	}
+	if s.Memory != nil && s.Memory["%commit"] > 100 {
+		observations = append(observations, &analysis.Observation{
+			Type:    analysis.Warning,
+			Message: fmt.Sprintf("Memory is overcommitted: %0.2f%% committed", s.Memory["%commit"]),
+		})
+	}
+	if s.Load != nil && s.CPUCount > 0 {
+		if runq := s.Load["runq-sz"]; runq > float64(s.CPUCount) {
+			observations = append(observations, &analysis.Observation{
+				Type:    analysis.Warning,
+				Message: fmt.Sprintf("%0.0f tasks waiting to run on %d CPUs - CPUs are saturated", runq, s.CPUCount),
+			})
+		}
+	}
+	if s.Load != nil && s.Load["blocked"] >= 1 {
+		observations = append(observations, &analysis.Observation{
+			Type:    analysis.Warning,
+			Message: fmt.Sprintf("%0.0f tasks blocked, usually waiting on I/O", s.Load["blocked"]),
+		})
+	}
+	return
+}
@@ -0,0 +1,116 @@
+package toolparse
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/enescakir/emoji"
+	"github.com/fatih/color"
+	"github.com/sredog/sre/pkg/analysis"
+)
+
+var ssParser = &Parser{
+	Tool: "ss -s",
+	Detect: func(lines []string) bool {
+		return hasPrefix(lines, "Total:") && hasPrefix(lines, "TCP:") && findHeader(lines, "Transport", "Total") >= 0
+	},
+	Parse: parseSS,
+}
+
+// SSOutput holds the socket summary of ss -s
+type SSOutput struct {
+	Total int
+	// TCP states, e.g. "estab", "timewait", "orphaned"
+	TCP map[string]int
+	// Transports map e.g. "UDP" to its total number of sockets
+	Transports map[string]int
+}
+
+var ssStateRE = regexp.MustCompile(`([a-z]+) (\d+)`)
+
+func parseSS(lines []string) (analysis.Probe, error) {
+	// Total: 190
+	// TCP:   12 (estab 5, closed 1, orphaned 0, timewait 1)
+	//
+	// Transport Total     IP        IPv6
+	// UDP	  5         3         2
+	// TCP	  11        8         3
+	s := &SSOutput{
+		TCP:        make(map[string]int),
+		Transports: make(map[string]int),
+	}
+	inTransports := false
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		switch {
+		case fields[0] == "Total:":
+			fmt.Sscanf(fields[1], "%d", &s.Total)
+		case fields[0] == "TCP:":
+			var total int
+			fmt.Sscanf(fields[1], "%d", &total)
+			s.TCP["total"] = total
+			// older versions print "timewait 1/0", the part after the slash is ignored
+			for _, m := range ssStateRE.FindAllStringSubmatch(line, -1) {
+				var val int
+				fmt.Sscanf(m[2], "%d", &val)
+				s.TCP[m[1]] = val
+			}
+		case fields[0] == "Transport":
+			inTransports = true
+		case inTransports:
+			var val int
+			if _, err := fmt.Sscanf(fields[1], "%d", &val); err == nil {
+				s.Transports[fields[0]] = val
+			}
+		}
+	}
+	if len(s.TCP) == 0 {
+		return nil, fmt.Errorf("no TCP summary found")
+	}
+	return s, nil
+}
+
+func (s *SSOutput) Display() string {
+	bold := color.New(color.Bold)
+	return fmt.Sprintf("%v ss: %v sockets, TCP: %v established, %v time-wait, %v orphaned, UDP: %v\n",
+		emoji.GlobeWithMeridians,
+		bold.Sprint(s.Total),
+		bold.Sprint(s.TCP["estab"]),
+		bold.Sprint(s.TCP["timewait"]),
+		bold.Sprint(s.TCP["orphaned"]),
+		bold.Sprint(s.Transports["UDP"]),
+	)
+}
+
+func (s *SSOutput) Serialize() interface{} {
+	return s
+}
+
+// the default net.ipv4.ip_local_port_range of 32768-60999 gives 28232 ephemeral ports
+const ephemeralPorts = 28232
+
+func (s *SSOutput) Analysis() (observations []*analysis.Observation) {
+	if timewait := s.TCP["timewait"]; timewait > ephemeralPorts/2 {
+		observations = append(observations, &analysis.Observation{
+			Type:    analysis.Warning,
+			Message: fmt.Sprintf("%d sockets in TIME-WAIT - connections to a single destination may run out of ephemeral ports", timewait),
+		})
+	}
+	if orphaned := s.TCP["orphaned"]; orphaned > 1000 {
+		observations = append(observations, &analysis.Observation{
+			Type:    analysis.Warning,
+			Message: fmt.Sprintf("%d orphaned TCP sockets - compare with net.ipv4.tcp_max_orphans", orphaned),
+		})
+	}
+	if synrecv := s.TCP["synrecv"]; synrecv > 100 {
+		observations = append(observations, &analysis.Observation{
+			Type:    analysis.Warning,
+			Message: fmt.Sprintf("%d sockets in SYN-RECV - slow clients or a SYN flood", synrecv),
+		})
+	}
+	return
+}
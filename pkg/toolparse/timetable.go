@@ -0,0 +1,141 @@
+package toolparse
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// sysstat tools (mpstat, sar) print tables with a timestamp in front of every line:
+//
+// 12:00:01 PM  CPU    %usr   %nice    %sys ...
+// 12:00:02 PM  all    1.00    0.00    0.50 ...
+// Average:     all    1.00    0.00    0.50 ...
+
+var timestampRE = regexp.MustCompile(`^\d{1,2}:\d{2}(:\d{2})?$`)
+var cpuCountRE = regexp.MustCompile(`\((\d+) CPU\)`)
+
+type timeRow struct {
+	Average bool
+	Values  []string
+}
+
+type timeTable struct {
+	Columns []string
+	Rows    []timeRow
+}
+
+// timestampWidth returns how many tokens the timestamp takes, or 0 if there's none
+func timestampWidth(fields []string) int {
+	switch {
+	case len(fields) > 0 && fields[0] == "Average:":
+		return 1
+	case len(fields) > 1 && timestampRE.MatchString(fields[0]) && (fields[1] == "AM" || fields[1] == "PM"):
+		return 2
+	case len(fields) > 0 && timestampRE.MatchString(fields[0]):
+		return 1
+	}
+	return 0
+}
+
+func isNumeric(s string) bool {
+	_, err := parseFloat(s)
+	return err == nil
+}
+
+// parseTimeTables splits the output into tables, each starting with a header
+func parseTimeTables(lines []string) (tables []*timeTable) {
+	var current *timeTable
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		width := timestampWidth(fields)
+		if width == 0 || len(fields) == width {
+			continue
+		}
+		values := fields[width:]
+		header := true
+		for _, v := range values {
+			if isNumeric(v) {
+				header = false
+				break
+			}
+		}
+		if header {
+			if current != nil && strings.Join(current.Columns, " ") == strings.Join(values, " ") {
+				// the same header repeated
+				continue
+			}
+			current = &timeTable{Columns: values}
+			tables = append(tables, current)
+			continue
+		}
+		if current == nil || len(values) != len(current.Columns) {
+			continue
+		}
+		current.Rows = append(current.Rows, timeRow{
+			Average: fields[0] == "Average:",
+			Values:  values,
+		})
+	}
+	return
+}
+
+func (t *timeTable) has(columns ...string) bool {
+	for _, c := range columns {
+		if t.index(c) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *timeTable) index(column string) int {
+	for i, c := range t.Columns {
+		if c == column {
+			return i
+		}
+	}
+	return -1
+}
+
+// latest returns the Average rows if the tool printed them, or else the last row
+// for every key (e.g. CPU or device), with values parsed by column name
+func (t *timeTable) latest(key string) (keys []string, rows map[string]map[string]float64) {
+	rows = make(map[string]map[string]float64)
+	keyIndex := t.index(key)
+	hasAverage := false
+	for _, row := range t.Rows {
+		hasAverage = hasAverage || row.Average
+	}
+	for _, row := range t.Rows {
+		if hasAverage && !row.Average {
+			continue
+		}
+		k := ""
+		if keyIndex >= 0 {
+			k = row.Values[keyIndex]
+		}
+		if _, seen := rows[k]; !seen {
+			keys = append(keys, k)
+		}
+		values := make(map[string]float64, len(t.Columns))
+		for i, c := range t.Columns {
+			if val, err := parseFloat(row.Values[i]); err == nil {
+				values[c] = val
+			}
+		}
+		rows[k] = values
+	}
+	return
+}
+
+// cpuCount reads the number of CPUs from the banner, e.g. "Linux 5.15.0 (host) 10/18/2026 _x86_64_ (4 CPU)"
+func cpuCount(lines []string) int {
+	for _, line := range lines {
+		if m := cpuCountRE.FindStringSubmatch(line); m != nil {
+			n, _ := strconv.Atoi(m[1])
+			return n
+		}
+	}
+	return 0
+}
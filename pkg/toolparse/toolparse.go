@@ -0,0 +1,179 @@
+// Package toolparse recognises the output of standard Linux tools (free, vmstat, iostat,
+// mpstat, top, sar, df, ss), parses it and analyses it like the live probes would.
+// This is what makes `free | sre analysis` work on output pasted from other machines.
+package toolparse
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/sredog/sre/pkg/analysis"
+)
+
+// Parser turns the output of a single tool into a probe
+type Parser struct {
+	// Tool is the command the parser understands, e.g. "iostat -x"
+	Tool string
+	// Detect tells whether the lines look like the output of Tool
+	Detect func(lines []string) bool
+	// Parse builds the probe out of the lines
+	Parse func(lines []string) (analysis.Probe, error)
+}
+
+// Parsers are tried in order, so the more specific formats need to go first
+var Parsers = []*Parser{
+	topParser,
+	ssParser,
+	freeParser,
+	vmstatParser,
+	iostatParser,
+	mpstatParser,
+	sarParser,
+	dfParser,
+}
+
+// Find returns the parser for a tool, e.g. "vmstat" or "iostat"
+func Find(tool string) (*Parser, error) {
+	for _, p := range Parsers {
+		if p.Tool == tool || strings.Fields(p.Tool)[0] == tool {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("no parser for %q", tool)
+}
+
+// Detect returns the parser recognising the lines
+func Detect(lines []string) (*Parser, error) {
+	for _, p := range Parsers {
+		if p.Detect(lines) {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("could not recognise the output, expected one of: %s", ToolNames())
+}
+
+// ToolNames lists the tools whose output can be parsed
+func ToolNames() string {
+	names := make([]string, 0, len(Parsers))
+	for _, p := range Parsers {
+		names = append(names, p.Tool)
+	}
+	return strings.Join(names, ", ")
+}
+
+// ReadLines reads the whole input, dropping trailing whitespace and carriage returns
+func ReadLines(r io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		lines = append(lines, strings.TrimRight(scanner.Text(), " \t\r"))
+	}
+	return lines, scanner.Err()
+}
+
+// Parse detects the tool that produced the input and parses it
+func Parse(r io.Reader) (*Parser, analysis.Probe, error) {
+	return ParseTool(r, "")
+}
+
+// ParseTool parses the input as the output of the tool, as Find names it, or detects
+// the tool when it's empty
+func ParseTool(r io.Reader, tool string) (*Parser, analysis.Probe, error) {
+	lines, err := ReadLines(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	var parser *Parser
+	if tool != "" {
+		parser, err = Find(tool)
+	} else {
+		parser, err = Detect(lines)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	probe, err := parser.Parse(lines)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", parser.Tool, err)
+	}
+	return parser, probe, nil
+}
+
+func hasPrefix(lines []string, prefix string) bool {
+	for _, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// findHeader returns the index of the first line containing all the fields
+func findHeader(lines []string, fields ...string) int {
+	for i, line := range lines {
+		tokens := make(map[string]bool)
+		for _, t := range strings.Fields(line) {
+			tokens[t] = true
+		}
+		found := true
+		for _, f := range fields {
+			if !tokens[f] {
+				found = false
+				break
+			}
+		}
+		if found {
+			return i
+		}
+	}
+	return -1
+}
+
+// parseSize parses sizes as printed by free -h and df -h ("1.5Gi", "512M", "100K").
+// Plain numbers are returned as they are, in whatever unit the tool used.
+func parseSize(s string) (float64, error) {
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "i")
+	multiplier := 1.0
+	if len(s) > 0 {
+		switch s[len(s)-1] {
+		case 'K', 'k':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		case 'T':
+			multiplier = 1 << 40
+		case 'P':
+			multiplier = 1 << 50
+		}
+		if multiplier != 1 {
+			s = s[:len(s)-1]
+		}
+	}
+	if s == "" {
+		return 0, nil
+	}
+	val, err := strconv.ParseFloat(strings.Replace(s, ",", ".", 1), 64)
+	if err != nil {
+		return 0, err
+	}
+	return val * multiplier, nil
+}
+
+// parseFloat parses numbers, accepting the decimal comma some locales use
+func parseFloat(s string) (float64, error) {
+	return strconv.ParseFloat(strings.Replace(s, ",", ".", 1), 64)
+}
+
+// ratio avoids dividing by zero when a tool prints empty totals
+func ratio(part, total float64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return part / total
+}
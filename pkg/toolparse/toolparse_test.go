@@ -0,0 +1,189 @@
+package toolparse
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sredog/sre/pkg/analysis"
+)
+
+const iostatOutput = `Linux 5.15.0-1 (host) 	10/18/2026 	_x86_64_	(4 CPU)
+
+avg-cpu:  %user   %nice %system %iowait  %steal   %idle
+           1.00    0.00    0.50    0.20    0.00   98.30
+
+Device            r/s     rkB/s   rrqm/s  %rrqm r_await rareq-sz     w/s     wkB/s   wrqm/s  %wrqm w_await wareq-sz  aqu-sz  %util
+sda              0.50     10.00     0.00   0.00    0.50    20.00    1.00    20.00     0.00   0.00    1.00    20.00    0.00   0.10
+
+avg-cpu:  %user   %nice %system %iowait  %steal   %idle
+           5.00    0.00    2.00   30.00    0.00   63.00
+
+Device            r/s     rkB/s   rrqm/s  %rrqm r_await rareq-sz     w/s     wkB/s   wrqm/s  %wrqm w_await wareq-sz  aqu-sz  %util
+sda            100.00   4000.00     0.00   0.00   80.00    40.00  100.00  4000.00     0.00   0.00   60.00    40.00    7.00  99.00
+`
+
+const mpstatOutput = `Linux 5.15.0-1 (host) 	10/18/2026 	_x86_64_	(2 CPU)
+
+12:00:01 PM  CPU    %usr   %nice    %sys %iowait    %irq   %soft  %steal  %guest  %gnice   %idle
+12:00:02 PM  all   50.00    0.00    0.00    0.00    0.00    0.00    0.00    0.00    0.00   50.00
+12:00:02 PM    0  100.00    0.00    0.00    0.00    0.00    0.00    0.00    0.00    0.00    0.00
+12:00:02 PM    1    0.00    0.00    0.00    0.00    0.00    0.00    0.00    0.00    0.00  100.00
+Average:     all   45.00    0.00    0.00    0.00    0.00    0.00    0.00    0.00    0.00   55.00
+Average:       0   95.00    0.00    0.00    0.00    0.00    0.00    0.00    0.00    0.00    5.00
+Average:       1    0.00    0.00    0.00    0.00    0.00    0.00    0.00    0.00    0.00  100.00
+`
+
+const sarOutput = `Linux 5.15.0-1 (host) 	10/18/2026 	_x86_64_	(2 CPU)
+
+12:00:01     runq-sz  plist-sz   ldavg-1   ldavg-5  ldavg-15   blocked
+12:10:01           5       300      4.00      3.00      2.00         0
+Average:           6       300      4.50      3.00      2.00         0
+`
+
+func parse(t *testing.T, output, tool string) analysis.Probe {
+	t.Helper()
+	parser, probe, err := Parse(strings.NewReader(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parser.Tool != tool {
+		t.Fatalf("Expected %s to be detected, got %s", tool, parser.Tool)
+	}
+	return probe
+}
+
+func hasObservation(observations []*analysis.Observation, substring string) bool {
+	for _, o := range observations {
+		if strings.Contains(o.Message, substring) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestIOStatUsesLastReport(t *testing.T) {
+	probe := parse(t, iostatOutput, "iostat -x")
+	o := probe.(*IOStatOutput)
+	if o.Reports != 2 {
+		t.Errorf("Expected 2 reports, got %d", o.Reports)
+	}
+	if o.Devices["sda"]["%util"] != 99 {
+		t.Errorf("Expected sda at 99%% util, got %v", o.Devices["sda"])
+	}
+	if await(o.Devices["sda"]) != 70 {
+		t.Errorf("Expected 70ms await, got %f", await(o.Devices["sda"]))
+	}
+	observations := probe.Analysis()
+	for _, expected := range []string{"sda is 99.00% busy", "wait 70.00 ms", "waiting on I/O"} {
+		if !hasObservation(observations, expected) {
+			t.Errorf("Expected an observation with %q", expected)
+		}
+	}
+}
+
+func TestMPStatDetectsHotCPU(t *testing.T) {
+	probe := parse(t, mpstatOutput, "mpstat")
+	c := probe.(*CPUTable)
+	if c.CPUCount != 2 || len(c.CPUs) != 3 {
+		t.Errorf("Expected 2 CPUs and all, got %d %v", c.CPUCount, c.CPUs)
+	}
+	if c.Values["0"]["%usr"] != 95 {
+		t.Errorf("Expected the Average rows to be used, got %v", c.Values["0"])
+	}
+	if !hasObservation(probe.Analysis(), "CPU(s) 0 are saturated") {
+		t.Errorf("Expected CPU 0 to be reported as saturated")
+	}
+}
+
+func TestSarRunQueue(t *testing.T) {
+	probe := parse(t, sarOutput, "sar")
+	s := probe.(*SarOutput)
+	if s.Load["runq-sz"] != 6 {
+		t.Errorf("Expected the Average run queue of 6, got %v", s.Load)
+	}
+	if !hasObservation(probe.Analysis(), "6 tasks waiting to run on 2 CPUs") {
+		t.Errorf("Expected the run queue to be reported as saturated")
+	}
+}
+
+func TestDetect(t *testing.T) {
+	outputs := map[string]string{
+		"free": `               total        used        free      shared  buff/cache   available
+Mem:            15Gi       4.9Gi       1.2Gi       120Mi       9.4Gi        10Gi
+Swap:          2.0Gi          0B       2.0Gi
+`,
+		"vmstat": `procs -----------memory---------- ---swap-- -----io---- -system-- ------cpu-----
+ r  b   swpd   free   buff  cache   si   so    bi    bo   in   cs us sy id wa st
+ 1  0      0 123456  12345 234567    0    0     1     2    3    4  1  1 98  0  0
+`,
+		"df": `Filesystem     1K-blocks    Used Available Use% Mounted on
+/dev/sda1       10000000 9700000    300000  97% /
+`,
+		"ss -s": `Total: 190
+TCP:   12 (estab 5, closed 1, orphaned 0, timewait 1)
+
+Transport Total     IP        IPv6
+UDP	  5         3         2
+TCP	  11        8         3
+`,
+		"top -b": `top - 12:00:00 up 10 days,  1:00,  1 user,  load average: 0.00, 0.01, 0.05
+Tasks: 200 total,   1 running, 199 sleeping,   0 stopped,   2 zombie
+%Cpu(s):  1.0 us,  0.5 sy,  0.0 ni, 98.0 id,  0.5 wa,  0.0 hi,  0.0 si,  0.0 st
+MiB Mem :  15884.6 total,   1000.0 free,   5000.0 used,   9884.6 buff/cache
+MiB Swap:   2048.0 total,   2048.0 free,      0.0 used.  10000.0 avail Mem
+
+    PID USER      PR  NI    VIRT    RES    SHR S  %CPU  %MEM     TIME+ COMMAND
+      1 root      20   0  168000  12000   8000 D   0.0   0.1   0:05.00 systemd
+`,
+	}
+	for tool, output := range outputs {
+		probe := parse(t, output, tool)
+		if probe.Display() == "" {
+			t.Errorf("Expected %s to display something", tool)
+		}
+	}
+	free := parse(t, outputs["free"], "free").(*FreeOutput)
+	if free.MemAvailable != 10*(1<<30) {
+		t.Errorf("Expected 10Gi available, got %f", free.MemAvailable)
+	}
+	if !hasObservation(parse(t, outputs["df"], "df").Analysis(), "/ (/dev/sda1) has 97%") {
+		t.Errorf("Expected / to be reported as full")
+	}
+	top := parse(t, outputs["top -b"], "top -b")
+	if !hasObservation(top.Analysis(), "2 zombie") || !hasObservation(top.Analysis(), "1 (systemd)") {
+		t.Errorf("Expected zombies and D state processes to be reported, got %v", top.Analysis())
+	}
+}
+
+func TestTopIdle(t *testing.T) {
+	// an idle box, top leaves no space after the comma before 100.0
+	output := `top - 12:00:00 up 10 days,  1:00,  1 user,  load average: 0.00, 0.00, 0.00
+Tasks: 100 total,   1 running,  99 sleeping,   0 stopped,   0 zombie
+%Cpu(s):  0.0 us,  0.0 sy,  0.0 ni,100.0 id,  0.0 wa,  0.0 hi,  0.0 si,  0.0 st
+MiB Mem :  15884.6 total,  14000.0 free,   1000.0 used,    884.6 buff/cache
+MiB Swap:      0.0 total,      0.0 free,      0.0 used.  14500.0 avail Mem
+
+    PID USER      PR  NI    VIRT    RES    SHR S  %CPU  %MEM     TIME+ COMMAND
+      1 root      20   0  168000  12000   8000 S   0.0   0.1   0:05.00 systemd
+`
+	top := parse(t, output, "top -b").(*TopOutput)
+	if top.CPU["id"] != 100 || top.CPU["ni"] != 0 {
+		t.Errorf("Expected the CPUs to be 100%% idle, got %v", top.CPU)
+	}
+	if hasObservation(top.Analysis(), "idle") {
+		t.Errorf("Expected no idle warning, got %v", top.Analysis())
+	}
+}
+
+func TestParseTool(t *testing.T) {
+	parser, _, err := ParseTool(strings.NewReader(sarOutput), "sar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parser != sarParser {
+		t.Errorf("Expected the sar parser, got %s", parser.Tool)
+	}
+	if _, _, err := ParseTool(strings.NewReader(sarOutput), "nosuchtool"); err == nil {
+		t.Errorf("Expected an unknown tool to fail")
+	}
+}
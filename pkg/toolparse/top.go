@@ -0,0 +1,215 @@
+package toolparse
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/enescakir/emoji"
+	"github.com/fatih/color"
+	"github.com/sredog/sre/pkg/analysis"
+	"github.com/sredog/sre/pkg/format"
+)
+
+var topParser = &Parser{
+	Tool: "top -b",
+	Detect: func(lines []string) bool {
+		return hasPrefix(lines, "top - ") && hasPrefix(lines, "Tasks:")
+	},
+	Parse: parseTop,
+}
+
+// TopProcess is a row of the process table
+type TopProcess struct {
+	PID     string
+	User    string
+	State   string
+	CPU     float64
+	Memory  float64
+	Command string
+}
+
+// TopOutput holds the summary area and process table of the last iteration of top
+type TopOutput struct {
+	LoadAverage []float64
+	Tasks       map[string]float64
+	CPU         map[string]float64
+	Memory      map[string]float64
+	Swap        map[string]float64
+	Processes   []TopProcess
+}
+
+// matches "0.5 us" and "1000.0 total" pairs in the summary area. Numbers start with a digit,
+// the comma separating the fields isn't always followed by a space: "0.0 ni,100.0 id"
+var topPairRE = regexp.MustCompile(`(\d[\d.,]*)\s+([a-z/]+(?: [A-Za-z]+)?)`)
+
+func parseTopPairs(line string) map[string]float64 {
+	pairs := make(map[string]float64)
+	if i := strings.Index(line, ":"); i >= 0 {
+		line = line[i+1:]
+	}
+	for _, m := range topPairRE.FindAllStringSubmatch(line, -1) {
+		if val, err := parseFloat(strings.TrimSuffix(m[1], ",")); err == nil {
+			pairs[m[2]] = val
+		}
+	}
+	return pairs
+}
+
+func parseTop(lines []string) (analysis.Probe, error) {
+	// top - 12:00:00 up 10 days,  1:00,  1 user,  load average: 0.00, 0.01, 0.05
+	// Tasks: 200 total,   1 running, 199 sleeping,   0 stopped,   0 zombie
+	// %Cpu(s):  1.0 us,  0.5 sy,  0.0 ni, 98.0 id,  0.5 wa,  0.0 hi,  0.0 si,  0.0 st
+	// MiB Mem :  15884.6 total,   1000.0 free,   5000.0 used,   9884.6 buff/cache
+	// MiB Swap:   2048.0 total,   2048.0 free,      0.0 used.  10000.0 avail Mem
+	//
+	//     PID USER      PR  NI    VIRT    RES    SHR S  %CPU  %MEM     TIME+ COMMAND
+	//       1 root      20   0  168000  12000   8000 S   0.0   0.1   0:05.00 systemd
+	var t *TopOutput
+	var header []string
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "top - "):
+			// only the last iteration of top -n counts
+			t = &TopOutput{}
+			header = nil
+			if i := strings.Index(trimmed, "load average:"); i >= 0 {
+				for _, field := range strings.Split(trimmed[i+len("load average:"):], ", ") {
+					if val, err := parseFloat(strings.TrimSpace(field)); err == nil {
+						t.LoadAverage = append(t.LoadAverage, val)
+					}
+				}
+			}
+		case t == nil:
+			continue
+		case strings.HasPrefix(trimmed, "Tasks:"):
+			t.Tasks = parseTopPairs(trimmed)
+		case strings.Contains(trimmed, "Cpu(s):"):
+			t.CPU = parseTopPairs(trimmed)
+		case strings.Contains(trimmed, "Swap:"):
+			t.Swap = parseTopPairs(trimmed)
+		case strings.Contains(trimmed, "Mem") && strings.Contains(trimmed, "total"):
+			t.Memory = parseTopPairs(trimmed)
+		case strings.HasPrefix(trimmed, "PID "):
+			header = strings.Fields(trimmed)
+		case header != nil && trimmed != "":
+			fields := strings.Fields(trimmed)
+			if len(fields) < len(header) {
+				continue
+			}
+			process := TopProcess{}
+			for i, name := range header {
+				// the command is last and may contain spaces
+				if name == "COMMAND" {
+					process.Command = strings.Join(fields[i:], " ")
+					break
+				}
+				switch name {
+				case "PID":
+					process.PID = fields[i]
+				case "USER":
+					process.User = fields[i]
+				case "S":
+					process.State = fields[i]
+				case "%CPU":
+					process.CPU, _ = parseFloat(fields[i])
+				case "%MEM":
+					process.Memory, _ = parseFloat(fields[i])
+				}
+			}
+			t.Processes = append(t.Processes, process)
+		}
+	}
+	if t == nil || t.CPU == nil {
+		return nil, fmt.Errorf("no summary area found")
+	}
+	return t, nil
+}
+
+func (t *TopOutput) memoryAvailable() (float64, bool) {
+	total := t.Memory["total"]
+	available, ok := t.Swap["avail Mem"]
+	if !ok || total == 0 {
+		return 0, false
+	}
+	return available / total, true
+}
+
+func (t *TopOutput) Display() string {
+	bold := color.New(color.Bold)
+	var sb strings.Builder
+	busy := 1 - t.CPU["id"]/100
+	fmt.Fprintf(&sb, "%v top: load avg %v, %v tasks (%v running, %v zombie), CPUs %v busy\n",
+		emoji.ChartIncreasing,
+		bold.Sprint(t.LoadAverage),
+		bold.Sprintf("%0.0f", t.Tasks["total"]),
+		bold.Sprintf("%0.0f", t.Tasks["running"]),
+		bold.Sprintf("%0.0f", t.Tasks["zombie"]),
+		format.ColorForUtilization(busy, 0.95, 0.85, 0.5).Sprintf("%0.2f%%", busy*100),
+	)
+	if available, ok := t.memoryAvailable(); ok {
+		used := 1 - available
+		fmt.Fprintf(&sb, "%v Memory is %v used\n",
+			emoji.ComputerDisk,
+			format.ColorForUtilization(used, 0.9, 0.75, 0.5).Sprintf("%0.2f%%", used*100),
+		)
+	}
+	for i, p := range t.Processes {
+		if i == 5 {
+			break
+		}
+		fmt.Fprintf(&sb, "%s %s (%s) cpu %0.1f%% mem %0.1f%% state %s\n", p.PID, p.Command, p.User, p.CPU, p.Memory, p.State)
+	}
+	return sb.String()
+}
+
+func (t *TopOutput) Serialize() interface{} {
+	return t
+}
+
+func (t *TopOutput) Analysis() (observations []*analysis.Observation) {
+	if zombies := t.Tasks["zombie"]; zombies > 0 {
+		observations = append(observations, &analysis.Observation{
+			Type:    analysis.Note,
+			Message: fmt.Sprintf("%0.0f zombie process(es) - their parents aren't reaping them", zombies),
+		})
+	}
+	if id := t.CPU["id"]; id < 10 {
+		observations = append(observations, &analysis.Observation{
+			Type:    analysis.Warning,
+			Message: fmt.Sprintf("CPUs are only %0.1f%% idle", id),
+		})
+	}
+	if wa := t.CPU["wa"]; wa > 20 {
+		observations = append(observations, &analysis.Observation{
+			Type:    analysis.Warning,
+			Message: fmt.Sprintf("CPUs spend %0.1f%% of time waiting on I/O", wa),
+		})
+	}
+	if st := t.CPU["st"]; st > 10 {
+		observations = append(observations, &analysis.Observation{
+			Type:    analysis.Warning,
+			Message: fmt.Sprintf("%0.1f%% of CPU time is stolen by the hypervisor", st),
+		})
+	}
+	if available, ok := t.memoryAvailable(); ok && available < 0.1 {
+		observations = append(observations, &analysis.Observation{
+			Type:    analysis.Warning,
+			Message: fmt.Sprintf("Only %0.2f%% of memory is available", available*100),
+		})
+	}
+	var blocked []string
+	for _, p := range t.Processes {
+		if p.State == "D" {
+			blocked = append(blocked, fmt.Sprintf("%s (%s)", p.PID, p.Command))
+		}
+	}
+	if len(blocked) > 0 {
+		observations = append(observations, &analysis.Observation{
+			Type:    analysis.Warning,
+			Message: fmt.Sprintf("Processes in uninterruptible sleep, usually waiting on I/O: %s", strings.Join(blocked, ", ")),
+		})
+	}
+	return
+}
@@ -0,0 +1,138 @@
+package toolparse
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/enescakir/emoji"
+	"github.com/fatih/color"
+	"github.com/sredog/sre/pkg/analysis"
+)
+
+var vmstatParser = &Parser{
+	Tool: "vmstat",
+	Detect: func(lines []string) bool {
+		return findHeader(lines, "r", "b", "swpd", "si", "so") >= 0
+	},
+	Parse: parseVMStat,
+}
+
+// VMStatOutput holds the rows of vmstat by column name. The first row is the average since boot.
+type VMStatOutput struct {
+	Rows []map[string]float64
+}
+
+func parseVMStat(lines []string) (analysis.Probe, error) {
+	// procs -----------memory---------- ---swap-- -----io---- -system-- ------cpu-----
+	//  r  b   swpd   free   buff  cache   si   so    bi    bo   in   cs us sy id wa st
+	//  1  0      0 123456  12345 234567    0    0     1     2    3    4  1  1 98  0  0
+	header := strings.Fields(lines[findHeader(lines, "r", "b", "swpd")])
+	v := &VMStatOutput{}
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) != len(header) {
+			continue
+		}
+		row := make(map[string]float64, len(header))
+		numeric := true
+		for i, field := range fields {
+			val, err := parseFloat(field)
+			if err != nil {
+				numeric = false
+				break
+			}
+			row[header[i]] = val
+		}
+		// skips the headers vmstat repeats every screenful
+		if numeric {
+			v.Rows = append(v.Rows, row)
+		}
+	}
+	if len(v.Rows) == 0 {
+		return nil, fmt.Errorf("no samples found")
+	}
+	return v, nil
+}
+
+// samples drops the first row averaged since boot, unless it's the only one
+func (v *VMStatOutput) samples() []map[string]float64 {
+	if len(v.Rows) > 1 {
+		return v.Rows[1:]
+	}
+	return v.Rows
+}
+
+// average of a column over the samples
+func (v *VMStatOutput) average(column string) float64 {
+	samples := v.samples()
+	var sum float64
+	for _, row := range samples {
+		sum += row[column]
+	}
+	return sum / float64(len(samples))
+}
+
+func (v *VMStatOutput) Display() string {
+	bold := color.New(color.Bold)
+	return fmt.Sprintf(`%v vmstat: %d sample(s), averages:
+Runnable: %v, blocked: %v, swap in: %v, swap out: %v
+CPU user: %v, system: %v, idle: %v, iowait: %v, stolen: %v
+`,
+		emoji.BarChart,
+		len(v.samples()),
+		bold.Sprintf("%0.1f", v.average("r")),
+		bold.Sprintf("%0.1f", v.average("b")),
+		bold.Sprintf("%0.1f", v.average("si")),
+		bold.Sprintf("%0.1f", v.average("so")),
+		bold.Sprintf("%0.0f%%", v.average("us")),
+		bold.Sprintf("%0.0f%%", v.average("sy")),
+		bold.Sprintf("%0.0f%%", v.average("id")),
+		bold.Sprintf("%0.0f%%", v.average("wa")),
+		bold.Sprintf("%0.0f%%", v.average("st")),
+	)
+}
+
+func (v *VMStatOutput) Serialize() interface{} {
+	return v
+}
+
+func (v *VMStatOutput) Analysis() (observations []*analysis.Observation) {
+	if len(v.Rows) == 1 {
+		observations = append(observations, &analysis.Observation{
+			Type:    analysis.Hint,
+			Message: "The first line of vmstat is the average since boot, run `vmstat 1 5` to see what's happening now",
+			See:     "vmstat",
+		})
+	}
+	if b := v.average("b"); b >= 1 {
+		observations = append(observations, &analysis.Observation{
+			Type:    analysis.Warning,
+			Message: fmt.Sprintf("On average %0.1f processes are blocked in uninterruptible sleep, usually waiting on I/O", b),
+		})
+	}
+	if swapping := v.average("si") + v.average("so"); swapping > 0 {
+		observations = append(observations, &analysis.Observation{
+			Type:    analysis.Warning,
+			Message: fmt.Sprintf("The system is swapping (si %0.1f, so %0.1f) - it's short on memory", v.average("si"), v.average("so")),
+		})
+	}
+	if id := v.average("id"); id < 10 {
+		observations = append(observations, &analysis.Observation{
+			Type:    analysis.Warning,
+			Message: fmt.Sprintf("CPUs are only %0.0f%% idle, with %0.1f runnable processes on average", id, v.average("r")),
+		})
+	}
+	if wa := v.average("wa"); wa > 20 {
+		observations = append(observations, &analysis.Observation{
+			Type:    analysis.Warning,
+			Message: fmt.Sprintf("CPUs spend %0.0f%% of time waiting on I/O", wa),
+		})
+	}
+	if st := v.average("st"); st > 10 {
+		observations = append(observations, &analysis.Observation{
+			Type:    analysis.Warning,
+			Message: fmt.Sprintf("%0.0f%% of CPU time is stolen by the hypervisor", st),
+		})
+	}
+	return
+}
@@ -0,0 +1,181 @@
+// Package tools is a catalog of command line tools to debug the components of a Linux system,
+// with example invocations and what to look for in their output.
+package tools
+
+import (
+	_ "embed"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/enescakir/emoji"
+	"github.com/fatih/color"
+	"github.com/sredog/sre/pkg/analysis"
+	"gopkg.in/yaml.v2"
+)
+
+//go:embed catalog.yaml
+var catalogYAML []byte
+
+// Example is an invocation of a tool
+type Example struct {
+	Command     string `yaml:"command"`
+	Explanation string `yaml:"explanation"`
+}
+
+// Entry describes a single tool
+type Entry struct {
+	ID          string    `yaml:"id"`
+	Tool        string    `yaml:"tool"`
+	Package     string    `yaml:"package"`
+	Components  []string  `yaml:"components"`
+	Description string    `yaml:"description"`
+	Examples    []Example `yaml:"examples"`
+	LookFor     string    `yaml:"lookfor"`
+	// Installed is true when Tool is found in $PATH
+	Installed bool `yaml:"-"`
+}
+
+// Catalog parses the embedded catalog and checks which tools are installed
+func Catalog() ([]*Entry, error) {
+	var entries []*Entry
+	if err := yaml.Unmarshal(catalogYAML, &entries); err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		_, err := exec.LookPath(e.Tool)
+		e.Installed = err == nil
+	}
+	return entries, nil
+}
+
+// Components lists the components the catalog covers
+func Components(entries []*Entry) []string {
+	seen := make(map[string]bool)
+	var components []string
+	for _, e := range entries {
+		for _, c := range e.Components {
+			if !seen[c] {
+				seen[c] = true
+				components = append(components, c)
+			}
+		}
+	}
+	sort.Strings(components)
+	return components
+}
+
+func (e *Entry) hasComponent(component string) bool {
+	for _, c := range e.Components {
+		if c == component {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Entry) matches(query string) bool {
+	query = strings.ToLower(query)
+	texts := []string{e.ID, e.Tool, e.Description, e.LookFor}
+	for _, example := range e.Examples {
+		texts = append(texts, example.Command, example.Explanation)
+	}
+	for _, text := range texts {
+		if strings.Contains(strings.ToLower(text), query) {
+			return true
+		}
+	}
+	return false
+}
+
+// Listing is a selection of catalog entries
+type Listing struct {
+	Title   string
+	Entries []*Entry
+}
+
+// Select picks the entries with the ID or component given as the argument,
+// matching the search query. Both are optional.
+func Select(entries []*Entry, arg, search string) (*Listing, error) {
+	l := &Listing{Title: "All tools"}
+	for _, e := range entries {
+		if arg != "" && e.ID == arg {
+			return &Listing{Title: e.ID, Entries: []*Entry{e}}, nil
+		}
+	}
+	if arg != "" {
+		found := false
+		for _, c := range Components(entries) {
+			found = found || c == arg
+		}
+		if !found {
+			return nil, fmt.Errorf("%q is neither a tool nor a component, expected one of: %s", arg, strings.Join(Components(entries), ", "))
+		}
+		l.Title = fmt.Sprintf("Tools for %s", arg)
+	}
+	if search != "" {
+		l.Title += fmt.Sprintf(" matching %q", search)
+	}
+	for _, e := range entries {
+		if arg != "" && !e.hasComponent(arg) {
+			continue
+		}
+		if search != "" && !e.matches(search) {
+			continue
+		}
+		l.Entries = append(l.Entries, e)
+	}
+	return l, nil
+}
+
+func (l *Listing) Display() string {
+	bold := color.New(color.Bold)
+	italic := color.New(color.Italic)
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%v %v\n", emoji.Toolbox, bold.Sprint(l.Title))
+	for _, e := range l.Entries {
+		installed := emoji.CheckMarkButton.String()
+		if !e.Installed {
+			installed = emoji.CrossMark.String()
+		}
+		fmt.Fprintf(&sb, "\n%v %v - %v [%v]\n", installed, bold.Sprint(e.ID), e.Description, strings.Join(e.Components, ", "))
+		for _, example := range e.Examples {
+			fmt.Fprintf(&sb, "  $ %v\n    %v\n", bold.Sprint(example.Command), italic.Sprint(example.Explanation))
+		}
+		fmt.Fprintf(&sb, "  Look for: %v\n", e.LookFor)
+	}
+	return sb.String()
+}
+
+func (l *Listing) Serialize() interface{} {
+	return l
+}
+
+func (l *Listing) Analysis() (observations []*analysis.Observation) {
+	if len(l.Entries) == 0 {
+		observations = append(observations, &analysis.Observation{
+			Type:    analysis.Note,
+			Message: "No tools found",
+		})
+	}
+	missing := make(map[string][]string)
+	var packages []string
+	for _, e := range l.Entries {
+		if e.Installed {
+			continue
+		}
+		if _, ok := missing[e.Package]; !ok {
+			packages = append(packages, e.Package)
+		}
+		missing[e.Package] = append(missing[e.Package], e.Tool)
+	}
+	sort.Strings(packages)
+	for _, p := range packages {
+		observations = append(observations, &analysis.Observation{
+			Type:    analysis.Hint,
+			Message: fmt.Sprintf("Install %s to get %s", p, strings.Join(missing[p], ", ")),
+		})
+	}
+	return
+}
@@ -0,0 +1,55 @@
+package tools
+
+import "testing"
+
+func TestCatalog(t *testing.T) {
+	entries, err := Catalog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make(map[string]bool)
+	for _, e := range entries {
+		if ids[e.ID] {
+			t.Errorf("Duplicate ID %s", e.ID)
+		}
+		ids[e.ID] = true
+		if e.Tool == "" || e.Package == "" || len(e.Components) == 0 || len(e.Examples) == 0 {
+			t.Errorf("Expected %s to have a tool, package, components and examples", e.ID)
+		}
+	}
+	// referenced by the probes' observations
+	for _, id := range []string{"dmesg", "meminfo", "vmstat", "iostat"} {
+		if !ids[id] {
+			t.Errorf("Expected %s in the catalog", id)
+		}
+	}
+}
+
+func TestSelect(t *testing.T) {
+	entries, err := Catalog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := Select(entries, "iostat", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l.Entries) != 1 || l.Entries[0].ID != "iostat" {
+		t.Errorf("Expected iostat only, got %v", l.Entries)
+	}
+	l, err = Select(entries, "disk", "latency")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range l.Entries {
+		if !e.hasComponent("disk") || !e.matches("latency") {
+			t.Errorf("Expected disk tools mentioning latency, got %s", e.ID)
+		}
+	}
+	if len(l.Entries) == 0 {
+		t.Errorf("Expected some disk tools mentioning latency")
+	}
+	if _, err := Select(entries, "nonsense", ""); err == nil {
+		t.Errorf("Expected an error for an unknown component")
+	}
+}
@@ -3,8 +3,10 @@
 package uptime
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
+	"path/filepath"
 	"time"
 
 	"github.com/enescakir/emoji"
@@ -12,7 +14,8 @@ import (
 	"github.com/sredog/sre/pkg/analysis"
 )
 
-const uptimePath = "/proc/uptime"
+// uptimeFile is relative to the procfs root
+const uptimeFile = "uptime"
 
 type UptimeProbe struct {
 	Uptime   time.Duration
@@ -20,9 +23,9 @@ type UptimeProbe struct {
 	CPUCount int
 }
 
-// NewUptimeProbe reads the uptime data and returns a struct representation
-func NewUptimeProbe(CPUCount int) (*UptimeProbe, error) {
-	content, err := ioutil.ReadFile(uptimePath)
+// NewUptimeProbe reads the uptime data from procfs mounted at procfsRoot and returns a struct representation
+func NewUptimeProbe(procfsRoot string, CPUCount int) (*UptimeProbe, error) {
+	content, err := ioutil.ReadFile(filepath.Join(procfsRoot, uptimeFile))
 	if err != nil {
 		return nil, err
 	}
@@ -50,6 +53,25 @@ func NewUptimeProbe(CPUCount int) (*UptimeProbe, error) {
 	return u, nil
 }
 
+func init() {
+	analysis.Register(&analysis.ProbeConfiguration{
+		ID:          "uptime",
+		Aliases:     []string{"up"},
+		Description: "Look into the systems uptime and idle time",
+		Build: func(ctx context.Context, env *analysis.Environment) (analysis.Probe, error) {
+			fs, err := env.Roots.ProcFS()
+			if err != nil {
+				return nil, err
+			}
+			stat, err := fs.Stat()
+			if err != nil {
+				return nil, err
+			}
+			return NewUptimeProbe(env.Roots.Proc, len(stat.CPU))
+		},
+	})
+}
+
 // Utilization returns ratio spent outside Idle process since boot
 // averaged out by the number of cpus
 func (u *UptimeProbe) Utilization() float64 {
@@ -74,6 +96,20 @@ func (u *UptimeProbe) Display() string {
 	)
 }
 
+func (u *UptimeProbe) Serialize() interface{} {
+	return struct {
+		UptimeSeconds float64
+		IdleSeconds   float64
+		CPUCount      int
+		Utilization   float64
+	}{
+		UptimeSeconds: u.Uptime.Seconds(),
+		IdleSeconds:   u.Idle.Seconds(),
+		CPUCount:      u.CPUCount,
+		Utilization:   u.Utilization(),
+	}
+}
+
 func (u *UptimeProbe) Analysis() (observations []*analysis.Observation) {
 	if u.Uptime < time.Hour*time.Duration(24) {
 		observations = append(observations, &analysis.Observation{
@@ -0,0 +1,110 @@
+package use
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/procfs"
+	"github.com/prometheus/procfs/blockdevice"
+	"github.com/sredog/sre/pkg/analysis"
+	"github.com/sredog/sre/pkg/disk"
+	"github.com/sredog/sre/pkg/fsroot"
+	"github.com/sredog/sre/pkg/vmstat"
+)
+
+// sample is a snapshot of the cumulative counters the USE table is computed from
+type sample struct {
+	At     time.Time
+	Stat   procfs.Stat
+	VMStat map[string]uint64
+	Disks  map[string]blockdevice.IOStats
+	// IOErrors are the SCSI error counters of the disks that have them
+	IOErrors map[string]uint64
+	Net      procfs.NetDev
+}
+
+func takeSample(roots *fsroot.Roots) (*sample, error) {
+	stats, err := vmstat.ReadVMStat(roots.ProcPath("vmstat"))
+	if err != nil {
+		return nil, err
+	}
+	disks, err := disk.ReadDiskstats(roots, false)
+	if err != nil {
+		return nil, err
+	}
+	procFS, err := roots.ProcFS()
+	if err != nil {
+		return nil, err
+	}
+	net, err := procFS.NetDev()
+	if err != nil {
+		return nil, err
+	}
+	stat, err := procFS.Stat()
+	if err != nil {
+		return nil, err
+	}
+	ioErrors := make(map[string]uint64)
+	for name := range disks {
+		if errors, ok := diskIOErrors(roots, name); ok {
+			ioErrors[name] = errors
+		}
+	}
+	return &sample{
+		At:       time.Now(),
+		Stat:     stat,
+		VMStat:   stats,
+		Disks:    disks,
+		IOErrors: ioErrors,
+		Net:      net,
+	}, nil
+}
+
+// takeSamples takes two samples interval apart, giving up early if ctx is done
+func takeSamples(ctx context.Context, roots *fsroot.Roots, interval time.Duration) (*sample, *sample, error) {
+	s, err := analysis.TakeSample(ctx, roots.Proc, interval, func() (*sample, error) {
+		return takeSample(roots)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return s.Before, s.After, nil
+}
+
+var scsiHostRE = regexp.MustCompile(`^host\d+$`)
+
+// diskController finds the name of the controller a disk is attached to:
+// the SCSI host for SCSI/SATA/SAS disks, or the device itself for NVMe and virtio
+func diskController(roots *fsroot.Roots, name string) string {
+	device, err := filepath.EvalSymlinks(roots.SysPath("block", name, "device"))
+	if err != nil {
+		return ""
+	}
+	parts := strings.Split(device, string(filepath.Separator))
+	for _, part := range parts {
+		if scsiHostRE.MatchString(part) {
+			return part
+		}
+	}
+	return filepath.Base(device)
+}
+
+// diskIOErrors reads the SCSI error counter of a disk, where available
+func diskIOErrors(roots *fsroot.Roots, name string) (uint64, bool) {
+	content, err := ioutil.ReadFile(roots.SysPath("block", name, "device", "ioerr_cnt"))
+	if err != nil {
+		return 0, false
+	}
+	// $ cat /sys/block/sda/device/ioerr_cnt
+	// 0x2
+	val, err := strconv.ParseUint(strings.TrimSpace(string(content)), 0, 64)
+	if err != nil {
+		return 0, false
+	}
+	return val, true
+}
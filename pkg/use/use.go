@@ -0,0 +1,369 @@
+// Package use runs the USE method checklist: for every resource check its
+// Utilisation, Saturation and Errors.
+// See https://www.brendangregg.com/usemethod.html
+// and https://www.brendangregg.com/USEmethod/use-linux.html
+package use
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/enescakir/emoji"
+	"github.com/fatih/color"
+	"github.com/sredog/sre/pkg/analysis"
+	"github.com/sredog/sre/pkg/cpu"
+	"github.com/sredog/sre/pkg/disk"
+	"github.com/sredog/sre/pkg/format"
+	"github.com/sredog/sre/pkg/fsroot"
+	"github.com/sredog/sre/pkg/loadavg"
+	"github.com/sredog/sre/pkg/memory"
+	"github.com/sredog/sre/pkg/network"
+)
+
+// Metric is a single cell of the USE table
+type Metric struct {
+	// Value is the human readable value
+	Value string
+	// Level between 0 and 1 says how worrying the value is
+	Level float64
+	// Source is the file the value was computed from
+	Source string
+}
+
+// notAvailable marks the cells Linux has no counters for
+var notAvailable = &Metric{Value: "-"}
+
+// Row holds the USE metrics of a single resource
+type Row struct {
+	Resource    string
+	Utilisation *Metric
+	Saturation  *Metric
+	Errors      *Metric
+	Saturated   bool
+	HasErrors   bool
+}
+
+type USEProbe struct {
+	Rows     []*Row
+	Interval time.Duration
+}
+
+// NewUSEProbe reuses the memory and load average probes, and samples the
+// counters of the remaining resources, CPU included, over the interval
+func NewUSEProbe(ctx context.Context, roots *fsroot.Roots, interval time.Duration, mp *memory.MemoryProbe, la *loadavg.LoadAverageProbe) (*USEProbe, error) {
+	before, after, err := takeSamples(ctx, roots, interval)
+	if err != nil {
+		return nil, err
+	}
+	cp := cpu.NewCPUProbeFromSample(&cpu.Sample{
+		Before:   &before.Stat,
+		After:    &after.Stat,
+		Interval: interval,
+		Elapsed:  after.At.Sub(before.At),
+	})
+	elapsed := after.At.Sub(before.At).Seconds()
+	p := &USEProbe{
+		Interval: interval,
+	}
+	p.Rows = append(p.Rows, cpuRow(roots, cp, la))
+	p.Rows = append(p.Rows, memoryRow(roots, mp, before, after, elapsed))
+	disks := diskRows(roots, before, after)
+	for _, d := range disks {
+		p.Rows = append(p.Rows, d.Row)
+	}
+	p.Rows = append(p.Rows, controllerRows(roots, disks)...)
+	p.Rows = append(p.Rows, networkRows(roots, before, after)...)
+	return p, nil
+}
+
+func percent(ratio float64) string {
+	return fmt.Sprintf("%0.2f%%", ratio*100)
+}
+
+func flag(condition bool) float64 {
+	if condition {
+		return 1
+	}
+	return 0
+}
+
+func cpuRow(roots *fsroot.Roots, cp *cpu.CPUProbe, la *loadavg.LoadAverageProbe) *Row {
+	cpus := float64(len(cp.Stat.CPU))
+	perCPU := la.L.Load1 / cpus
+	saturated := perCPU > 1
+	return &Row{
+		Resource: "CPU",
+		Utilisation: &Metric{
+			Value:  percent(cp.Utilization()),
+			Level:  cp.Utilization(),
+			Source: roots.ProcPath("stat"),
+		},
+		Saturation: &Metric{
+			Value:  fmt.Sprintf("load %0.2f/CPU", perCPU),
+			Level:  flag(saturated),
+			Source: roots.ProcPath("loadavg"),
+		},
+		Errors:    notAvailable,
+		Saturated: saturated,
+	}
+}
+
+func memoryRow(roots *fsroot.Roots, mp *memory.MemoryProbe, before, after *sample, elapsed float64) *Row {
+	delta := func(key string) float64 {
+		return float64(after.VMStat[key]-before.VMStat[key]) / elapsed
+	}
+	swapping := delta("pswpin") + delta("pswpout")
+	directScan := delta("pgscan_direct")
+	saturated := swapping > 0 || directScan > 0
+	// the counter is cumulative, an OOM kill long ago isn't an error now
+	ooms := counterDelta(before.VMStat["oom_kill"], after.VMStat["oom_kill"])
+	return &Row{
+		Resource: "Memory",
+		Utilisation: &Metric{
+			Value:  percent(mp.Utilization()),
+			Level:  mp.Utilization(),
+			Source: roots.ProcPath("meminfo"),
+		},
+		Saturation: &Metric{
+			Value:  fmt.Sprintf("swap %0.0f/s, direct scan %0.0f/s", swapping, directScan),
+			Level:  flag(saturated),
+			Source: roots.ProcPath("vmstat"),
+		},
+		Errors: &Metric{
+			Value:  fmt.Sprintf("%d OOM kills (%d since boot)", ooms, after.VMStat["oom_kill"]),
+			Level:  flag(ooms > 0),
+			Source: roots.ProcPath("vmstat"),
+		},
+		Saturated: saturated,
+		HasErrors: ooms > 0,
+	}
+}
+
+// counterDelta returns how much a cumulative counter increased, 0 if it was reset
+func counterDelta(before, after uint64) uint64 {
+	if after < before {
+		return 0
+	}
+	return after - before
+}
+
+type diskRow struct {
+	*Row
+	Controller  string
+	Throughput  float64
+	QueueSize   float64
+	IOErrors    uint64
+	Utilisation float64
+}
+
+func diskRows(roots *fsroot.Roots, before, after *sample) (rows []*diskRow) {
+	names := make([]string, 0, len(after.Disks))
+	for name := range after.Disks {
+		if _, ok := before.Disks[name]; ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		d := disk.NewDevice(name, before.Disks[name], after.Disks[name], after.At.Sub(before.At))
+		utilisation, queue, throughput := d.Utilization, d.QueueSize, d.Throughput()
+		saturated := queue > 1
+		row := &diskRow{
+			Row: &Row{
+				Resource: "Disk " + name,
+				Utilisation: &Metric{
+					Value:  percent(utilisation),
+					Level:  utilisation,
+					Source: roots.ProcPath("diskstats"),
+				},
+				Saturation: &Metric{
+					Value:  fmt.Sprintf("avg queue %0.2f", queue),
+					Level:  flag(saturated),
+					Source: roots.ProcPath("diskstats"),
+				},
+				Errors:    notAvailable,
+				Saturated: saturated,
+			},
+			Controller:  diskController(roots, name),
+			Throughput:  throughput,
+			QueueSize:   queue,
+			Utilisation: utilisation,
+		}
+		errorsBefore, okBefore := before.IOErrors[name]
+		errorsAfter, okAfter := after.IOErrors[name]
+		if okBefore && okAfter {
+			errors := counterDelta(errorsBefore, errorsAfter)
+			row.IOErrors = errors
+			row.HasErrors = errors > 0
+			row.Errors = &Metric{
+				Value:  fmt.Sprintf("%d I/O errors (%d since boot)", errors, errorsAfter),
+				Level:  flag(errors > 0),
+				Source: roots.SysPath("block", name, "device", "ioerr_cnt"),
+			}
+		}
+		rows = append(rows, row)
+	}
+	return
+}
+
+// controllerRows aggregates the disks by the controller they hang off
+func controllerRows(roots *fsroot.Roots, disks []*diskRow) (rows []*Row) {
+	byController := make(map[string][]*diskRow)
+	var controllers []string
+	for _, d := range disks {
+		if d.Controller == "" {
+			continue
+		}
+		if _, ok := byController[d.Controller]; !ok {
+			controllers = append(controllers, d.Controller)
+		}
+		byController[d.Controller] = append(byController[d.Controller], d)
+	}
+	sort.Strings(controllers)
+	for _, controller := range controllers {
+		var throughput, queue, busiest float64
+		var errors uint64
+		hasErrorCounters := false
+		disks := byController[controller]
+		for _, d := range disks {
+			throughput += d.Throughput
+			queue += d.QueueSize
+			if d.Utilisation > busiest {
+				busiest = d.Utilisation
+			}
+			if d.Errors != notAvailable {
+				hasErrorCounters = true
+				errors += d.IOErrors
+			}
+		}
+		// a controller is saturated when it queues more than one request per disk
+		saturated := queue > float64(len(disks))
+		row := &Row{
+			Resource: "Controller " + controller,
+			Utilisation: &Metric{
+				Value:  fmt.Sprintf("%s/s, busiest disk %s", humanize.Bytes(uint64(throughput)), percent(busiest)),
+				Level:  busiest,
+				Source: roots.ProcPath("diskstats"),
+			},
+			Saturation: &Metric{
+				Value:  fmt.Sprintf("avg queue %0.2f", queue),
+				Level:  flag(saturated),
+				Source: roots.ProcPath("diskstats"),
+			},
+			Errors:    notAvailable,
+			Saturated: saturated,
+		}
+		if hasErrorCounters {
+			row.HasErrors = errors > 0
+			row.Errors = &Metric{
+				Value:  fmt.Sprintf("%d I/O errors", errors),
+				Level:  flag(errors > 0),
+				Source: roots.SysPath("block", "*", "device", "ioerr_cnt"),
+			}
+		}
+		rows = append(rows, row)
+	}
+	return
+}
+
+func networkRows(roots *fsroot.Roots, before, after *sample) (rows []*Row) {
+	probe := network.NewNetworkProbeFromSample(roots, &network.Sample{
+		Before:   before.Net,
+		After:    after.Net,
+		Interval: after.At.Sub(before.At),
+		Elapsed:  after.At.Sub(before.At),
+	})
+	for _, i := range probe.Interfaces {
+		utilisation := &Metric{
+			Value:  fmt.Sprintf("rx %s/s, tx %s/s", humanize.Bytes(uint64(i.RxBytes)), humanize.Bytes(uint64(i.TxBytes))),
+			Source: roots.ProcPath("net", "dev"),
+		}
+		if i.Speed > 0 {
+			utilisation.Level = i.Utilization()
+			utilisation.Value = fmt.Sprintf("%s of %d Mbit/s", percent(utilisation.Level), i.Speed)
+			utilisation.Source = roots.SysPath("class", "net", i.Name, "speed")
+		}
+		rows = append(rows, &Row{
+			Resource:    "Net " + i.Name,
+			Utilisation: utilisation,
+			Saturation: &Metric{
+				Value:  fmt.Sprintf("%0.0f drops+overruns/s", i.Drops()),
+				Level:  flag(i.Drops() > 0),
+				Source: roots.ProcPath("net", "dev"),
+			},
+			Errors: &Metric{
+				Value:  fmt.Sprintf("%0.0f errors/s", i.Errors()),
+				Level:  flag(i.Errors() > 0),
+				Source: roots.ProcPath("net", "dev"),
+			},
+			Saturated: i.Drops() > 0,
+			HasErrors: i.Errors() > 0,
+		})
+	}
+	return
+}
+
+func pad(text string, width int) string {
+	return text + strings.Repeat(" ", width-len(text))
+}
+
+func (p *USEProbe) Display() string {
+	bold := color.New(color.Bold)
+	headers := []string{"Resource", "Utilisation", "Saturation", "Errors"}
+	widths := make([]int, len(headers))
+	for i, h := range headers {
+		widths[i] = len(h)
+	}
+	for _, row := range p.Rows {
+		for i, cell := range []string{row.Resource, row.Utilisation.Value, row.Saturation.Value, row.Errors.Value} {
+			if len(cell) > widths[i] {
+				widths[i] = len(cell)
+			}
+		}
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%v USE method over %v\n", emoji.Microscope, bold.Sprint(p.Interval))
+	for i, h := range headers {
+		sb.WriteString(bold.Sprint(pad(h, widths[i])) + "  ")
+	}
+	sb.WriteString("\n")
+	for _, row := range p.Rows {
+		sb.WriteString(pad(row.Resource, widths[0]) + "  ")
+		for i, m := range []*Metric{row.Utilisation, row.Saturation, row.Errors} {
+			textColor := format.ColorForUtilization(m.Level, 0.95, 0.85, 0.5)
+			sb.WriteString(textColor.Sprint(pad(m.Value, widths[i+1])) + "  ")
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+func (p *USEProbe) Serialize() interface{} {
+	return p
+}
+
+func (p *USEProbe) Analysis() (observations []*analysis.Observation) {
+	for _, row := range p.Rows {
+		if row.Saturated {
+			observations = append(observations, &analysis.Observation{
+				Type:    analysis.Issue,
+				Message: fmt.Sprintf("%s is saturated: %s (from %s)", row.Resource, row.Saturation.Value, row.Saturation.Source),
+			})
+		}
+		if row.HasErrors {
+			observations = append(observations, &analysis.Observation{
+				Type:    analysis.Issue,
+				Message: fmt.Sprintf("%s is showing errors: %s (from %s)", row.Resource, row.Errors.Value, row.Errors.Source),
+			})
+		}
+	}
+	observations = append(observations, &analysis.Observation{
+		Type:    analysis.Learn,
+		Message: "Learn more about the USE method https://www.brendangregg.com/usemethod.html",
+	})
+	return
+}
@@ -0,0 +1,172 @@
+package use
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/procfs"
+	"github.com/prometheus/procfs/blockdevice"
+	"github.com/sredog/sre/pkg/cpu"
+	"github.com/sredog/sre/pkg/fsroot"
+	"github.com/sredog/sre/pkg/loadavg"
+	"github.com/sredog/sre/pkg/memory"
+)
+
+func kB(v uint64) *uint64 {
+	return &v
+}
+
+func testRoots(t *testing.T) *fsroot.Roots {
+	t.Helper()
+	return &fsroot.Roots{Proc: t.TempDir(), Sys: t.TempDir()}
+}
+
+// writeIOErrors gives the disk an ioerr_cnt in the sysfs of roots
+func writeIOErrors(t *testing.T, roots *fsroot.Roots, name, content string) {
+	t.Helper()
+	dir := roots.SysPath("block", name, "device")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "ioerr_cnt"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDiskIOErrors(t *testing.T) {
+	roots := testRoots(t)
+	writeIOErrors(t, roots, "sda", "0x2\n")
+	writeIOErrors(t, roots, "sdb", "garbage\n")
+	for name, expected := range map[string]struct {
+		errors uint64
+		ok     bool
+	}{
+		"sda":     {2, true},
+		"sdb":     {0, false},
+		"nvme0n1": {0, false},
+	} {
+		if errors, ok := diskIOErrors(roots, name); errors != expected.errors || ok != expected.ok {
+			t.Errorf("Expected %d, %v for %s, got %d, %v", expected.errors, expected.ok, name, errors, ok)
+		}
+	}
+}
+
+func TestCPURow(t *testing.T) {
+	before := &procfs.Stat{CPUTotal: procfs.CPUStat{User: 100, Idle: 100}, CPU: make([]procfs.CPUStat, 2)}
+	after := &procfs.Stat{CPUTotal: procfs.CPUStat{User: 250, Idle: 150}, CPU: make([]procfs.CPUStat, 2)}
+	cp := cpu.NewCPUProbeFromSample(&cpu.Sample{Before: before, After: after, Interval: time.Second, Elapsed: time.Second})
+	row := cpuRow(testRoots(t), cp, &loadavg.LoadAverageProbe{L: &procfs.LoadAvg{Load1: 3}})
+	if row.Utilisation.Value != "75.00%" {
+		t.Errorf("Expected 75%% utilisation, got %s", row.Utilisation.Value)
+	}
+	if !row.Saturated || row.Saturation.Value != "load 1.50/CPU" {
+		t.Errorf("Expected a load of 1.5 per CPU to saturate, got %s", row.Saturation.Value)
+	}
+	if row.Errors != notAvailable {
+		t.Errorf("Expected no CPU error counters, got %+v", row.Errors)
+	}
+}
+
+func TestMemoryRow(t *testing.T) {
+	mp := &memory.MemoryProbe{Meminfo: &procfs.Meminfo{MemTotal: kB(1000), MemAvailable: kB(250)}}
+	for _, tc := range []struct {
+		name          string
+		before, after map[string]uint64
+		saturated     bool
+		errors        string
+	}{
+		{
+			name:   "idle",
+			before: map[string]uint64{"oom_kill": 3},
+			after:  map[string]uint64{"oom_kill": 3},
+			errors: "0 OOM kills (3 since boot)",
+		},
+		{
+			name:      "swapping and killing",
+			before:    map[string]uint64{"pswpin": 10, "oom_kill": 3},
+			after:     map[string]uint64{"pswpin": 30, "pgscan_direct": 10, "oom_kill": 5},
+			saturated: true,
+			errors:    "2 OOM kills (5 since boot)",
+		},
+		{
+			name:   "counter reset",
+			before: map[string]uint64{"oom_kill": 3},
+			after:  map[string]uint64{"oom_kill": 1},
+			errors: "0 OOM kills (1 since boot)",
+		},
+	} {
+		row := memoryRow(testRoots(t), mp, &sample{VMStat: tc.before}, &sample{VMStat: tc.after}, 2)
+		if row.Utilisation.Value != "75.00%" {
+			t.Errorf("%s: expected 75%% utilisation, got %s", tc.name, row.Utilisation.Value)
+		}
+		if row.Saturated != tc.saturated {
+			t.Errorf("%s: expected saturated to be %v, got %s", tc.name, tc.saturated, row.Saturation.Value)
+		}
+		if row.Errors.Value != tc.errors || row.HasErrors != (row.Errors.Level > 0) {
+			t.Errorf("%s: expected %q, got %q", tc.name, tc.errors, row.Errors.Value)
+		}
+	}
+}
+
+func TestDiskRows(t *testing.T) {
+	roots := testRoots(t)
+	now := time.Now()
+	before := &sample{
+		At: now,
+		Disks: map[string]blockdevice.IOStats{
+			"sda": {IOsTotalTicks: 1000, WeightedIOTicks: 1000},
+			"sdb": {},
+			"vda": {},
+		},
+		IOErrors: map[string]uint64{"sda": 1, "sdb": 5},
+	}
+	after := &sample{
+		At: now.Add(time.Second),
+		Disks: map[string]blockdevice.IOStats{
+			"sda": {IOsTotalTicks: 1500, WeightedIOTicks: 3000},
+			"sdb": {},
+			"vda": {},
+			// plugged in while sampling
+			"sdc": {},
+		},
+		IOErrors: map[string]uint64{"sda": 3, "sdb": 0},
+	}
+	rows := diskRows(roots, before, after)
+	if len(rows) != 3 {
+		t.Fatalf("Expected the 3 disks in both samples, got %d", len(rows))
+	}
+	sda, sdb, vda := rows[0], rows[1], rows[2]
+	if sda.Row.Utilisation.Value != "50.00%" || !sda.Saturated || sda.Saturation.Value != "avg queue 2.00" {
+		t.Errorf("Expected sda 50%% utilised with a queue of 2, got %s and %s", sda.Row.Utilisation.Value, sda.Saturation.Value)
+	}
+	if !sda.HasErrors || sda.IOErrors != 2 || sda.Errors.Value != "2 I/O errors (3 since boot)" {
+		t.Errorf("Expected 2 errors on sda, got %s", sda.Errors.Value)
+	}
+	// the counter went back, e.g. the device was reset
+	if sdb.HasErrors || sdb.Errors.Value != "0 I/O errors (0 since boot)" {
+		t.Errorf("Expected no errors on sdb, got %s", sdb.Errors.Value)
+	}
+	// virtio disks have no ioerr_cnt
+	if vda.HasErrors || vda.Errors != notAvailable {
+		t.Errorf("Expected no error counter for vda, got %+v", vda.Errors)
+	}
+
+	sda.Controller, sdb.Controller, vda.Controller = "host0", "host0", "vda"
+	controllers := controllerRows(roots, rows)
+	if len(controllers) != 2 {
+		t.Fatalf("Expected 2 controllers, got %d", len(controllers))
+	}
+	host0, virtio := controllers[0], controllers[1]
+	if host0.Resource != "Controller host0" || !host0.HasErrors || host0.Errors.Value != "2 I/O errors" {
+		t.Errorf("Expected the errors of sda on host0, got %s: %s", host0.Resource, host0.Errors.Value)
+	}
+	// a queue of 2 over 2 disks is one request each
+	if host0.Saturated {
+		t.Errorf("Expected host0 not to be saturated, got %s", host0.Saturation.Value)
+	}
+	if virtio.Errors != notAvailable {
+		t.Errorf("Expected no error counters for vda, got %+v", virtio.Errors)
+	}
+}
@@ -0,0 +1,321 @@
+// Package vmstat samples the virtual memory counters of /proc/vmstat: paging, swapping,
+// reclaim, compaction and OOM kills, which show thrashing that meminfo doesn't
+// See https://www.kernel.org/doc/Documentation/admin-guide/mm/concepts.rst
+// and https://www.kernel.org/doc/Documentation/admin-guide/sysctl/vm.rst
+package vmstat
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/enescakir/emoji"
+	"github.com/fatih/color"
+	"github.com/sredog/sre/pkg/analysis"
+	"github.com/sredog/sre/pkg/fsroot"
+)
+
+// ReadVMStat parses the "name value" pairs of /proc/vmstat
+func ReadVMStat(path string) (map[string]uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	stats := make(map[string]uint64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		val, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse %s in %s: %w", fields[0], path, err)
+		}
+		stats[fields[0]] = val
+	}
+	return stats, scanner.Err()
+}
+
+// Sample is /proc/vmstat before and after the interval
+type Sample analysis.Sample[map[string]uint64]
+
+// TakeSample samples /proc/vmstat, shared by the memory and vmstat probes through SharedSample
+func TakeSample(ctx context.Context, roots *fsroot.Roots, interval time.Duration) (*Sample, error) {
+	s, err := analysis.TakeSample(ctx, roots.Proc, interval, func() (map[string]uint64, error) {
+		return ReadVMStat(roots.ProcPath("vmstat"))
+	})
+	return (*Sample)(s), err
+}
+
+// SharedSample reads /proc/vmstat once per environment, for both the vmstat probe and the
+// memory activity of the memory probe
+func SharedSample(ctx context.Context, env *analysis.Environment) (*Sample, error) {
+	v, err := env.Shared("vmstat.Sample", func() (interface{}, error) {
+		return TakeSample(ctx, env.Roots, env.Interval)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*Sample), nil
+}
+
+// zones split some counters on kernels before 4.8, e.g. pgscan_kswapd_normal
+var zones = []string{"dma", "dma32", "normal", "high", "movable"}
+
+// total returns the counter, or the sum of its zones on older kernels
+func total(stats map[string]uint64, name string) uint64 {
+	if v, ok := stats[name]; ok {
+		return v
+	}
+	var sum uint64
+	for _, zone := range zones {
+		sum += stats[name+"_"+zone]
+	}
+	return sum
+}
+
+// Delta returns how much the counter increased over the sample
+func (s *Sample) Delta(name string) uint64 {
+	after, before := total(s.After, name), total(s.Before, name)
+	if after < before {
+		return 0
+	}
+	return after - before
+}
+
+// Rate returns the increase of the counter per second
+func (s *Sample) Rate(name string) float64 {
+	return float64(s.Delta(name)) / s.Elapsed.Seconds()
+}
+
+// Rates are per second, of pages unless said otherwise
+type Rates struct {
+	SwapIn  float64
+	SwapOut float64
+	// MajorFaults are page faults that had to wait on the disk
+	MajorFaults float64
+	// Scan are the pages looked at to reclaim by kswapd in the background, and by allocating
+	// processes stalled in direct reclaim. Steal are those reclaimed.
+	ScanKswapd  float64
+	ScanDirect  float64
+	StealKswapd float64
+	StealDirect float64
+	// CompactStalls are allocations stalled compacting memory for contiguous pages
+	CompactStalls float64
+	// THPFallbacks are faults that wanted a transparent huge page and got small ones
+	THPFallbacks float64
+	// OOMKills is the number of processes killed over the sample, since Linux 4.13
+	OOMKills uint64
+}
+
+// NewRates computes the rates over the sample
+func NewRates(s *Sample) Rates {
+	return Rates{
+		SwapIn:        s.Rate("pswpin"),
+		SwapOut:       s.Rate("pswpout"),
+		MajorFaults:   s.Rate("pgmajfault"),
+		ScanKswapd:    s.Rate("pgscan_kswapd"),
+		ScanDirect:    s.Rate("pgscan_direct"),
+		StealKswapd:   s.Rate("pgsteal_kswapd"),
+		StealDirect:   s.Rate("pgsteal_direct"),
+		CompactStalls: s.Rate("compact_stall"),
+		THPFallbacks:  s.Rate("thp_fault_fallback"),
+		OOMKills:      s.Delta("oom_kill"),
+	}
+}
+
+// DirectShare returns the share of the scanning done in direct reclaim
+func (r *Rates) DirectShare() float64 {
+	if r.ScanKswapd+r.ScanDirect == 0 {
+		return 0
+	}
+	return r.ScanDirect / (r.ScanKswapd + r.ScanDirect)
+}
+
+// Efficiency returns the share of the pages scanned that were reclaimed, 1 without scanning
+func (r *Rates) Efficiency() float64 {
+	scanned := r.ScanKswapd + r.ScanDirect
+	if scanned == 0 {
+		return 1
+	}
+	return (r.StealKswapd + r.StealDirect) / scanned
+}
+
+type VMStatProbe struct {
+	Rates
+	Interval time.Duration
+}
+
+// NewVMStatProbe reads /proc/vmstat twice, interval apart, or once for the rates since boot
+func NewVMStatProbe(ctx context.Context, roots *fsroot.Roots, interval time.Duration) (*VMStatProbe, error) {
+	s, err := TakeSample(ctx, roots, interval)
+	if err != nil {
+		return nil, err
+	}
+	return NewVMStatProbeFromSample(s), nil
+}
+
+// NewVMStatProbeFromSample works out the paging, reclaim and OOM kill rates of the sample
+func NewVMStatProbeFromSample(s *Sample) *VMStatProbe {
+	return &VMStatProbe{
+		Rates:    NewRates(s),
+		Interval: s.Interval,
+	}
+}
+
+func init() {
+	analysis.Register(&analysis.ProbeConfiguration{
+		ID:          "vmstat",
+		Aliases:     []string{"paging", "reclaim"},
+		Description: "Swapping, major faults, page reclaim, compaction stalls and OOM kills over the interval",
+		Build: func(ctx context.Context, env *analysis.Environment) (analysis.Probe, error) {
+			s, err := SharedSample(ctx, env)
+			if err != nil {
+				return nil, err
+			}
+			return NewVMStatProbeFromSample(s), nil
+		},
+	})
+}
+
+// Period says what the rates were averaged over
+func (p *VMStatProbe) Period() string {
+	if p.Interval == 0 {
+		return "since boot"
+	}
+	return fmt.Sprintf("over %v", p.Interval)
+}
+
+// Summary is a line about the paging activity, for the display of the memory probe
+func (p *VMStatProbe) Summary() string {
+	bold := color.New(color.Bold)
+	return fmt.Sprintf("Paging %s: swap in/out %v/%v pages/s, major faults %v/s, reclaim scanned %v pages/s (%v direct)",
+		p.Period(),
+		bold.Sprintf("%0.1f", p.SwapIn),
+		bold.Sprintf("%0.1f", p.SwapOut),
+		bold.Sprintf("%0.1f", p.MajorFaults),
+		bold.Sprintf("%0.1f", p.ScanKswapd+p.ScanDirect),
+		bold.Sprintf("%0.0f%%", p.DirectShare()*100),
+	)
+}
+
+const displayFormat = `%v Virtual memory %v
+Swap in: %0.1f pages/s, swap out: %0.1f pages/s, major faults: %0.1f/s
+Reclaim scanned by kswapd: %0.1f pages/s, direct: %0.1f pages/s, reclaimed: %0.0f%%
+Compaction stalls: %0.1f/s, THP fault fallbacks: %0.1f/s, OOM kills: %d
+`
+
+func (p *VMStatProbe) Display() string {
+	return fmt.Sprintf(displayFormat,
+		emoji.RecyclingSymbol,
+		p.Period(),
+		p.SwapIn, p.SwapOut, p.MajorFaults,
+		p.ScanKswapd, p.ScanDirect, p.Efficiency()*100,
+		p.CompactStalls, p.THPFallbacks, p.OOMKills,
+	)
+}
+
+func (p *VMStatProbe) Serialize() interface{} {
+	return struct {
+		IntervalSeconds float64
+		Rates
+		DirectShare float64
+		Efficiency  float64
+	}{
+		IntervalSeconds: p.Interval.Seconds(),
+		Rates:           p.Rates,
+		DirectShare:     p.DirectShare(),
+		Efficiency:      p.Efficiency(),
+	}
+}
+
+// Thresholds in pages or events per second
+const (
+	swapInStorm       = 1000
+	swapInWarning     = 100
+	swapOutWarning    = 1000
+	directReclaimRate = 1000
+	majorFaultRate    = 1000
+)
+
+// SinceBoot tells whether the rates are averaged since boot
+func (p *VMStatProbe) SinceBoot() bool {
+	return p.Interval == 0
+}
+
+func (p *VMStatProbe) Analysis() (observations []*analysis.Observation) {
+	// since boot, what happened once long ago is worth a note, not a warning
+	sinceBoot := p.SinceBoot()
+	rated := func(t analysis.ObservationType) analysis.ObservationType {
+		if sinceBoot {
+			return analysis.Note
+		}
+		return t
+	}
+	switch {
+	case p.SwapIn >= swapInStorm:
+		observations = append(observations, &analysis.Observation{
+			Type:    rated(analysis.Issue),
+			Message: fmt.Sprintf("Swapping in %0.0f pages/s %s (out %0.0f/s) - the working set doesn't fit in memory and processes wait on the disk", p.SwapIn, p.Period(), p.SwapOut),
+			See:     "vmstat",
+		})
+	case p.SwapIn >= swapInWarning:
+		observations = append(observations, &analysis.Observation{
+			Type:    rated(analysis.Warning),
+			Message: fmt.Sprintf("Swapping in %0.0f pages/s %s (out %0.0f/s)", p.SwapIn, p.Period(), p.SwapOut),
+			See:     "vmstat",
+		})
+	case p.SwapOut >= swapOutWarning:
+		observations = append(observations, &analysis.Observation{
+			Type:    rated(analysis.Warning),
+			Message: fmt.Sprintf("Swapping out %0.0f pages/s %s - memory is short, pages get pushed out", p.SwapOut, p.Period()),
+			See:     "vmstat",
+		})
+	}
+	if p.ScanDirect > 0 {
+		t := analysis.Warning
+		if p.ScanDirect >= directReclaimRate && p.DirectShare() >= 0.5 {
+			t = analysis.Issue
+		}
+		observations = append(observations, &analysis.Observation{
+			Type: rated(t),
+			Message: fmt.Sprintf("Allocations stalled in direct reclaim, scanning %0.0f pages/s %s (%0.0f%% of the scanning, kswapd %0.0f/s, %0.0f%% reclaimed) - kswapd doesn't keep up, processes pay for it in latency",
+				p.ScanDirect, p.Period(), p.DirectShare()*100, p.ScanKswapd, p.Efficiency()*100),
+			See: "vmstat",
+		})
+	}
+	if p.MajorFaults >= majorFaultRate {
+		observations = append(observations, &analysis.Observation{
+			Type:    rated(analysis.Warning),
+			Message: fmt.Sprintf("%0.0f major page faults/s %s - pages are read back from disk, the page cache or swap is too small for the working set", p.MajorFaults, p.Period()),
+			See:     "pidstat",
+		})
+	}
+	if p.CompactStalls > 0 {
+		observations = append(observations, &analysis.Observation{
+			Type:    rated(analysis.Warning),
+			Message: fmt.Sprintf("%0.1f allocations/s stalled compacting memory %s - memory is fragmented, higher-order allocations wait", p.CompactStalls, p.Period()),
+			See:     "vmstat",
+		})
+	}
+	if p.THPFallbacks > 0 {
+		observations = append(observations, &analysis.Observation{
+			Type:    analysis.Note,
+			Message: fmt.Sprintf("%0.1f transparent huge page faults/s fell back to small pages %s - memory is fragmented", p.THPFallbacks, p.Period()),
+		})
+	}
+	if p.OOMKills > 0 {
+		observations = append(observations, &analysis.Observation{
+			Type:    rated(analysis.Issue),
+			Message: fmt.Sprintf("The OOM killer killed %d process(es) %s - the kernel log says which", p.OOMKills, p.Period()),
+			See:     "dmesg",
+		})
+	}
+	return
+}
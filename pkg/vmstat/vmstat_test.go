@@ -0,0 +1,53 @@
+package vmstat
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sredog/sre/pkg/analysis"
+)
+
+func TestThrashing(t *testing.T) {
+	s := &Sample{
+		Before: map[string]uint64{
+			"pswpin": 1000, "pswpout": 1000, "pgmajfault": 500,
+			// before 4.8 reclaim is counted per zone
+			"pgscan_kswapd_normal": 1000, "pgscan_kswapd_dma32": 1000, "pgscan_direct_normal": 0,
+			"pgsteal_kswapd_normal": 1000, "pgsteal_direct_normal": 0,
+			"oom_kill": 1,
+		},
+		After: map[string]uint64{
+			"pswpin": 6000, "pswpout": 3000, "pgmajfault": 3500,
+			"pgscan_kswapd_normal": 2000, "pgscan_kswapd_dma32": 2000, "pgscan_direct_normal": 4000,
+			"pgsteal_kswapd_normal": 2000, "pgsteal_direct_normal": 1000,
+			"oom_kill": 2,
+		},
+		Interval: 2 * time.Second,
+		Elapsed:  2 * time.Second,
+	}
+	p := NewVMStatProbeFromSample(s)
+	if p.SwapIn != 2500 || p.ScanKswapd != 1000 || p.ScanDirect != 2000 || p.OOMKills != 1 {
+		t.Errorf("Unexpected rates %+v", p.Rates)
+	}
+	if p.DirectShare() < 0.66 || p.DirectShare() > 0.67 || p.Efficiency() < 0.33 || p.Efficiency() > 0.34 {
+		t.Errorf("Expected 2/3 of the scanning direct and 1/3 of it reclaimed, got %0.2f and %0.2f", p.DirectShare(), p.Efficiency())
+	}
+	issues := 0
+	for _, o := range p.Analysis() {
+		if o.Type == analysis.Issue {
+			issues++
+		}
+	}
+	// the swap-in storm, the direct reclaim and the OOM kill
+	if issues != 3 {
+		t.Errorf("Expected 3 issues, got %+v", p.Analysis())
+	}
+
+	// since boot the same counters are only worth notes
+	s.Before, s.Interval = nil, 0
+	for _, o := range NewVMStatProbeFromSample(s).Analysis() {
+		if o.Type > analysis.Note {
+			t.Errorf("Expected no warnings since boot, got %+v", o)
+		}
+	}
+}